@@ -0,0 +1,228 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	berror "github.com/beego/beego-error/v2"
+)
+
+const (
+	defaultBatchLoadWindow   = 2 * time.Millisecond
+	defaultBatchLoadMaxBatch = 100
+)
+
+// BatchLoadCacheOption configures the BatchLoadCache
+type BatchLoadCacheOption func(c *BatchLoadCache)
+
+// WithBatchLoadCacheWindow configures how long the missed keys of Get are collected into one batch,
+// default 2ms.
+func WithBatchLoadCacheWindow(window time.Duration) BatchLoadCacheOption {
+	return func(c *BatchLoadCache) {
+		c.window = window
+	}
+}
+
+// WithBatchLoadCacheMaxBatch configures the max number of keys of a batch, default 100.
+// A batch is loaded at once when it is full.
+func WithBatchLoadCacheMaxBatch(n int) BatchLoadCacheOption {
+	return func(c *BatchLoadCache) {
+		c.maxBatch = n
+	}
+}
+
+// BatchLoadCache is a read through decorator loading the missed keys in batches, like a DataLoader.
+// GetMulti loads its missed keys in one call, and the missed keys of the concurrent Get
+// within a small time window are loaded in one call too.
+//
+// The keys absent from the result of loadFunc don't exist, ErrKeyNotExist is returned for them.
+type BatchLoadCache struct {
+	Cache
+	expiration time.Duration
+	loadFunc   func(ctx context.Context, keys []string) (map[string]any, error)
+	window     time.Duration
+	maxBatch   int
+
+	mu      sync.Mutex
+	pending *loadBatch
+}
+
+// loadBatch is the keys missed by Get and collected in a window.
+type loadBatch struct {
+	ctx  context.Context
+	keys []string
+	// closed when the batch is loaded
+	done chan struct{}
+	vals map[string]any
+	// the error of loading, or the errors of writing back per key
+	err     error
+	putErrs map[string]error
+}
+
+// NewBatchLoadCache creates BatchLoadCache, expiration is the TTL of the loaded values
+func NewBatchLoadCache(c Cache, expiration time.Duration,
+	loadFunc func(ctx context.Context, keys []string) (map[string]any, error), opts ...BatchLoadCacheOption,
+) (*BatchLoadCache, error) {
+	if loadFunc == nil {
+		return nil, berror.Error(InvalidLoadFunc, "loadFunc cannot be nil")
+	}
+	res := &BatchLoadCache{
+		Cache:      c,
+		expiration: expiration,
+		loadFunc:   loadFunc,
+		window:     defaultBatchLoadWindow,
+		maxBatch:   defaultBatchLoadMaxBatch,
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	if res.window < 0 || res.maxBatch <= 0 {
+		return nil, berror.Errorf(InvalidInitParameters,
+			"invalid batch window %v or max batch %d", res.window, res.maxBatch)
+	}
+	return res, nil
+}
+
+// Get loads key with the other keys missed in the window if it is missing.
+// The caller stops waiting when ctx is done, while the batch is still loaded for the others.
+func (c *BatchLoadCache) Get(ctx context.Context, key string) (any, error) {
+	val, err := c.Cache.Get(ctx, key)
+	if val != nil && err == nil {
+		return val, nil
+	}
+
+	b := c.enqueue(ctx, key)
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if b.err != nil {
+		return nil, b.err
+	}
+	val, ok := b.vals[key]
+	if !ok {
+		return nil, ErrKeyNotExist
+	}
+	return val, b.putErrs[key]
+}
+
+// GetMulti loads the missed keys in one call.
+// Like MemoryCache, a MultiGetFailed error lists the keys which don't exist.
+func (c *BatchLoadCache) GetMulti(ctx context.Context, keys []string) ([]any, error) {
+	vals, _ := c.Cache.GetMulti(ctx, keys)
+	if len(vals) != len(keys) {
+		vals = make([]any, len(keys))
+	}
+	missed := make([]string, 0, len(keys))
+	missedIdx := make([]int, 0, len(keys))
+	for i, val := range vals {
+		if val == nil {
+			missed = append(missed, keys[i])
+			missedIdx = append(missedIdx, i)
+		}
+	}
+	if len(missed) == 0 {
+		return vals, nil
+	}
+
+	loaded, err := c.loadFunc(ctx, missed)
+	if err != nil {
+		return vals, berror.Wrap(err, LoadFuncFailed, "cache unable to load data")
+	}
+	keysErr := make([]string, 0)
+	for i, key := range missed {
+		val, ok := loaded[key]
+		if !ok {
+			keysErr = append(keysErr, fmt.Sprintf("key [%s] error: %s", key, ErrKeyNotExist.Error()))
+			continue
+		}
+		vals[missedIdx[i]] = val
+		if err = c.Cache.Put(ctx, key, val, c.expiration); err != nil {
+			keysErr = append(keysErr, fmt.Sprintf("key [%s] error: %s", key, err.Error()))
+		}
+	}
+	if len(keysErr) == 0 {
+		return vals, nil
+	}
+	return vals, berror.Error(MultiGetFailed, strings.Join(keysErr, "; "))
+}
+
+// enqueue adds key to the pending batch, and returns the batch.
+func (c *BatchLoadCache) enqueue(ctx context.Context, key string) *loadBatch {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b := c.pending
+	if b == nil {
+		b = &loadBatch{
+			ctx:  ctx,
+			done: make(chan struct{}),
+		}
+		c.pending = b
+		time.AfterFunc(c.window, func() {
+			c.dispatch(b)
+		})
+	}
+	for _, k := range b.keys {
+		if k == key {
+			return b
+		}
+	}
+	b.keys = append(b.keys, key)
+	if len(b.keys) >= c.maxBatch {
+		c.pending = nil
+		go c.load(b)
+	}
+	return b
+}
+
+// dispatch loads b when the window is over, unless it has been loaded because it is full.
+func (c *BatchLoadCache) dispatch(b *loadBatch) {
+	c.mu.Lock()
+	if c.pending != b {
+		c.mu.Unlock()
+		return
+	}
+	c.pending = nil
+	c.mu.Unlock()
+	c.load(b)
+}
+
+// load loads b and writes the values back.
+// loadFunc uses a context which keeps the values of the first caller but is never canceled,
+// because the batch is shared.
+func (c *BatchLoadCache) load(b *loadBatch) {
+	defer close(b.done)
+	ctx := detachedContext{Context: b.ctx}
+	vals, err := c.loadFunc(ctx, b.keys)
+	if err != nil {
+		b.err = berror.Wrap(err, LoadFuncFailed, "cache unable to load data")
+		return
+	}
+	b.vals = vals
+	b.putErrs = make(map[string]error)
+	for _, key := range b.keys {
+		if val, ok := vals[key]; ok {
+			if err = c.Cache.Put(ctx, key, val, c.expiration); err != nil {
+				b.putErrs[key] = err
+			}
+		}
+	}
+}
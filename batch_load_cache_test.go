@@ -0,0 +1,164 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	berror "github.com/beego/beego-error/v2"
+)
+
+// batchLoader records the keys of every call and loads the keys of db.
+type batchLoader struct {
+	mu    sync.Mutex
+	db    map[string]any
+	calls [][]string
+	err   error
+}
+
+func (l *batchLoader) load(ctx context.Context, keys []string) (map[string]any, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sorted := append([]string(nil), keys...)
+	sort.Strings(sorted)
+	l.calls = append(l.calls, sorted)
+	if l.err != nil {
+		return nil, l.err
+	}
+	res := make(map[string]any, len(keys))
+	for _, key := range keys {
+		if val, ok := l.db[key]; ok {
+			res[key] = val
+		}
+	}
+	return res, nil
+}
+
+func (l *batchLoader) getCalls() [][]string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.calls
+}
+
+func TestBatchLoadCache_Get(t *testing.T) {
+	loader := &batchLoader{db: map[string]any{"key1": "value1", "key2": "value2", "key3": "value3"}}
+	c, err := NewBatchLoadCache(NewMemoryCache(0), time.Minute, loader.load,
+		WithBatchLoadCacheWindow(20*time.Millisecond))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for _, key := range []string{"key1", "key2", "key2", "missing"} {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			val, err := c.Get(ctx, key)
+			if key == "missing" {
+				assert.Equal(t, ErrKeyNotExist, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "value"+key[3:], val)
+		}(key)
+	}
+	wg.Wait()
+	assert.Equal(t, [][]string{{"key1", "key2", "missing"}}, loader.getCalls())
+
+	// written back
+	val, err := c.Get(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, "value1", val)
+	assert.Len(t, loader.getCalls(), 1)
+}
+
+func TestBatchLoadCache_GetMaxBatch(t *testing.T) {
+	loader := &batchLoader{db: map[string]any{"key1": "value1", "key2": "value2"}}
+	c, err := NewBatchLoadCache(NewMemoryCache(0), time.Minute, loader.load,
+		WithBatchLoadCacheWindow(time.Hour), WithBatchLoadCacheMaxBatch(2))
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for _, key := range []string{"key1", "key2"} {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			_, err := c.Get(context.Background(), key)
+			assert.NoError(t, err)
+		}(key)
+	}
+	wg.Wait()
+	assert.Equal(t, [][]string{{"key1", "key2"}}, loader.getCalls())
+}
+
+func TestBatchLoadCache_GetCanceled(t *testing.T) {
+	loader := &batchLoader{db: map[string]any{"key": "value"}}
+	c, err := NewBatchLoadCache(NewMemoryCache(0), time.Minute, loader.load,
+		WithBatchLoadCacheWindow(50*time.Millisecond))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = c.Get(ctx, "key")
+	assert.Equal(t, context.DeadlineExceeded, err)
+	// the batch is still loaded
+	assert.Eventually(t, func() bool {
+		ok, _ := c.IsExist(context.Background(), "key")
+		return ok
+	}, time.Second, 10*time.Millisecond)
+
+	loader.mu.Lock()
+	loader.err = errors.New("db is down")
+	loader.mu.Unlock()
+	_, err = c.Get(context.Background(), "other")
+	code, _ := berror.FromError(err)
+	assert.Equal(t, LoadFuncFailed.Code(), code.Code())
+}
+
+func TestBatchLoadCache_GetMulti(t *testing.T) {
+	loader := &batchLoader{db: map[string]any{"key1": "value1", "key2": "value2", "key3": "value3"}}
+	mc := NewMemoryCache(0)
+	c, err := NewBatchLoadCache(mc, time.Minute, loader.load)
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, mc.Put(ctx, "key1", "cached", time.Minute))
+
+	vals, err := c.GetMulti(ctx, []string{"key1", "key2", "key3"})
+	require.NoError(t, err)
+	assert.Equal(t, []any{"cached", "value2", "value3"}, vals)
+	assert.Equal(t, [][]string{{"key2", "key3"}}, loader.getCalls())
+
+	vals, err = c.GetMulti(ctx, []string{"key2", "missing"})
+	assert.Equal(t, []any{"value2", nil}, vals)
+	code, _ := berror.FromError(err)
+	assert.Equal(t, MultiGetFailed.Code(), code.Code())
+	assert.Contains(t, err.Error(), "key [missing]")
+	assert.Equal(t, [][]string{{"key2", "key3"}, {"missing"}}, loader.getCalls())
+}
+
+func TestNewBatchLoadCache(t *testing.T) {
+	_, err := NewBatchLoadCache(NewMemoryCache(0), time.Minute, nil)
+	assert.Error(t, err)
+	_, err = NewBatchLoadCache(NewMemoryCache(0), time.Minute, (&batchLoader{}).load,
+		WithBatchLoadCacheMaxBatch(0))
+	assert.Error(t, err)
+}
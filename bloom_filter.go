@@ -0,0 +1,171 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"sync"
+
+	"github.com/bits-and-blooms/bloom/v3"
+)
+
+// SyncBloomFilter is a BloomFilter safe for concurrent use, backed by github.com/bits-and-blooms/bloom.
+// It implements io.WriterTo and io.ReaderFrom, so that BloomFilterCache can persist and restore it.
+type SyncBloomFilter struct {
+	mu     sync.RWMutex
+	filter *bloom.BloomFilter
+}
+
+// NewSyncBloomFilter creates SyncBloomFilter sized for n items with the false positive rate fp.
+func NewSyncBloomFilter(n uint, fp float64) *SyncBloomFilter {
+	return &SyncBloomFilter{filter: bloom.NewWithEstimates(n, fp)}
+}
+
+// Test reports whether data may be added.
+func (f *SyncBloomFilter) Test(data string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.filter.TestString(data)
+}
+
+// Add adds data.
+func (f *SyncBloomFilter) Add(data string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.filter.AddString(data)
+}
+
+// WriteTo writes the bits of the filter to w.
+func (f *SyncBloomFilter) WriteTo(w io.Writer) (int64, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.filter.WriteTo(w)
+}
+
+// ReadFrom replaces the filter with the one read from r.
+func (f *SyncBloomFilter) ReadFrom(r io.Reader) (int64, error) {
+	filter := &bloom.BloomFilter{}
+	n, err := filter.ReadFrom(r)
+	if err != nil {
+		return n, err
+	}
+	f.mu.Lock()
+	f.filter = filter
+	f.mu.Unlock()
+	return n, nil
+}
+
+// CountingBloomFilter is a RemovableBloomFilter safe for concurrent use,
+// which keeps a counter instead of a bit for every location.
+//
+// Adding is counted, a key added n times may exist until it is removed n times.
+// Removing a key which is never added may drop the other keys.
+type CountingBloomFilter struct {
+	mu       sync.RWMutex
+	k        uint
+	counters []uint8
+}
+
+// NewCountingBloomFilter creates CountingBloomFilter sized for n items with the false positive rate fp.
+// It takes 8 times the memory of a bloom filter with the same parameters.
+func NewCountingBloomFilter(n uint, fp float64) *CountingBloomFilter {
+	m, k := bloom.EstimateParameters(n, fp)
+	return &CountingBloomFilter{k: k, counters: make([]uint8, m)}
+}
+
+// Test reports whether data may be added.
+func (f *CountingBloomFilter) Test(data string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.test(data)
+}
+
+// Add adds data.
+func (f *CountingBloomFilter) Add(data string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, loc := range f.locations(data) {
+		// a saturated counter is never decreased, so that it never drops the other keys
+		if f.counters[loc] < math.MaxUint8 {
+			f.counters[loc]++
+		}
+	}
+}
+
+// Remove removes data if it may exist.
+func (f *CountingBloomFilter) Remove(data string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.test(data) {
+		return
+	}
+	for _, loc := range f.locations(data) {
+		if f.counters[loc] < math.MaxUint8 {
+			f.counters[loc]--
+		}
+	}
+}
+
+// WriteTo writes the counters of the filter to w.
+func (f *CountingBloomFilter) WriteTo(w io.Writer) (int64, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	header := [2]uint64{uint64(f.k), uint64(len(f.counters))}
+	if err := binary.Write(w, binary.BigEndian, header); err != nil {
+		return 0, err
+	}
+	n, err := w.Write(f.counters)
+	return int64(binary.Size(header) + n), err
+}
+
+// ReadFrom replaces the filter with the one read from r.
+func (f *CountingBloomFilter) ReadFrom(r io.Reader) (int64, error) {
+	var header [2]uint64
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return 0, err
+	}
+	if header[0] == 0 || header[1] == 0 {
+		return int64(binary.Size(header)), errors.New("invalid parameters of counting bloom filter")
+	}
+	counters := make([]uint8, header[1])
+	n, err := io.ReadFull(r, counters)
+	if err != nil {
+		return int64(binary.Size(header) + n), err
+	}
+	f.mu.Lock()
+	f.k, f.counters = uint(header[0]), counters
+	f.mu.Unlock()
+	return int64(binary.Size(header) + n), nil
+}
+
+func (f *CountingBloomFilter) test(data string) bool {
+	for _, loc := range f.locations(data) {
+		if f.counters[loc] == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *CountingBloomFilter) locations(data string) []uint64 {
+	locs := bloom.Locations([]byte(data), f.k)
+	for i := range locs {
+		locs[i] %= uint64(len(f.counters))
+	}
+	return locs
+}
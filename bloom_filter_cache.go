@@ -17,47 +17,151 @@ package cache
 import (
 	"context"
 	"errors"
+	"io"
+	"sync"
 	"time"
 
 	berror "github.com/beego/beego-error/v2"
 )
 
+// BloomFilterCache is a read through decorator which only loads the missed keys passing the bloom filter.
+// The keys are added to the filter when they are put or loaded.
+//
+// The filter may be rebuilt from the source of the keys, so that the deleted keys are dropped,
+// and it may be persisted and restored, so that it isn't rebuilt after restarting.
+// Use Test and Add of BloomFilterCache instead of the filter directly, because the filter may be swapped.
 type BloomFilterCache struct {
 	Cache
 	BloomFilter
-	loadFunc   func(ctx context.Context, key string) (any, error)
-	expiration time.Duration // set cache expiration, default never expire
+	loadFunc    func(ctx context.Context, key string) (any, error)
+	expiration  time.Duration // set cache expiration, default never expire
+	negativeTTL time.Duration // set tombstone expiration, default no tombstone
+
+	newFilter       func() BloomFilter
+	source          func(ctx context.Context, add func(key string)) error
+	rebuildInterval time.Duration
+	onError         func(err error)
+
+	mu sync.RWMutex
+	// the keys added during rebuilding, nil if not rebuilding
+	added     []string
+	rebuildMu sync.Mutex
+	stop      chan struct{}
+	once      sync.Once
+}
+
+// BloomFilterCacheOption configures the BloomFilterCache
+type BloomFilterCacheOption func(bfc *BloomFilterCache)
+
+// WithBloomFilterCacheNegativeTTL configures how long a tombstone is cached
+// when loadFunc returns ErrNotFound, during which Get returns ErrKeyNotExist without calling loadFunc.
+// It avoids loading the keys passing the bloom filter by false positive again and again.
+// By default no tombstone is cached.
+func WithBloomFilterCacheNegativeTTL(ttl time.Duration) BloomFilterCacheOption {
+	return func(bfc *BloomFilterCache) {
+		bfc.negativeTTL = ttl
+	}
+}
+
+// WithBloomFilterCacheFactory configures the function creating an empty filter,
+// which is required by rebuilding and ReadFrom.
+func WithBloomFilterCacheFactory(newFilter func() BloomFilter) BloomFilterCacheOption {
+	return func(bfc *BloomFilterCache) {
+		bfc.newFilter = newFilter
+	}
+}
+
+// WithBloomFilterCacheRebuild configures the source of the keys to rebuild the filter,
+// source should call add for every existing key.
+// The filter is rebuilt every interval in background if interval is positive,
+// otherwise it is only rebuilt by calling Rebuild. Close must be called to stop rebuilding.
+func WithBloomFilterCacheRebuild(source func(ctx context.Context, add func(key string)) error,
+	interval time.Duration,
+) BloomFilterCacheOption {
+	return func(bfc *BloomFilterCache) {
+		bfc.source = source
+		bfc.rebuildInterval = interval
+	}
+}
+
+// WithBloomFilterCacheErrorHandler configures the function to handle the errors of rebuilding in background,
+// by default errors are ignored.
+func WithBloomFilterCacheErrorHandler(fn func(err error)) BloomFilterCacheOption {
+	return func(bfc *BloomFilterCache) {
+		bfc.onError = fn
+	}
 }
 
+// BloomFilter reports whether a key may exist. The implementations should be safe for concurrent use.
 type BloomFilter interface {
 	Test(data string) bool
 	Add(data string)
 }
 
+// ContextBloomFilter is a BloomFilter which may fail, like the one stored in redis.
+// BloomFilterCache adds the keys by TestContext and AddContext, so that Put returns the error.
+type ContextBloomFilter interface {
+	BloomFilter
+	TestContext(ctx context.Context, data string) (bool, error)
+	AddContext(ctx context.Context, data string) error
+}
+
+// RemovableBloomFilter is a BloomFilter which can remove data, like CountingBloomFilter and CuckooFilter.
+// BloomFilterCache removes the deleted keys from it.
+// BloomFilterCache only adds a key to it when the key is not in the cache, so that Delete removes it.
+// A key put again after its value expires is added again, so it may still pass the filter after Delete
+// until rebuilding, which costs a loading but never misses a record.
+type RemovableBloomFilter interface {
+	BloomFilter
+	Remove(data string)
+}
+
 func NewBloomFilterCache(cache Cache, ln func(context.Context, string) (any, error), blm BloomFilter,
-	expiration time.Duration,
+	expiration time.Duration, opts ...BloomFilterCacheOption,
 ) (*BloomFilterCache, error) {
 	if cache == nil || ln == nil || blm == nil {
 		return nil, berror.Error(InvalidInitParameters, "missing required parameters")
 	}
 
-	return &BloomFilterCache{
+	res := &BloomFilterCache{
 		Cache:       cache,
 		BloomFilter: blm,
 		loadFunc:    ln,
 		expiration:  expiration,
-	}, nil
+		onError:     func(error) {},
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	if res.source != nil && res.newFilter == nil {
+		return nil, berror.Error(InvalidInitParameters, "rebuilding requires WithBloomFilterCacheFactory")
+	}
+	if res.source != nil && res.rebuildInterval > 0 {
+		res.stop = make(chan struct{})
+		go res.loop()
+	}
+	return res, nil
 }
 
+// Get loads the key passing the bloom filter when it is missing.
+// ErrKeyNotExist is returned if loadFunc returns ErrNotFound.
 func (bfc *BloomFilterCache) Get(ctx context.Context, key string) (any, error) {
 	val, err := bfc.Cache.Get(ctx, key)
-	if err != nil && !errors.Is(err, ErrKeyNotExist) {
+	// an expired key is also a miss, tombstones expire soon
+	miss := errors.Is(err, ErrKeyNotExist) || errors.Is(err, ErrKeyExpired)
+	if err != nil && !miss {
 		return nil, err
 	}
-	if errors.Is(err, ErrKeyNotExist) {
-		exist := bfc.BloomFilter.Test(key)
+	if err == nil && isTombstone(val) {
+		return nil, ErrKeyNotExist
+	}
+	if miss {
+		exist := bfc.Test(key)
 		if exist {
 			val, err = bfc.loadFunc(ctx, key)
+			if errors.Is(err, ErrNotFound) {
+				return nil, putTombstone(ctx, bfc.Cache, key, bfc.negativeTTL)
+			}
 			if err != nil {
 				return nil, berror.Wrap(err, LoadFuncFailed, "cache unable to load data")
 			}
@@ -69,3 +173,203 @@ func (bfc *BloomFilterCache) Get(ctx context.Context, key string) (any, error) {
 	}
 	return val, nil
 }
+
+// GetMulti gets the values of keys, the tombstones are misses.
+func (bfc *BloomFilterCache) GetMulti(ctx context.Context, keys []string) ([]any, error) {
+	return getMultiSkipTombstones(ctx, bfc.Cache, keys)
+}
+
+// IsExist reports whether key exists, a tombstone doesn't.
+func (bfc *BloomFilterCache) IsExist(ctx context.Context, key string) (bool, error) {
+	return isExistSkipTombstone(ctx, bfc.Cache, key)
+}
+
+// Put puts the value, and adds key to the filter if it succeeds and key is new.
+// The error of adding is returned if the filter is a ContextBloomFilter.
+// The adding of a RemovableBloomFilter is counted, so key is only added to it
+// if it is not in the cache before, and a single Delete removes it.
+func (bfc *BloomFilterCache) Put(ctx context.Context, key string, val any, timeout time.Duration) error {
+	existed := false
+	if bfc.removable() {
+		existed, _ = bfc.Cache.IsExist(ctx, key)
+	}
+	if err := bfc.Cache.Put(ctx, key, val, timeout); err != nil {
+		return err
+	}
+	return bfc.addNew(ctx, key, existed)
+}
+
+// Delete deletes the value, and removes key from the filter if it is a RemovableBloomFilter,
+// so Delete means the record is deleted. Use Cache.Delete to only invalidate the value.
+// The key is removed only if it is in the cache, because removing a key which is never added
+// may drop the other keys.
+func (bfc *BloomFilterCache) Delete(ctx context.Context, key string) error {
+	existed := true
+	if bfc.removable() {
+		existed, _ = bfc.Cache.IsExist(ctx, key)
+	}
+	if err := bfc.Cache.Delete(ctx, key); err != nil {
+		return err
+	}
+	if existed {
+		bfc.Remove(key)
+	}
+	return nil
+}
+
+// Test reports whether key may exist by the current filter.
+func (bfc *BloomFilterCache) Test(key string) bool {
+	bfc.mu.RLock()
+	defer bfc.mu.RUnlock()
+	return bfc.BloomFilter.Test(key)
+}
+
+// Add adds key to the current filter, and to the one being rebuilt.
+func (bfc *BloomFilterCache) Add(key string) {
+	bfc.mu.Lock()
+	defer bfc.mu.Unlock()
+	bfc.BloomFilter.Add(key)
+	if bfc.added != nil {
+		bfc.added = append(bfc.added, key)
+	}
+}
+
+// addNew adds key to the current filter unless it passes the filter already,
+// and for a RemovableBloomFilter, existed in the cache too. It is always added to the one being rebuilt.
+func (bfc *BloomFilterCache) addNew(ctx context.Context, key string, existed bool) error {
+	bfc.mu.Lock()
+	defer bfc.mu.Unlock()
+	if bfc.added != nil {
+		bfc.added = append(bfc.added, key)
+	}
+	_, removable := bfc.BloomFilter.(RemovableBloomFilter)
+	cbf, ok := bfc.BloomFilter.(ContextBloomFilter)
+	if !ok {
+		if (!removable || existed) && bfc.BloomFilter.Test(key) {
+			return nil
+		}
+		bfc.BloomFilter.Add(key)
+		return nil
+	}
+	if !removable || existed {
+		// added if it fails to test, so that the error is returned
+		if exist, err := cbf.TestContext(ctx, key); err == nil && exist {
+			return nil
+		}
+	}
+	return cbf.AddContext(ctx, key)
+}
+
+func (bfc *BloomFilterCache) removable() bool {
+	bfc.mu.RLock()
+	defer bfc.mu.RUnlock()
+	_, ok := bfc.BloomFilter.(RemovableBloomFilter)
+	return ok
+}
+
+// Remove removes key from the current filter if it is a RemovableBloomFilter,
+// and from the keys added during rebuilding.
+func (bfc *BloomFilterCache) Remove(key string) {
+	bfc.mu.Lock()
+	defer bfc.mu.Unlock()
+	if rbf, ok := bfc.BloomFilter.(RemovableBloomFilter); ok {
+		rbf.Remove(key)
+	}
+	for i := 0; i < len(bfc.added); i++ {
+		if bfc.added[i] == key {
+			bfc.added = append(bfc.added[:i], bfc.added[i+1:]...)
+			i--
+		}
+	}
+}
+
+// Rebuild builds a new filter from the source of the keys, and swaps it with the current one.
+// The keys added during rebuilding are kept. The current filter is kept if the source fails.
+func (bfc *BloomFilterCache) Rebuild(ctx context.Context) error {
+	if bfc.source == nil {
+		return berror.Error(RebuildBloomFilterFailed, "the source of the keys is not configured")
+	}
+	bfc.rebuildMu.Lock()
+	defer bfc.rebuildMu.Unlock()
+
+	bfc.mu.Lock()
+	bfc.added = make([]string, 0)
+	bfc.mu.Unlock()
+
+	filter := bfc.newFilter()
+	err := bfc.source(ctx, filter.Add)
+
+	bfc.mu.Lock()
+	defer bfc.mu.Unlock()
+	added := bfc.added
+	bfc.added = nil
+	if err != nil {
+		return berror.Wrap(err, RebuildBloomFilterFailed, "bloom filter cache unable to rebuild the filter")
+	}
+	for _, key := range added {
+		filter.Add(key)
+	}
+	bfc.BloomFilter = filter
+	return nil
+}
+
+// WriteTo writes the bits of the current filter to w, the filter should implement io.WriterTo.
+func (bfc *BloomFilterCache) WriteTo(w io.Writer) (int64, error) {
+	bfc.mu.RLock()
+	defer bfc.mu.RUnlock()
+	wt, ok := bfc.BloomFilter.(io.WriterTo)
+	if !ok {
+		return 0, berror.Error(PersistBloomFilterFailed, "the bloom filter doesn't implement io.WriterTo")
+	}
+	n, err := wt.WriteTo(w)
+	if err != nil {
+		return n, berror.Wrap(err, PersistBloomFilterFailed, "bloom filter cache unable to write the filter")
+	}
+	return n, nil
+}
+
+// ReadFrom reads a filter written by WriteTo from r, and swaps it with the current one.
+// The filter is created by the function of WithBloomFilterCacheFactory, which should implement io.ReaderFrom.
+func (bfc *BloomFilterCache) ReadFrom(r io.Reader) (int64, error) {
+	if bfc.newFilter == nil {
+		return 0, berror.Error(PersistBloomFilterFailed, "the factory of the bloom filter is not configured")
+	}
+	filter := bfc.newFilter()
+	rf, ok := filter.(io.ReaderFrom)
+	if !ok {
+		return 0, berror.Error(PersistBloomFilterFailed, "the bloom filter doesn't implement io.ReaderFrom")
+	}
+	n, err := rf.ReadFrom(r)
+	if err != nil {
+		return n, berror.Wrap(err, PersistBloomFilterFailed, "bloom filter cache unable to read the filter")
+	}
+	bfc.mu.Lock()
+	bfc.BloomFilter = filter
+	bfc.mu.Unlock()
+	return n, nil
+}
+
+// Close stops rebuilding in background.
+func (bfc *BloomFilterCache) Close() error {
+	bfc.once.Do(func() {
+		if bfc.stop != nil {
+			close(bfc.stop)
+		}
+	})
+	return nil
+}
+
+func (bfc *BloomFilterCache) loop() {
+	ticker := time.NewTicker(bfc.rebuildInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := bfc.Rebuild(context.Background()); err != nil {
+				bfc.onError(err)
+			}
+		case <-bfc.stop:
+			return
+		}
+	}
+}
@@ -16,6 +16,7 @@
 package cache
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"sync"
@@ -25,6 +26,7 @@ import (
 	berror "github.com/beego/beego-error/v2"
 	"github.com/bits-and-blooms/bloom/v3"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type MockDB struct {
@@ -201,3 +203,200 @@ func TestBloomFilterCache_Get(t *testing.T) {
 //	wg.Wait()
 //	assert.Equal(t, int64(1), mockDB.loadCnt)
 // }
+
+func TestBloomFilterCache_Put(t *testing.T) {
+	bfc, err := NewBloomFilterCache(NewMemoryCache(0), loadFunc, NewSyncBloomFilter(100, 0.01), time.Minute)
+	require.NoError(t, err)
+
+	assert.False(t, bfc.Test("key"))
+	require.NoError(t, bfc.Put(context.Background(), "key", "value", time.Minute))
+	assert.True(t, bfc.Test("key"))
+}
+
+// addCountingFilter counts the adding of the keys, and the removing if it is removable
+type addCountingFilter struct {
+	BloomFilter
+	mu      sync.Mutex
+	adds    map[string]int
+	removes map[string]int
+}
+
+func (f *addCountingFilter) Add(data string) {
+	f.mu.Lock()
+	f.adds[data]++
+	f.mu.Unlock()
+	f.BloomFilter.Add(data)
+}
+
+type removableAddCountingFilter struct {
+	*addCountingFilter
+}
+
+func (f removableAddCountingFilter) Remove(data string) {
+	f.mu.Lock()
+	f.removes[data]++
+	f.mu.Unlock()
+	f.BloomFilter.(RemovableBloomFilter).Remove(data)
+}
+
+func TestBloomFilterCache_PutNewKeys(t *testing.T) {
+	ctx := context.Background()
+	filter := &addCountingFilter{BloomFilter: NewSyncBloomFilter(100, 0.01), adds: make(map[string]int)}
+	bfc, err := NewBloomFilterCache(NewMemoryCache(0), loadFunc, filter, time.Minute)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, bfc.Put(ctx, "key", i, time.Minute))
+	}
+	assert.Equal(t, 1, filter.adds["key"])
+
+	// a removable filter adds the key again only if it is not in the cache
+	filter = &addCountingFilter{
+		BloomFilter: NewCountingBloomFilter(100, 0.01),
+		adds:        make(map[string]int),
+		removes:     make(map[string]int),
+	}
+	bfc, err = NewBloomFilterCache(NewMemoryCache(0), loadFunc, removableAddCountingFilter{filter}, time.Minute)
+	require.NoError(t, err)
+	for i := 0; i < 300; i++ {
+		require.NoError(t, bfc.Put(ctx, "key", i, time.Minute))
+	}
+	assert.Equal(t, 1, filter.adds["key"])
+	require.NoError(t, bfc.Delete(ctx, "key"))
+	assert.False(t, bfc.Test("key"))
+	require.NoError(t, bfc.Put(ctx, "key", "value", time.Minute))
+	assert.Equal(t, 2, filter.adds["key"])
+}
+
+func TestBloomFilterCache_Rebuild(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	db := map[string]any{"key1": "value1", "key2": "value2"}
+	source := func(ctx context.Context, add func(key string)) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if db == nil {
+			return errors.New("db is down")
+		}
+		for key := range db {
+			add(key)
+		}
+		return nil
+	}
+	newFilter := func() BloomFilter {
+		return NewSyncBloomFilter(100, 0.01)
+	}
+	bfc, err := NewBloomFilterCache(NewMemoryCache(0), loadFunc, newFilter(), time.Minute,
+		WithBloomFilterCacheFactory(newFilter), WithBloomFilterCacheRebuild(source, 0))
+	require.NoError(t, err)
+
+	require.NoError(t, bfc.Rebuild(ctx))
+	assert.True(t, bfc.Test("key1"))
+	assert.True(t, bfc.Test("key2"))
+
+	// the deleted keys are dropped
+	mu.Lock()
+	delete(db, "key2")
+	mu.Unlock()
+	require.NoError(t, bfc.Rebuild(ctx))
+	assert.True(t, bfc.Test("key1"))
+	assert.False(t, bfc.Test("key2"))
+
+	// the filter is kept if the source fails
+	mu.Lock()
+	db = nil
+	mu.Unlock()
+	err = bfc.Rebuild(ctx)
+	code, _ := berror.FromError(err)
+	assert.Equal(t, RebuildBloomFilterFailed.Code(), code.Code())
+	assert.True(t, bfc.Test("key1"))
+
+	_, err = NewBloomFilterCache(NewMemoryCache(0), loadFunc, newFilter(), time.Minute,
+		WithBloomFilterCacheRebuild(source, 0))
+	assert.Error(t, err)
+}
+
+func TestBloomFilterCache_RebuildInterval(t *testing.T) {
+	var keys []string
+	var mu sync.Mutex
+	source := func(ctx context.Context, add func(key string)) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, key := range keys {
+			add(key)
+		}
+		return nil
+	}
+	newFilter := func() BloomFilter {
+		return NewSyncBloomFilter(100, 0.01)
+	}
+	bfc, err := NewBloomFilterCache(NewMemoryCache(0), loadFunc, newFilter(), time.Minute,
+		WithBloomFilterCacheFactory(newFilter), WithBloomFilterCacheRebuild(source, 10*time.Millisecond))
+	require.NoError(t, err)
+	defer bfc.Close()
+
+	mu.Lock()
+	keys = append(keys, "key")
+	mu.Unlock()
+	assert.Eventually(t, func() bool {
+		return bfc.Test("key")
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestBloomFilterCache_Persist(t *testing.T) {
+	newFilter := func() BloomFilter {
+		return NewSyncBloomFilter(100, 0.01)
+	}
+	bfc, err := NewBloomFilterCache(NewMemoryCache(0), loadFunc, newFilter(), time.Minute,
+		WithBloomFilterCacheFactory(newFilter))
+	require.NoError(t, err)
+	bfc.Add("key")
+
+	var buf bytes.Buffer
+	_, err = bfc.WriteTo(&buf)
+	require.NoError(t, err)
+
+	restored, err := NewBloomFilterCache(NewMemoryCache(0), loadFunc, newFilter(), time.Minute,
+		WithBloomFilterCacheFactory(newFilter))
+	require.NoError(t, err)
+	assert.False(t, restored.Test("key"))
+	_, err = restored.ReadFrom(&buf)
+	require.NoError(t, err)
+	assert.True(t, restored.Test("key"))
+
+	// the factory is required to read
+	noFactory, err := NewBloomFilterCache(NewMemoryCache(0), loadFunc, newFilter(), time.Minute)
+	require.NoError(t, err)
+	_, err = noFactory.ReadFrom(&buf)
+	code, _ := berror.FromError(err)
+	assert.Equal(t, PersistBloomFilterFailed.Code(), code.Code())
+}
+
+func TestBloomFilterCache_Delete(t *testing.T) {
+	ctx := context.Background()
+	bfc, err := NewBloomFilterCache(NewMemoryCache(0), loadFunc, NewCuckooFilter(100), time.Minute)
+	require.NoError(t, err)
+
+	require.NoError(t, bfc.Put(ctx, "key", "value", time.Minute))
+	assert.True(t, bfc.Test("key"))
+	require.NoError(t, bfc.Delete(ctx, "key"))
+	assert.False(t, bfc.Test("key"))
+
+	// only invalidated
+	require.NoError(t, bfc.Put(ctx, "key", "value", time.Minute))
+	require.NoError(t, bfc.Cache.Delete(ctx, "key"))
+	assert.True(t, bfc.Test("key"))
+
+	// the keys never added are not removed, so that they never drop the colliding keys
+	filter := &addCountingFilter{
+		BloomFilter: NewCountingBloomFilter(100, 0.01),
+		adds:        make(map[string]int),
+		removes:     make(map[string]int),
+	}
+	bfc, err = NewBloomFilterCache(NewMemoryCache(0), loadFunc, removableAddCountingFilter{filter}, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, bfc.Put(ctx, "key", "value", time.Minute))
+	require.NoError(t, bfc.Delete(ctx, "missing"))
+	require.NoError(t, bfc.Delete(ctx, "key"))
+	require.NoError(t, bfc.Delete(ctx, "key"))
+	assert.Equal(t, map[string]int{"key": 1}, filter.removes)
+}
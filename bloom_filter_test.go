@@ -0,0 +1,99 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemovableBloomFilter(t *testing.T) {
+	testCases := []struct {
+		name      string
+		newFilter func() RemovableBloomFilter
+	}{
+		{
+			name: "counting bloom filter",
+			newFilter: func() RemovableBloomFilter {
+				return NewCountingBloomFilter(1000, 0.01)
+			},
+		},
+		{
+			name: "cuckoo filter",
+			newFilter: func() RemovableBloomFilter {
+				return NewCuckooFilter(1000)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := tc.newFilter()
+			for i := 0; i < 1000; i++ {
+				f.Add(fmt.Sprintf("key_%d", i))
+			}
+			for i := 0; i < 1000; i++ {
+				assert.True(t, f.Test(fmt.Sprintf("key_%d", i)))
+			}
+			falsePositives := 0
+			for i := 0; i < 1000; i++ {
+				if f.Test(fmt.Sprintf("other_%d", i)) {
+					falsePositives++
+				}
+			}
+			assert.Less(t, falsePositives, 50)
+
+			// added twice, removed twice
+			f.Add("key_0")
+			f.Remove("key_0")
+			assert.True(t, f.Test("key_0"))
+			f.Remove("key_0")
+			assert.False(t, f.Test("key_0"))
+			for i := 1; i < 500; i++ {
+				f.Remove(fmt.Sprintf("key_%d", i))
+			}
+			for i := 500; i < 1000; i++ {
+				assert.True(t, f.Test(fmt.Sprintf("key_%d", i)))
+			}
+
+			// persisted and restored
+			var buf bytes.Buffer
+			_, err := f.(io.WriterTo).WriteTo(&buf)
+			require.NoError(t, err)
+			restored := tc.newFilter()
+			_, err = restored.(io.ReaderFrom).ReadFrom(&buf)
+			require.NoError(t, err)
+			for i := 500; i < 1000; i++ {
+				assert.True(t, restored.Test(fmt.Sprintf("key_%d", i)))
+			}
+		})
+	}
+}
+
+func TestCuckooFilter_Overflow(t *testing.T) {
+	f := NewCuckooFilter(8)
+	for i := 0; i < 100; i++ {
+		f.Add(fmt.Sprintf("key_%d", i))
+	}
+	// every key may exist after overflowing
+	for i := 0; i < 200; i++ {
+		assert.True(t, f.Test(fmt.Sprintf("key_%d", i)))
+	}
+}
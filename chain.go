@@ -0,0 +1,166 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The operation names of Invocation
+const (
+	OpGet      = "Get"
+	OpGetMulti = "GetMulti"
+	OpPut      = "Put"
+	OpDelete   = "Delete"
+	OpIncr     = "Incr"
+	OpDecr     = "Decr"
+	OpIsExist  = "IsExist"
+	OpClearAll = "ClearAll"
+)
+
+// Invocation is a call of an operation of Cache.
+// Keys has one key except that GetMulti has many and ClearAll has none.
+// Val and Timeout are only used by Put.
+type Invocation struct {
+	Operation string
+	Keys      []string
+	Val       any
+	Timeout   time.Duration
+	// Backend is the type of the cache passed to Chain, like cache.MemoryCache
+	Backend string
+}
+
+// Result is the result of an Invocation.
+// Val is the result of Get, Vals of GetMulti and Exist of IsExist.
+type Result struct {
+	Val   any
+	Vals  []any
+	Exist bool
+	Err   error
+}
+
+// Invoker runs an Invocation.
+type Invoker func(ctx context.Context, inv Invocation) Result
+
+// Interceptor wraps every operation of the cache created by Chain.
+// It may change the context and the invocation before calling next, like rewriting the keys,
+// call next many times or not at all, and change the result.
+// Keys should be copied before being changed, because it is shared with the caller.
+type Interceptor func(ctx context.Context, inv Invocation, next Invoker) Result
+
+// Chain returns a Cache calling base through the interceptors.
+// The first interceptor is the outermost, it is called first and returns last.
+func Chain(base Cache, interceptors ...Interceptor) Cache {
+	invoker := invoke(base)
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, inv Invocation) Result {
+			return interceptor(ctx, inv, next)
+		}
+	}
+	return &chainCache{
+		invoker: invoker,
+		backend: strings.TrimPrefix(fmt.Sprintf("%T", base), "*"),
+	}
+}
+
+// KeyPrefixInterceptor returns an Interceptor adding prefix to all the keys.
+func KeyPrefixInterceptor(prefix string) Interceptor {
+	return func(ctx context.Context, inv Invocation, next Invoker) Result {
+		keys := make([]string, 0, len(inv.Keys))
+		for _, key := range inv.Keys {
+			keys = append(keys, prefix+key)
+		}
+		inv.Keys = keys
+		return next(ctx, inv)
+	}
+}
+
+// invoke returns the Invoker calling c.
+func invoke(c Cache) Invoker {
+	return func(ctx context.Context, inv Invocation) Result {
+		var res Result
+		switch inv.Operation {
+		case OpGet:
+			res.Val, res.Err = c.Get(ctx, inv.Keys[0])
+		case OpGetMulti:
+			res.Vals, res.Err = c.GetMulti(ctx, inv.Keys)
+		case OpPut:
+			res.Err = c.Put(ctx, inv.Keys[0], inv.Val, inv.Timeout)
+		case OpDelete:
+			res.Err = c.Delete(ctx, inv.Keys[0])
+		case OpIncr:
+			res.Err = c.Incr(ctx, inv.Keys[0])
+		case OpDecr:
+			res.Err = c.Decr(ctx, inv.Keys[0])
+		case OpIsExist:
+			res.Exist, res.Err = c.IsExist(ctx, inv.Keys[0])
+		case OpClearAll:
+			res.Err = c.ClearAll(ctx)
+		default:
+			res.Err = fmt.Errorf("cache: unknown operation %s", inv.Operation)
+		}
+		return res
+	}
+}
+
+// chainCache turns every operation into an Invocation
+type chainCache struct {
+	invoker Invoker
+	backend string
+}
+
+func (c *chainCache) Get(ctx context.Context, key string) (any, error) {
+	res := c.invoke(ctx, Invocation{Operation: OpGet, Keys: []string{key}})
+	return res.Val, res.Err
+}
+
+func (c *chainCache) GetMulti(ctx context.Context, keys []string) ([]any, error) {
+	res := c.invoke(ctx, Invocation{Operation: OpGetMulti, Keys: keys})
+	return res.Vals, res.Err
+}
+
+func (c *chainCache) Put(ctx context.Context, key string, val any, timeout time.Duration) error {
+	return c.invoke(ctx, Invocation{Operation: OpPut, Keys: []string{key}, Val: val, Timeout: timeout}).Err
+}
+
+func (c *chainCache) Delete(ctx context.Context, key string) error {
+	return c.invoke(ctx, Invocation{Operation: OpDelete, Keys: []string{key}}).Err
+}
+
+func (c *chainCache) Incr(ctx context.Context, key string) error {
+	return c.invoke(ctx, Invocation{Operation: OpIncr, Keys: []string{key}}).Err
+}
+
+func (c *chainCache) Decr(ctx context.Context, key string) error {
+	return c.invoke(ctx, Invocation{Operation: OpDecr, Keys: []string{key}}).Err
+}
+
+func (c *chainCache) IsExist(ctx context.Context, key string) (bool, error) {
+	res := c.invoke(ctx, Invocation{Operation: OpIsExist, Keys: []string{key}})
+	return res.Exist, res.Err
+}
+
+func (c *chainCache) ClearAll(ctx context.Context) error {
+	return c.invoke(ctx, Invocation{Operation: OpClearAll}).Err
+}
+
+func (c *chainCache) invoke(ctx context.Context, inv Invocation) Result {
+	inv.Backend = c.backend
+	return c.invoker(ctx, inv)
+}
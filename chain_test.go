@@ -0,0 +1,88 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChain(t *testing.T) {
+	var calls []string
+	record := func(name string) Interceptor {
+		return func(ctx context.Context, inv Invocation, next Invoker) Result {
+			calls = append(calls, name+" "+inv.Operation)
+			res := next(ctx, inv)
+			calls = append(calls, name+" done")
+			return res
+		}
+	}
+	mc := NewMemoryCache(0)
+	c := Chain(mc, record("outer"), KeyPrefixInterceptor("app:"), record("inner"))
+	ctx := context.Background()
+
+	require.NoError(t, c.Put(ctx, "key", 1, time.Minute))
+	assert.Equal(t, []string{"outer Put", "inner Put", "inner done", "outer done"}, calls)
+	// the key is rewritten
+	val, err := mc.Get(ctx, "app:key")
+	require.NoError(t, err)
+	assert.Equal(t, 1, val)
+
+	require.NoError(t, c.Incr(ctx, "key"))
+	require.NoError(t, c.Incr(ctx, "key"))
+	require.NoError(t, c.Decr(ctx, "key"))
+	val, err = c.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, 2, val)
+	vals, err := c.GetMulti(ctx, []string{"key", "missing"})
+	assert.Error(t, err)
+	assert.Equal(t, []any{2, nil}, vals)
+	ok, err := c.IsExist(ctx, "key")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	require.NoError(t, c.Delete(ctx, "key"))
+	ok, err = c.IsExist(ctx, "key")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	require.NoError(t, c.Put(ctx, "key", 1, time.Minute))
+	require.NoError(t, c.ClearAll(ctx))
+	ok, err = mc.IsExist(ctx, "app:key")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestChain_ShortCircuit(t *testing.T) {
+	readOnly := func(ctx context.Context, inv Invocation, next Invoker) Result {
+		switch inv.Operation {
+		case OpGet, OpGetMulti, OpIsExist:
+			return next(ctx, inv)
+		}
+		return Result{}
+	}
+	c := Chain(NewMemoryCache(0), readOnly)
+	ctx := context.Background()
+
+	require.NoError(t, c.Put(ctx, "key", "value", time.Minute))
+	ok, err := c.IsExist(ctx, "key")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	res := invoke(NewMemoryCache(0))(ctx, Invocation{Operation: "Unknown"})
+	assert.Error(t, res.Err)
+}
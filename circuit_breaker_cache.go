@@ -0,0 +1,358 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	berror "github.com/beego/beego-error/v2"
+)
+
+// CircuitState is the state of the circuit of CircuitBreakerCache
+type CircuitState int
+
+const (
+	// CircuitClosed lets the operations through
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails the operations fast
+	CircuitOpen
+	// CircuitHalfOpen lets a few probes through to decide if the circuit closes or opens again
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(s))
+	}
+}
+
+// CircuitBreakerCacheOption configures CircuitBreakerCache
+type CircuitBreakerCacheOption func(c *CircuitBreakerCache)
+
+// WithCircuitBreakerCacheConsecutiveFailures configures the number of consecutive failures opening the circuit,
+// default 5. Zero disables it.
+func WithCircuitBreakerCacheConsecutiveFailures(n int) CircuitBreakerCacheOption {
+	return func(c *CircuitBreakerCache) {
+		c.maxConsecutiveFailures = n
+	}
+}
+
+// WithCircuitBreakerCacheErrorRate opens the circuit when the ratio of the failures reaches rate
+// in a window of at least minRequests operations. It is disabled by default.
+func WithCircuitBreakerCacheErrorRate(rate float64, minRequests int, window time.Duration) CircuitBreakerCacheOption {
+	return func(c *CircuitBreakerCache) {
+		c.errorRate = rate
+		c.minRequests = minRequests
+		c.window = window
+	}
+}
+
+// WithCircuitBreakerCacheOpenTimeout configures how long the circuit stays open before probing, default 5s.
+func WithCircuitBreakerCacheOpenTimeout(timeout time.Duration) CircuitBreakerCacheOption {
+	return func(c *CircuitBreakerCache) {
+		c.openTimeout = timeout
+	}
+}
+
+// WithCircuitBreakerCacheProbes configures the number of the operations let through in the half-open state,
+// default 1. The circuit closes when all of them succeed, and opens again when one of them fails.
+func WithCircuitBreakerCacheProbes(n int) CircuitBreakerCacheOption {
+	return func(c *CircuitBreakerCache) {
+		c.probes = n
+	}
+}
+
+// WithCircuitBreakerCacheFallback configures the cache serving the operations rejected by the circuit
+// and the failed ones, usually a MemoryCache.
+// It is cleared when the circuit closes, so that it doesn't serve stale values later,
+// and the keys written to it are deleted from the cache, so that the values before the outage are not served.
+func WithCircuitBreakerCacheFallback(fallback Cache) CircuitBreakerCacheOption {
+	return func(c *CircuitBreakerCache) {
+		c.fallback = fallback
+	}
+}
+
+// WithCircuitBreakerCacheFailure configures the function telling if an error is a failure of the cache,
+// default IsRetriable, which includes context.DeadlineExceeded. The other errors, like the misses, don't count.
+func WithCircuitBreakerCacheFailure(fn func(err error) bool) CircuitBreakerCacheOption {
+	return func(c *CircuitBreakerCache) {
+		c.isFailure = fn
+	}
+}
+
+// WithCircuitBreakerCacheStateHandler configures the function called when the state changes.
+// It is called synchronously by the operation changing the state, so it should be fast.
+func WithCircuitBreakerCacheStateHandler(fn func(from, to CircuitState)) CircuitBreakerCacheOption {
+	return func(c *CircuitBreakerCache) {
+		c.onStateChange = fn
+	}
+}
+
+// CircuitBreakerCache is a decorator failing fast when the cache keeps failing, like when Redis is down,
+// instead of letting every operation wait for a timeout.
+//
+// The circuit opens after consecutive failures or when the error rate is too high.
+// While it is open, Get, GetMulti and IsExist are misses and the other operations return ErrCircuitOpen,
+// or they are served by the fallback cache if there is one.
+// After the open timeout the circuit is half-open, a few probes are let through
+// and the circuit closes if they succeed, or opens again if one fails.
+type CircuitBreakerCache struct {
+	Cache
+	primary                Cache
+	fallback               Cache
+	maxConsecutiveFailures int
+	errorRate              float64
+	minRequests            int
+	window                 time.Duration
+	openTimeout            time.Duration
+	probes                 int
+	isFailure              func(err error) bool
+	onStateChange          func(from, to CircuitState)
+
+	mu    sync.Mutex
+	state CircuitState
+	// generation changes with the state, so that the results of the operations started before are ignored
+	generation          uint64
+	openedAt            time.Time
+	consecutiveFailures int
+	requests            int
+	failures            int
+	windowStart         time.Time
+	inflightProbes      int
+	succeededProbes     int
+	// the keys written to the fallback, they are deleted from the cache when the circuit closes
+	dirty    map[string]struct{}
+	dirtyAll bool
+}
+
+// NewCircuitBreakerCache creates CircuitBreakerCache
+func NewCircuitBreakerCache(c Cache, opts ...CircuitBreakerCacheOption) (*CircuitBreakerCache, error) {
+	if c == nil {
+		return nil, berror.Error(InvalidInitParameters, "cache can not be nil")
+	}
+	cb := &CircuitBreakerCache{
+		primary:                c,
+		maxConsecutiveFailures: 5,
+		openTimeout:            5 * time.Second,
+		probes:                 1,
+		isFailure:              IsRetriable,
+	}
+	for _, opt := range opts {
+		opt(cb)
+	}
+	if cb.maxConsecutiveFailures < 0 || cb.errorRate < 0 || cb.errorRate > 1 ||
+		(cb.errorRate > 0 && cb.window <= 0) || cb.openTimeout <= 0 || cb.probes < 1 {
+		return nil, berror.Errorf(InvalidInitParameters,
+			"invalid consecutive failures %d, error rate %v in %v, open timeout %v or probes %d",
+			cb.maxConsecutiveFailures, cb.errorRate, cb.window, cb.openTimeout, cb.probes)
+	}
+	cb.dirty = make(map[string]struct{})
+	cb.Cache = Chain(c, cb.intercept)
+	return cb, nil
+}
+
+// State returns the current state of the circuit.
+func (c *CircuitBreakerCache) State() CircuitState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == CircuitOpen && time.Since(c.openedAt) >= c.openTimeout {
+		return CircuitHalfOpen
+	}
+	return c.state
+}
+
+func (c *CircuitBreakerCache) intercept(ctx context.Context, inv Invocation, next Invoker) Result {
+	generation, allowed, changed := c.before(inv)
+	c.notify(changed)
+	if !allowed {
+		return c.reject(ctx, inv)
+	}
+	res := next(ctx, inv)
+	failed := res.Err != nil && c.isFailure(res.Err)
+	changed = c.after(generation, failed)
+	c.notify(changed)
+	if failed && c.fallback != nil {
+		c.mu.Lock()
+		c.markDirty(inv)
+		c.mu.Unlock()
+		return invoke(c.fallback)(ctx, inv)
+	}
+	return res
+}
+
+// reject serves inv by the fallback, or fails it fast
+func (c *CircuitBreakerCache) reject(ctx context.Context, inv Invocation) Result {
+	if c.fallback != nil {
+		return invoke(c.fallback)(ctx, inv)
+	}
+	switch inv.Operation {
+	case OpGet:
+		return Result{Err: ErrKeyNotExist}
+	case OpGetMulti:
+		keysErr := make([]string, 0, len(inv.Keys))
+		for _, key := range inv.Keys {
+			keysErr = append(keysErr, fmt.Sprintf("key [%s] error: %s", key, ErrKeyNotExist.Error()))
+		}
+		return Result{Vals: make([]any, len(inv.Keys)), Err: berror.Error(MultiGetFailed, strings.Join(keysErr, "; "))}
+	case OpIsExist:
+		return Result{}
+	default:
+		return Result{Err: ErrCircuitOpen}
+	}
+}
+
+// stateChange is a change of the state to notify
+type stateChange struct {
+	from, to CircuitState
+}
+
+// before decides if inv is let through, and returns the generation of the operation.
+// The keys written by a rejected inv are marked dirty at once, so that the closing never misses them.
+func (c *CircuitBreakerCache) before(inv Invocation) (generation uint64, allowed bool, changed []stateChange) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.state == CircuitOpen && now.Sub(c.openedAt) >= c.openTimeout {
+		changed = append(changed, c.setState(CircuitHalfOpen, now))
+	}
+	switch c.state {
+	case CircuitOpen:
+		c.markDirty(inv)
+		return c.generation, false, changed
+	case CircuitHalfOpen:
+		if c.inflightProbes >= c.probes-c.succeededProbes {
+			c.markDirty(inv)
+			return c.generation, false, changed
+		}
+		c.inflightProbes++
+	}
+	return c.generation, true, changed
+}
+
+// markDirty records the keys inv writes to the fallback, c.mu must be held
+func (c *CircuitBreakerCache) markDirty(inv Invocation) {
+	if c.fallback == nil {
+		return
+	}
+	switch inv.Operation {
+	case OpPut, OpDelete, OpIncr, OpDecr:
+		for _, key := range inv.Keys {
+			c.dirty[key] = struct{}{}
+		}
+	case OpClearAll:
+		c.dirtyAll = true
+	}
+}
+
+// after records the result of an operation started in generation
+func (c *CircuitBreakerCache) after(generation uint64, failed bool) (changed []stateChange) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return nil
+	}
+	now := time.Now()
+	switch c.state {
+	case CircuitClosed:
+		if c.window > 0 && now.Sub(c.windowStart) >= c.window {
+			c.requests, c.failures, c.windowStart = 0, 0, now
+		}
+		c.requests++
+		if !failed {
+			c.consecutiveFailures = 0
+			return nil
+		}
+		c.failures++
+		c.consecutiveFailures++
+		if (c.maxConsecutiveFailures > 0 && c.consecutiveFailures >= c.maxConsecutiveFailures) ||
+			(c.errorRate > 0 && c.requests >= c.minRequests &&
+				float64(c.failures) >= c.errorRate*float64(c.requests)) {
+			changed = append(changed, c.setState(CircuitOpen, now))
+		}
+	case CircuitHalfOpen:
+		c.inflightProbes--
+		if failed {
+			return append(changed, c.setState(CircuitOpen, now))
+		}
+		c.succeededProbes++
+		if c.succeededProbes >= c.probes {
+			changed = append(changed, c.setState(CircuitClosed, now))
+		}
+	}
+	return changed
+}
+
+// setState changes the state and resets the counters, c.mu must be held
+func (c *CircuitBreakerCache) setState(state CircuitState, now time.Time) stateChange {
+	change := stateChange{from: c.state, to: state}
+	c.state = state
+	c.generation++
+	c.consecutiveFailures, c.requests, c.failures, c.windowStart = 0, 0, 0, now
+	c.inflightProbes, c.succeededProbes = 0, 0
+	if state == CircuitOpen {
+		c.openedAt = now
+	}
+	return change
+}
+
+// notify calls the state handler, and cleans up the fallback when the circuit closes
+func (c *CircuitBreakerCache) notify(changed []stateChange) {
+	for _, change := range changed {
+		if change.to == CircuitClosed && c.fallback != nil {
+			c.invalidate(context.Background())
+			_ = c.fallback.ClearAll(context.Background())
+		}
+		if c.onStateChange != nil {
+			c.onStateChange(change.from, change.to)
+		}
+	}
+}
+
+// invalidate deletes the keys written to the fallback from the cache,
+// the keys failed to be deleted are kept dirty and deleted again when the circuit closes next time.
+func (c *CircuitBreakerCache) invalidate(ctx context.Context) {
+	c.mu.Lock()
+	dirty, dirtyAll := c.dirty, c.dirtyAll
+	c.dirty, c.dirtyAll = make(map[string]struct{}), false
+	c.mu.Unlock()
+
+	if dirtyAll {
+		if err := c.primary.ClearAll(ctx); err == nil {
+			return
+		}
+		c.mu.Lock()
+		c.dirtyAll = true
+		c.mu.Unlock()
+	}
+	for key := range dirty {
+		// memcache fails to delete the missing keys
+		if err := c.primary.Delete(ctx, key); err != nil && ClassifyError(err) != ErrorClassMiss {
+			c.mu.Lock()
+			c.dirty[key] = struct{}{}
+			c.mu.Unlock()
+		}
+	}
+}
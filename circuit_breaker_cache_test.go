@@ -0,0 +1,150 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	berror "github.com/beego/beego-error/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// downInterceptor fails all the calls with RedisCacheCurdFailed while down is set
+func downInterceptor(down *int32, calls *int32) Interceptor {
+	return func(ctx context.Context, inv Invocation, next Invoker) Result {
+		atomic.AddInt32(calls, 1)
+		if atomic.LoadInt32(down) == 1 {
+			return Result{Err: berror.Error(RedisCacheCurdFailed, "connection refused")}
+		}
+		return next(ctx, inv)
+	}
+}
+
+func TestCircuitBreakerCache(t *testing.T) {
+	ctx := context.Background()
+	var down, calls int32
+	var mu sync.Mutex
+	var changes []string
+	c, err := NewCircuitBreakerCache(Chain(NewMemoryCache(0), downInterceptor(&down, &calls)),
+		WithCircuitBreakerCacheConsecutiveFailures(3),
+		WithCircuitBreakerCacheOpenTimeout(50*time.Millisecond),
+		WithCircuitBreakerCacheStateHandler(func(from, to CircuitState) {
+			mu.Lock()
+			defer mu.Unlock()
+			changes = append(changes, from.String()+"->"+to.String())
+		}))
+	require.NoError(t, err)
+	require.NoError(t, c.Put(ctx, "key", "value", time.Minute))
+
+	// the misses are not failures
+	for i := 0; i < 5; i++ {
+		_, err = c.Get(ctx, "missing")
+		assert.Error(t, err)
+	}
+	assert.Equal(t, CircuitClosed, c.State())
+
+	atomic.StoreInt32(&down, 1)
+	for i := 0; i < 3; i++ {
+		_, err = c.Get(ctx, "key")
+		assert.Error(t, err)
+	}
+	assert.Equal(t, CircuitOpen, c.State())
+
+	// fails fast
+	calls = 0
+	_, err = c.Get(ctx, "key")
+	code, _ := berror.FromError(err)
+	assert.Equal(t, KeyNotExist.Code(), code.Code())
+	vals, err := c.GetMulti(ctx, []string{"key", "key2"})
+	code, _ = berror.FromError(err)
+	assert.Equal(t, MultiGetFailed.Code(), code.Code())
+	assert.Equal(t, []any{nil, nil}, vals)
+	exist, err := c.IsExist(ctx, "key")
+	assert.NoError(t, err)
+	assert.False(t, exist)
+	err = c.Put(ctx, "key", "value", time.Minute)
+	code, _ = berror.FromError(err)
+	assert.Equal(t, CircuitBreakerOpen.Code(), code.Code())
+	assert.Equal(t, int32(0), calls)
+
+	// the probe fails
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, CircuitHalfOpen, c.State())
+	_, err = c.Get(ctx, "key")
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), calls)
+	assert.Equal(t, CircuitOpen, c.State())
+
+	// the probe succeeds
+	atomic.StoreInt32(&down, 0)
+	time.Sleep(60 * time.Millisecond)
+	val, err := c.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", val)
+	assert.Equal(t, CircuitClosed, c.State())
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"closed->open", "open->half-open", "half-open->open",
+		"open->half-open", "half-open->closed"}, changes)
+}
+
+func TestCircuitBreakerCache_ErrorRateAndFallback(t *testing.T) {
+	ctx := context.Background()
+	var down, calls int32
+	fallback := NewMemoryCache(0)
+	c, err := NewCircuitBreakerCache(Chain(NewMemoryCache(0), downInterceptor(&down, &calls)),
+		WithCircuitBreakerCacheConsecutiveFailures(0),
+		WithCircuitBreakerCacheErrorRate(0.5, 4, time.Minute),
+		WithCircuitBreakerCacheOpenTimeout(50*time.Millisecond),
+		WithCircuitBreakerCacheFallback(fallback))
+	require.NoError(t, err)
+
+	for i := 0; i < 4; i++ {
+		atomic.StoreInt32(&down, int32(i%2))
+		require.NoError(t, c.Put(ctx, "key", i, time.Minute))
+	}
+	assert.Equal(t, CircuitOpen, c.State())
+
+	// served by the fallback
+	require.NoError(t, c.Put(ctx, "key", "fallback", time.Minute))
+	val, err := c.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "fallback", val)
+
+	// the fallback is cleared when the circuit closes,
+	// and the key written to it is deleted from the cache, so the value before the outage is not served
+	atomic.StoreInt32(&down, 0)
+	time.Sleep(60 * time.Millisecond)
+	exist, err := c.IsExist(ctx, "other")
+	assert.NoError(t, err)
+	assert.False(t, exist)
+	assert.Equal(t, CircuitClosed, c.State())
+	exist, err = fallback.IsExist(ctx, "key")
+	assert.NoError(t, err)
+	assert.False(t, exist)
+	_, err = c.Get(ctx, "key")
+	assert.ErrorIs(t, err, ErrKeyNotExist)
+
+	_, err = NewCircuitBreakerCache(nil)
+	assert.Error(t, err)
+	_, err = NewCircuitBreakerCache(fallback, WithCircuitBreakerCacheProbes(0))
+	assert.Error(t, err)
+}
@@ -0,0 +1,204 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"io"
+	"math/bits"
+	"math/rand"
+	"sync"
+)
+
+const (
+	cuckooBucketSize = 4
+	cuckooMaxKicks   = 500
+	// the load factor a cuckoo filter with 4 slots per bucket reaches in practice
+	cuckooLoadFactor = 0.95
+)
+
+// CuckooFilter is a RemovableBloomFilter safe for concurrent use,
+// which keeps a 16 bits fingerprint of every key in one of its two buckets.
+// The false positive rate is about 8 / 65536 when it is full.
+//
+// Adding is counted, a key added n times may exist until it is removed n times.
+// Removing a key which is never added may drop the other keys.
+// When it overflows, every key may exist, so the keys are loaded as if there is no filter.
+type CuckooFilter struct {
+	mu      sync.RWMutex
+	buckets [][cuckooBucketSize]uint16
+	// the fingerprint evicted by the last failed adding
+	victim      uint16
+	victimIndex uint64
+	overflow    bool
+}
+
+// NewCuckooFilter creates CuckooFilter sized for n items.
+func NewCuckooFilter(n uint) *CuckooFilter {
+	size := uint64(float64(n)/cuckooBucketSize/cuckooLoadFactor) + 1
+	// the number of buckets is a power of 2, so that the alternate index can be computed by xor
+	size = 1 << bits.Len64(size-1)
+	return &CuckooFilter{buckets: make([][cuckooBucketSize]uint16, size)}
+}
+
+// Test reports whether data may be added.
+func (f *CuckooFilter) Test(data string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	fp, i1, i2 := f.indexes(data)
+	return f.overflow || f.victim == fp && (f.victimIndex == i1 || f.victimIndex == i2) ||
+		f.contains(i1, fp) || f.contains(i2, fp)
+}
+
+// Add adds data.
+func (f *CuckooFilter) Add(data string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.overflow {
+		return
+	}
+	fp, i1, i2 := f.indexes(data)
+	if f.put(i1, fp) || f.put(i2, fp) {
+		return
+	}
+	if f.victim != 0 {
+		// there is no room for the victim, so there is no room for data either
+		f.overflow = true
+		return
+	}
+	f.insert(fp, i1)
+}
+
+// Remove removes data if it may exist.
+func (f *CuckooFilter) Remove(data string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fp, i1, i2 := f.indexes(data)
+	if f.victim == fp && (f.victimIndex == i1 || f.victimIndex == i2) {
+		f.victim = 0
+		return
+	}
+	if !f.delete(i1, fp) && !f.delete(i2, fp) {
+		return
+	}
+	// there is room for the victim now
+	if f.victim != 0 {
+		fp, f.victim = f.victim, 0
+		f.insert(fp, f.victimIndex)
+	}
+}
+
+// WriteTo writes the buckets of the filter to w.
+func (f *CuckooFilter) WriteTo(w io.Writer) (int64, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	var overflow uint64
+	if f.overflow {
+		overflow = 1
+	}
+	header := [4]uint64{uint64(len(f.buckets)), uint64(f.victim), f.victimIndex, overflow}
+	if err := binary.Write(w, binary.BigEndian, header); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(w, binary.BigEndian, f.buckets); err != nil {
+		return int64(binary.Size(header)), err
+	}
+	return int64(binary.Size(header) + binary.Size(f.buckets)), nil
+}
+
+// ReadFrom replaces the filter with the one read from r.
+func (f *CuckooFilter) ReadFrom(r io.Reader) (int64, error) {
+	var header [4]uint64
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return 0, err
+	}
+	if header[0] == 0 || header[0]&(header[0]-1) != 0 {
+		return int64(binary.Size(header)), errors.New("invalid number of buckets of cuckoo filter")
+	}
+	buckets := make([][cuckooBucketSize]uint16, header[0])
+	if err := binary.Read(r, binary.BigEndian, buckets); err != nil {
+		return int64(binary.Size(header)), err
+	}
+	f.mu.Lock()
+	f.buckets, f.victim, f.victimIndex, f.overflow = buckets, uint16(header[1]), header[2], header[3] == 1
+	f.mu.Unlock()
+	return int64(binary.Size(header) + binary.Size(buckets)), nil
+}
+
+// indexes returns the fingerprint of data, which is never 0, and its two buckets.
+func (f *CuckooFilter) indexes(data string) (uint16, uint64, uint64) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(data))
+	sum := h.Sum64()
+	fp := uint16(sum >> 48)
+	if fp == 0 {
+		fp = 1
+	}
+	i1 := sum & uint64(len(f.buckets)-1)
+	return fp, i1, f.alternate(i1, fp)
+}
+
+func (f *CuckooFilter) alternate(i uint64, fp uint16) uint64 {
+	return (i ^ uint64(fp)*0x5bd1e995) & uint64(len(f.buckets)-1)
+}
+
+// insert puts fp into the bucket i or its alternate, evicting the others if both are full.
+// The fingerprint evicted at last becomes the victim.
+func (f *CuckooFilter) insert(fp uint16, i uint64) {
+	if f.put(i, fp) {
+		return
+	}
+	i = f.alternate(i, fp)
+	for n := 0; n < cuckooMaxKicks; n++ {
+		if f.put(i, fp) {
+			return
+		}
+		slot := rand.Intn(cuckooBucketSize)
+		fp, f.buckets[i][slot] = f.buckets[i][slot], fp
+		i = f.alternate(i, fp)
+	}
+	f.victim, f.victimIndex = fp, i
+}
+
+func (f *CuckooFilter) put(i uint64, fp uint16) bool {
+	for slot, v := range f.buckets[i] {
+		if v == 0 {
+			f.buckets[i][slot] = fp
+			return true
+		}
+	}
+	return false
+}
+
+func (f *CuckooFilter) contains(i uint64, fp uint16) bool {
+	for _, v := range f.buckets[i] {
+		if v == fp {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *CuckooFilter) delete(i uint64, fp uint16) bool {
+	for slot, v := range f.buckets[i] {
+		if v == fp {
+			f.buckets[i][slot] = 0
+			return true
+		}
+	}
+	return false
+}
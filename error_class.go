@@ -0,0 +1,91 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	berror "github.com/beego/beego-error/v2"
+)
+
+// ErrorClass is the class of an error returned by a cache
+type ErrorClass int
+
+const (
+	// ErrorClassUnknown means the classifier doesn't know the error
+	ErrorClassUnknown ErrorClass = iota
+	// ErrorClassMiss is a missing key, like redis.Nil
+	ErrorClassMiss
+	// ErrorClassFailure is a failure of the backend which may go away, like a lost connection
+	ErrorClassFailure
+)
+
+var (
+	classifiersMu sync.RWMutex
+	classifiers   []func(err error) ErrorClass
+)
+
+// RegisterErrorClassifier registers fn classifying the errors of a client library,
+// the adapters register theirs when they are imported.
+// The errors unknown to all the classifiers are failures if they are network errors,
+// or have the code MemCacheCurdFailed, RedisCacheCurdFailed, DialFailed or SsdbCacheCurdFailed.
+func RegisterErrorClassifier(fn func(err error) ErrorClass) {
+	classifiersMu.Lock()
+	defer classifiersMu.Unlock()
+	classifiers = append(classifiers, fn)
+}
+
+// ClassifyError returns the class of err, see RegisterErrorClassifier.
+func ClassifyError(err error) ErrorClass {
+	if err == nil {
+		return ErrorClassUnknown
+	}
+	if errors.Is(err, ErrKeyNotExist) || errors.Is(err, ErrKeyExpired) {
+		return ErrorClassMiss
+	}
+	classifiersMu.RLock()
+	for _, fn := range classifiers {
+		if class := fn(err); class != ErrorClassUnknown {
+			classifiersMu.RUnlock()
+			return class
+		}
+	}
+	classifiersMu.RUnlock()
+
+	var netErr net.Error
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr) {
+		return ErrorClassFailure
+	}
+	code, _ := berror.FromError(err)
+	for _, c := range retriableCodes {
+		if c.Code() == code.Code() {
+			return ErrorClassFailure
+		}
+	}
+	return ErrorClassUnknown
+}
+
+// retriableCodes are the error codes of the network errors of the adapters
+var retriableCodes = []berror.Code{MemCacheCurdFailed, RedisCacheCurdFailed, DialFailed, SsdbCacheCurdFailed}
+
+// IsRetriable reports whether err is a failure of the backend, see ClassifyError.
+// The misses are not, like memcache.ErrCacheMiss wrapped in MemCacheCurdFailed by the memcache adapter.
+// It is the default of RetryCache and CircuitBreakerCache.
+func IsRetriable(err error) bool {
+	return ClassifyError(err) == ErrorClassFailure
+}
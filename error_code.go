@@ -99,6 +99,27 @@ Failed to execute the StoreFunc.
 Please check the log to make sure the StoreFunc works for the specific key and value.
 `)
 
+var RecordNotFound = berror.DefineCode(4002027, moduleName, "RecordNotFound", `
+The loadFunc reports that the record doesn't exist.
+Return ErrNotFound from the loadFunc, so that the decorators can cache a tombstone instead of loading it again.
+`)
+
+var RebuildBloomFilterFailed = berror.DefineCode(4002028, moduleName, "RebuildBloomFilterFailed", `
+BloomFilterCache failed to rebuild the bloom filter, usually the key source function returns an error.
+The current filter is kept until the next rebuilding.
+`)
+
+var PersistBloomFilterFailed = berror.DefineCode(4002029, moduleName, "PersistBloomFilterFailed", `
+BloomFilterCache failed to write or read the bits of the bloom filter.
+The filter should implement io.WriterTo to be written and io.ReaderFrom to be read,
+and BloomFilterCache needs WithBloomFilterCacheFactory to create the filter to read.
+`)
+
+var CircuitBreakerOpen = berror.DefineCode(4002030, moduleName, "CircuitBreakerOpen", `
+CircuitBreakerCache rejects the write because the circuit is open, the cache failed too many times recently.
+The reads are treated as misses meanwhile. Configure a fallback cache to keep serving the writes during the outage.
+`)
+
 var DeleteFileCacheItemFailed = berror.DefineCode(5002001, moduleName, "DeleteFileCacheItemFailed", `
 Beego try to delete file cache item failed. 
 Please check whether Beego generated file correctly. 
@@ -144,4 +165,8 @@ Usually it indicates something wrong on server side.
 var (
 	ErrKeyExpired  = berror.Error(KeyExpired, "the key is expired")
 	ErrKeyNotExist = berror.Error(KeyNotExist, "the key isn't exist")
+	// ErrNotFound should be returned by loadFunc when the record doesn't exist
+	ErrNotFound = berror.Error(RecordNotFound, "the record isn't found")
+	// ErrCircuitOpen is returned by the writes of CircuitBreakerCache when the circuit is open
+	ErrCircuitOpen = berror.Error(CircuitBreakerOpen, "the circuit is open")
 )
@@ -0,0 +1,122 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"time"
+
+	berror "github.com/beego/beego-error/v2"
+)
+
+// Observation is one cache operation.
+// Before receives the operation, keys and backend, After also receives the result.
+type Observation struct {
+	Operation string
+	Keys      []string
+	// Backend is the type of the cache, like cache.MemoryCache
+	Backend string
+	// Hits and Misses are the number of keys found or not by Get, GetMulti and IsExist
+	Hits   int
+	Misses int
+	// Err is the error of the operation, not including the misses
+	Err      error
+	Duration time.Duration
+}
+
+// Hook is called around every operation of HookCache.
+// Before returns the context passed to the next hooks and the cache, and to its After,
+// so that it can carry values like a span.
+type Hook interface {
+	Before(ctx context.Context, o Observation) context.Context
+	After(ctx context.Context, o Observation)
+}
+
+// HookCache is a decorator calling the hooks around every operation.
+// Before is called in order and After in reverse order.
+//
+// The errors of Get classified as ErrorClassMiss, like ErrKeyNotExist and redis.Nil, are misses, not errors.
+// The MultiGetFailed error of GetMulti is not an error either, the misses are counted by the nil values.
+type HookCache struct {
+	Cache
+}
+
+// NewHookCache creates HookCache, which is Chain(c, HookInterceptor(hooks...))
+func NewHookCache(c Cache, hooks ...Hook) (*HookCache, error) {
+	if c == nil {
+		return nil, berror.Error(InvalidInitParameters, "cache can not be nil")
+	}
+	return &HookCache{Cache: Chain(c, HookInterceptor(hooks...))}, nil
+}
+
+// HookInterceptor returns an Interceptor calling the hooks around every operation, see HookCache.
+func HookInterceptor(hooks ...Hook) Interceptor {
+	return func(ctx context.Context, inv Invocation, next Invoker) Result {
+		o := Observation{Operation: inv.Operation, Keys: inv.Keys, Backend: inv.Backend}
+		ctxs := make([]context.Context, len(hooks))
+		for i, h := range hooks {
+			ctx = h.Before(ctx, o)
+			ctxs[i] = ctx
+		}
+		start := time.Now()
+		res := next(ctx, inv)
+		o.Duration = time.Since(start)
+		o.observe(res)
+		for i := len(hooks) - 1; i >= 0; i-- {
+			hooks[i].After(ctxs[i], o)
+		}
+		return res
+	}
+}
+
+// observe fills the hits, misses and error of res into o.
+func (o *Observation) observe(res Result) {
+	o.Err = res.Err
+	switch o.Operation {
+	case OpGet:
+		switch {
+		case ClassifyError(res.Err) == ErrorClassMiss:
+			o.Misses, o.Err = 1, nil
+		case res.Err == nil && res.Val == nil:
+			o.Misses = 1
+		case res.Err == nil:
+			o.Hits = 1
+		}
+	case OpGetMulti:
+		if res.Err != nil {
+			if code, _ := berror.FromError(res.Err); code.Code() == MultiGetFailed.Code() {
+				o.Err = nil
+			}
+		}
+		if o.Err == nil {
+			for _, val := range res.Vals {
+				if val == nil {
+					o.Misses++
+				} else {
+					o.Hits++
+				}
+			}
+			o.Misses += len(o.Keys) - len(res.Vals)
+		}
+	case OpIsExist:
+		if res.Err == nil {
+			if res.Exist {
+				o.Hits = 1
+			} else {
+				o.Misses = 1
+			}
+		}
+	}
+}
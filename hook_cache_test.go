@@ -0,0 +1,130 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingHook struct {
+	name  string
+	calls *[]string
+	obs   []Observation
+}
+
+type hookCtxKey struct{}
+
+func (h *recordingHook) Before(ctx context.Context, o Observation) context.Context {
+	*h.calls = append(*h.calls, "before "+h.name+" "+o.Operation)
+	return context.WithValue(ctx, hookCtxKey{}, h.name)
+}
+
+func (h *recordingHook) After(ctx context.Context, o Observation) {
+	*h.calls = append(*h.calls, "after "+h.name+" "+ctx.Value(hookCtxKey{}).(string))
+	h.obs = append(h.obs, o)
+}
+
+func TestHookCache(t *testing.T) {
+	var calls []string
+	h1, h2 := &recordingHook{name: "h1", calls: &calls}, &recordingHook{name: "h2", calls: &calls}
+	c, err := NewHookCache(NewMemoryCache(0), h1, h2)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.NoError(t, c.Put(ctx, "key", "value", time.Minute))
+	assert.Equal(t, []string{"before h1 Put", "before h2 Put", "after h2 h2", "after h1 h1"}, calls)
+	o := h1.obs[0]
+	assert.Equal(t, OpPut, o.Operation)
+	assert.Equal(t, []string{"key"}, o.Keys)
+	assert.Equal(t, "cache.MemoryCache", o.Backend)
+	assert.Greater(t, o.Duration, time.Duration(0))
+
+	_, err = c.Get(ctx, "missing")
+	assert.Error(t, err)
+	o = h1.obs[1]
+	assert.Equal(t, 1, o.Misses)
+	assert.Nil(t, o.Err)
+
+	assert.Error(t, c.Incr(ctx, "key"))
+	assert.Error(t, h1.obs[2].Err)
+}
+
+type mockSpan struct {
+	name  string
+	attrs map[string]any
+	err   error
+	ended bool
+}
+
+func (s *mockSpan) SetAttributes(attrs ...Attribute) {
+	for _, attr := range attrs {
+		s.attrs[attr.Key] = attr.Value
+	}
+}
+
+func (s *mockSpan) RecordError(err error) {
+	s.err = err
+}
+
+func (s *mockSpan) End() {
+	s.ended = true
+}
+
+type mockTracer struct {
+	mu    sync.Mutex
+	spans []*mockSpan
+}
+
+func (t *mockTracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	span := &mockSpan{name: name, attrs: map[string]any{}}
+	span.SetAttributes(attrs...)
+	t.mu.Lock()
+	t.spans = append(t.spans, span)
+	t.mu.Unlock()
+	return ctx, span
+}
+
+func TestTracingHook(t *testing.T) {
+	tracer := &mockTracer{}
+	c, err := NewHookCache(NewMemoryCache(0), NewTracingHook(tracer))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.NoError(t, c.Put(ctx, "key", "value", time.Minute))
+	_, err = c.Get(ctx, "key")
+	require.NoError(t, err)
+	_, _ = c.GetMulti(ctx, []string{"key", "missing"})
+	assert.Error(t, c.Incr(ctx, "key"))
+
+	require.Len(t, tracer.spans, 4)
+	for _, span := range tracer.spans {
+		assert.True(t, span.ended)
+		assert.Equal(t, "cache.MemoryCache", span.attrs[AttrBackend])
+	}
+	assert.Equal(t, "cache.Put", tracer.spans[0].name)
+	assert.Equal(t, map[string]any{
+		AttrOperation: OpGet, AttrBackend: "cache.MemoryCache", AttrKeyCount: 1, AttrHit: true,
+	}, tracer.spans[1].attrs)
+	assert.Equal(t, 2, tracer.spans[2].attrs[AttrKeyCount])
+	assert.Equal(t, 1, tracer.spans[2].attrs[AttrHits])
+	assert.Equal(t, 1, tracer.spans[2].attrs[AttrMisses])
+	assert.Error(t, tracer.spans[3].err)
+}
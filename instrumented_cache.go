@@ -0,0 +1,59 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+
+	berror "github.com/beego/beego-error/v2"
+)
+
+// MetricsCollector collects the observations of InstrumentedCache.
+// Collect is called synchronously for every operation, so it should be fast and safe for concurrent use.
+type MetricsCollector interface {
+	Collect(o Observation)
+}
+
+// InstrumentedCache is a decorator recording the count, hits and misses, errors and latency
+// of every operation into a MetricsCollector.
+// See HookCache for how the hits, misses and errors are counted.
+type InstrumentedCache struct {
+	*HookCache
+}
+
+// NewInstrumentedCache creates InstrumentedCache
+func NewInstrumentedCache(c Cache, collector MetricsCollector) (*InstrumentedCache, error) {
+	if c == nil || collector == nil {
+		return nil, berror.Error(InvalidInitParameters, "cache or collector can not be nil")
+	}
+	hc, err := NewHookCache(c, collectorHook{collector: collector})
+	if err != nil {
+		return nil, err
+	}
+	return &InstrumentedCache{HookCache: hc}, nil
+}
+
+// collectorHook is a Hook passing the observations to a MetricsCollector
+type collectorHook struct {
+	collector MetricsCollector
+}
+
+func (h collectorHook) Before(ctx context.Context, o Observation) context.Context {
+	return ctx
+}
+
+func (h collectorHook) After(ctx context.Context, o Observation) {
+	h.collector.Collect(o)
+}
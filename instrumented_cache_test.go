@@ -0,0 +1,84 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstrumentedCache(t *testing.T) {
+	collector, err := NewMemoryMetricsCollector()
+	require.NoError(t, err)
+	c, err := NewInstrumentedCache(NewMemoryCache(0), collector)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.NoError(t, c.Put(ctx, "key1", "value1", time.Minute))
+	require.NoError(t, c.Put(ctx, "counter", 1, time.Minute))
+	_, err = c.Get(ctx, "key1")
+	require.NoError(t, err)
+	_, err = c.Get(ctx, "missing")
+	assert.Error(t, err)
+	_, err = c.GetMulti(ctx, []string{"key1", "missing", "other"})
+	assert.Error(t, err)
+	ok, err := c.IsExist(ctx, "key1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	require.NoError(t, c.Incr(ctx, "counter"))
+	assert.Error(t, c.Incr(ctx, "key1"))
+
+	get := collector.Stats(OpGet)
+	assert.Equal(t, uint64(2), get.Count)
+	assert.Equal(t, uint64(1), get.Hits)
+	assert.Equal(t, uint64(1), get.Misses)
+	assert.Empty(t, get.Errors)
+	assert.Equal(t, 0.5, get.HitRatio())
+	assert.Equal(t, uint64(2), get.BucketCounts[len(get.BucketCounts)-1])
+
+	multi := collector.Stats(OpGetMulti)
+	assert.Equal(t, uint64(1), multi.Hits)
+	assert.Equal(t, uint64(2), multi.Misses)
+	assert.Empty(t, multi.Errors)
+
+	assert.Equal(t, uint64(1), collector.Stats(OpIsExist).Hits)
+	assert.Equal(t, map[uint32]uint64{NotIntegerType.Code(): 1}, collector.Stats(OpIncr).Errors)
+	assert.Equal(t, uint64(0), collector.Stats(OpClearAll).Count)
+
+	rec := httptest.NewRecorder()
+	collector.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	assert.Contains(t, rec.Header().Get("Content-Type"), "text/plain")
+	assert.Contains(t, string(body), "# TYPE beego_cache_operations_total counter\n")
+	assert.Contains(t, string(body), `beego_cache_operations_total{operation="Get"} 2`)
+	assert.Contains(t, string(body), `beego_cache_hits_total{operation="GetMulti"} 1`)
+	assert.Contains(t, string(body), `beego_cache_errors_total{operation="Incr",code="4002006"} 1`)
+	assert.Contains(t, string(body), `beego_cache_operation_duration_seconds_bucket{operation="Put",le="+Inf"} 2`)
+	assert.Contains(t, string(body), `beego_cache_operation_duration_seconds_count{operation="Put"} 2`)
+}
+
+func TestNewInstrumentedCache(t *testing.T) {
+	_, err := NewInstrumentedCache(NewMemoryCache(0), nil)
+	assert.Error(t, err)
+	_, err = NewMemoryMetricsCollector(WithMemoryMetricsCollectorBuckets(time.Second, time.Millisecond))
+	assert.Error(t, err)
+}
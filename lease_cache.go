@@ -0,0 +1,173 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strconv"
+	"time"
+
+	berror "github.com/beego/beego-error/v2"
+)
+
+const (
+	defaultLeaseWindow       = 3 * time.Second
+	defaultDoubleDeleteDelay = time.Second
+
+	// leaseKeyPrefix is the prefix of the keys of the leases in the underlying cache
+	leaseKeyPrefix = "__lease__:"
+)
+
+// LeaseCacheOption configures the LeaseCache
+type LeaseCacheOption func(c *LeaseCache)
+
+// WithLeaseCacheWindow configures how long a lease lives after the key is written or deleted, default 3s.
+// It should be longer than the slowest loadFunc, a load outliving the lease may fill a stale value.
+func WithLeaseCacheWindow(window time.Duration) LeaseCacheOption {
+	return func(c *LeaseCache) {
+		c.window = window
+	}
+}
+
+// WithLeaseCacheDoubleDelete configures the delay of the second deleting of Delete, default 1s.
+// Zero disables the second deleting.
+func WithLeaseCacheDoubleDelete(delay time.Duration) LeaseCacheOption {
+	return func(c *LeaseCache) {
+		c.doubleDeleteDelay = delay
+	}
+}
+
+// WithLeaseCacheErrorHandler configures the function to handle the errors of the delayed deleting,
+// by default errors are ignored.
+func WithLeaseCacheErrorHandler(fn func(key string, err error)) LeaseCacheOption {
+	return func(c *LeaseCache) {
+		c.onError = fn
+	}
+}
+
+// LeaseCache is a read through decorator for the cache-aside pattern,
+// which prevents the concurrent readers from filling the cache with the values
+// loaded before the record is updated and the key is deleted.
+//
+// Put and Delete issue a new lease of the key, which is a token kept in the underlying cache for the window.
+// Get remembers the lease before calling loadFunc, and only fills the cache
+// if the lease is unchanged after loading, otherwise the loaded value is returned without filling.
+// Because the lease is kept in the underlying cache, it works across the processes sharing a redis.
+//
+// Checking the lease and filling aren't atomic, so Delete deletes the key again after a delay
+// to remove the stale value filled in between.
+type LeaseCache struct {
+	Cache
+	expiration        time.Duration
+	loadFunc          func(ctx context.Context, key string) (any, error)
+	window            time.Duration
+	doubleDeleteDelay time.Duration
+	onError           func(key string, err error)
+}
+
+// NewLeaseCache creates LeaseCache, expiration is the TTL of the loaded values
+func NewLeaseCache(c Cache, expiration time.Duration,
+	loadFunc func(ctx context.Context, key string) (any, error), opts ...LeaseCacheOption,
+) (*LeaseCache, error) {
+	if loadFunc == nil {
+		return nil, berror.Error(InvalidLoadFunc, "loadFunc cannot be nil")
+	}
+	res := &LeaseCache{
+		Cache:             c,
+		expiration:        expiration,
+		loadFunc:          loadFunc,
+		window:            defaultLeaseWindow,
+		doubleDeleteDelay: defaultDoubleDeleteDelay,
+		onError:           func(string, error) {},
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	if res.window <= 0 || res.doubleDeleteDelay < 0 {
+		return nil, berror.Errorf(InvalidInitParameters,
+			"invalid lease window %v or double delete delay %v", res.window, res.doubleDeleteDelay)
+	}
+	return res, nil
+}
+
+// Get loads the missed key, and fills the cache unless the key is written or deleted during loading.
+// ErrKeyNotExist is returned if loadFunc returns ErrNotFound.
+func (c *LeaseCache) Get(ctx context.Context, key string) (any, error) {
+	val, err := c.Cache.Get(ctx, key)
+	if val != nil && err == nil {
+		return val, nil
+	}
+
+	lease := c.lease(ctx, key)
+	val, err = c.loadFunc(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrKeyNotExist
+	}
+	if err != nil {
+		return nil, berror.Wrap(err, LoadFuncFailed, "cache unable to load data")
+	}
+	if c.lease(ctx, key) != lease {
+		return val, nil
+	}
+	return val, c.Cache.Put(ctx, key, val, c.expiration)
+}
+
+// Put issues a new lease of key and puts the value,
+// so the values loaded before are not filled.
+func (c *LeaseCache) Put(ctx context.Context, key string, val any, timeout time.Duration) error {
+	if err := c.issue(ctx, key); err != nil {
+		return err
+	}
+	return c.Cache.Put(ctx, key, val, timeout)
+}
+
+// Delete issues a new lease of key and deletes the value,
+// and deletes it again after the delay if the double deleting is enabled.
+func (c *LeaseCache) Delete(ctx context.Context, key string) error {
+	if err := c.issue(ctx, key); err != nil {
+		return err
+	}
+	if err := c.Cache.Delete(ctx, key); err != nil {
+		return err
+	}
+	if c.doubleDeleteDelay > 0 {
+		ctx = detachedContext{Context: ctx}
+		time.AfterFunc(c.doubleDeleteDelay, func() {
+			if err := c.Cache.Delete(ctx, key); err != nil {
+				c.onError(key, err)
+			}
+		})
+	}
+	return nil
+}
+
+// issue puts a new lease of key for the window.
+// The leases are only compared for equality, so the clocks of the processes don't matter.
+func (c *LeaseCache) issue(ctx context.Context, key string) error {
+	token := strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.FormatInt(rand.Int63(), 36)
+	return c.Cache.Put(ctx, leaseKeyPrefix+key, token, c.window)
+}
+
+// lease returns the current lease of key, or "" if there is none.
+// The errors are treated as no lease, because the adapters report a missing key differently.
+func (c *LeaseCache) lease(ctx context.Context, key string) string {
+	val, err := c.Cache.Get(ctx, leaseKeyPrefix+key)
+	if err != nil {
+		return ""
+	}
+	return GetString(val)
+}
@@ -0,0 +1,116 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	berror "github.com/beego/beego-error/v2"
+)
+
+func TestLeaseCache_StaleFill(t *testing.T) {
+	ctx := context.Background()
+	db := "old"
+	loading, loaded := make(chan struct{}), make(chan struct{})
+	c, err := NewLeaseCache(NewMemoryCache(0), time.Minute, func(ctx context.Context, key string) (any, error) {
+		val := db
+		if key == "slow" && val == "old" {
+			close(loading)
+			<-loaded
+		}
+		return val, nil
+	}, WithLeaseCacheDoubleDelete(0))
+	require.NoError(t, err)
+
+	// the reader loads the old value, then the record is updated and the key is deleted
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		val, err := c.Get(ctx, "slow")
+		assert.NoError(t, err)
+		assert.Equal(t, "old", val)
+	}()
+	<-loading
+	db = "new"
+	require.NoError(t, c.Delete(ctx, "slow"))
+	close(loaded)
+	<-done
+
+	// the old value isn't filled
+	ok, err := c.IsExist(ctx, "slow")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	val, err := c.Get(ctx, "slow")
+	require.NoError(t, err)
+	assert.Equal(t, "new", val)
+
+	// filled when nothing changes
+	val, err = c.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "new", val)
+	ok, err = c.IsExist(ctx, "key")
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestLeaseCache_DoubleDelete(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMemoryCache(0)
+	c, err := NewLeaseCache(mc, time.Minute, func(ctx context.Context, key string) (any, error) {
+		return "value", nil
+	}, WithLeaseCacheDoubleDelete(50*time.Millisecond))
+	require.NoError(t, err)
+
+	require.NoError(t, c.Put(ctx, "key", "value", time.Minute))
+	require.NoError(t, c.Delete(ctx, "key"))
+	// a stale value filled between checking the lease and filling
+	require.NoError(t, mc.Put(ctx, "key", "stale", time.Minute))
+	assert.Eventually(t, func() bool {
+		ok, err := mc.IsExist(ctx, "key")
+		return err == nil && !ok
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestLeaseCache_Get(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewLeaseCache(NewMemoryCache(0), time.Minute, func(ctx context.Context, key string) (any, error) {
+		if key == "missing" {
+			return nil, ErrNotFound
+		}
+		return nil, errors.New("db is down")
+	})
+	require.NoError(t, err)
+
+	_, err = c.Get(ctx, "missing")
+	assert.Equal(t, ErrKeyNotExist, err)
+	_, err = c.Get(ctx, "key")
+	code, _ := berror.FromError(err)
+	assert.Equal(t, LoadFuncFailed.Code(), code.Code())
+}
+
+func TestNewLeaseCache(t *testing.T) {
+	_, err := NewLeaseCache(NewMemoryCache(0), time.Minute, nil)
+	assert.Error(t, err)
+	_, err = NewLeaseCache(NewMemoryCache(0), time.Minute, func(ctx context.Context, key string) (any, error) {
+		return nil, nil
+	}, WithLeaseCacheWindow(0))
+	assert.Error(t, err)
+}
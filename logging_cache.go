@@ -0,0 +1,195 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.21
+
+// The logging cache uses log/slog, so it is only built with Go 1.21 or later,
+// while the rest of the package supports Go 1.18. See README.
+
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"time"
+
+	berror "github.com/beego/beego-error/v2"
+)
+
+const defaultSlowThreshold = 100 * time.Millisecond
+
+// LoggingCacheOption configures the cache created by NewLoggingCache
+type LoggingCacheOption func(l *cacheLogger)
+
+// WithLoggingCacheLevel configures the level of the successful operations, default slog.LevelDebug.
+// The slow operations are logged at slog.LevelWarn and the failed ones at slog.LevelError.
+func WithLoggingCacheLevel(level slog.Level) LoggingCacheOption {
+	return func(l *cacheLogger) {
+		l.level = level
+	}
+}
+
+// WithLoggingCacheKeyRedactor configures how the keys are logged, like RedactString and HashString.
+// By default the keys are logged as they are.
+func WithLoggingCacheKeyRedactor(fn func(key string) string) LoggingCacheOption {
+	return func(l *cacheLogger) {
+		l.redactKey = fn
+	}
+}
+
+// WithLoggingCacheValueRedactor enables logging the values of Put, and configures how they are logged.
+// By default the values are not logged.
+func WithLoggingCacheValueRedactor(fn func(val any) string) LoggingCacheOption {
+	return func(l *cacheLogger) {
+		l.redactValue = fn
+	}
+}
+
+// WithLoggingCacheSampleRate configures the fraction of the successful operations logged, default 1.
+// The slow and failed operations are always logged.
+func WithLoggingCacheSampleRate(rate float64) LoggingCacheOption {
+	return func(l *cacheLogger) {
+		l.sampleRate = rate
+	}
+}
+
+// WithLoggingCacheSlowThreshold configures the duration from which an operation is slow, default 100ms.
+// Zero disables it.
+func WithLoggingCacheSlowThreshold(threshold time.Duration) LoggingCacheOption {
+	return func(l *cacheLogger) {
+		l.slowThreshold = threshold
+	}
+}
+
+// RedactString replaces s with [REDACTED].
+func RedactString(s string) string {
+	return "[REDACTED]"
+}
+
+// HashString replaces s with the first 16 hex digits of its sha256,
+// so that the logs of a key can be correlated without exposing it.
+func HashString(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:8])
+}
+
+// HashValue replaces val with the hash of its default format, see HashString.
+func HashValue(val any) string {
+	return HashString(fmt.Sprint(val))
+}
+
+// cacheLogger logs the operations
+type cacheLogger struct {
+	logger        *slog.Logger
+	level         slog.Level
+	redactKey     func(key string) string
+	redactValue   func(val any) string
+	sampleRate    float64
+	slowThreshold time.Duration
+}
+
+// NewLoggingCache creates a Cache logging the operations of c with logger,
+// which is Chain(c, LoggingInterceptor(logger, opts...)).
+//
+// The misses are not errors, see HookCache. The failed operations are logged with the berror code.
+func NewLoggingCache(c Cache, logger *slog.Logger, opts ...LoggingCacheOption) (Cache, error) {
+	if c == nil {
+		return nil, berror.Error(InvalidInitParameters, "cache can not be nil")
+	}
+	interceptor, err := LoggingInterceptor(logger, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return Chain(c, interceptor), nil
+}
+
+// LoggingInterceptor returns an Interceptor logging every operation with logger, see NewLoggingCache.
+func LoggingInterceptor(logger *slog.Logger, opts ...LoggingCacheOption) (Interceptor, error) {
+	if logger == nil {
+		return nil, berror.Error(InvalidInitParameters, "logger can not be nil")
+	}
+	l := &cacheLogger{
+		logger:        logger,
+		level:         slog.LevelDebug,
+		redactKey:     func(key string) string { return key },
+		sampleRate:    1,
+		slowThreshold: defaultSlowThreshold,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if l.sampleRate < 0 || l.sampleRate > 1 || l.slowThreshold < 0 {
+		return nil, berror.Errorf(InvalidInitParameters,
+			"invalid sample rate %v or slow threshold %v", l.sampleRate, l.slowThreshold)
+	}
+	return l.intercept, nil
+}
+
+func (l *cacheLogger) intercept(ctx context.Context, inv Invocation, next Invoker) Result {
+	start := time.Now()
+	res := next(ctx, inv)
+	o := Observation{Operation: inv.Operation, Keys: inv.Keys, Backend: inv.Backend, Duration: time.Since(start)}
+	o.observe(res)
+
+	level, msg := l.level, "cache operation"
+	switch {
+	case o.Err != nil:
+		level, msg = slog.LevelError, "cache operation failed"
+	case l.slowThreshold > 0 && o.Duration >= l.slowThreshold:
+		level, msg = slog.LevelWarn, "slow cache operation"
+	case l.sampleRate < 1 && rand.Float64() >= l.sampleRate:
+		return res
+	}
+	if !l.logger.Enabled(ctx, level) {
+		return res
+	}
+	l.logger.LogAttrs(ctx, level, msg, l.attrs(inv, o)...)
+	return res
+}
+
+func (l *cacheLogger) attrs(inv Invocation, o Observation) []slog.Attr {
+	attrs := []slog.Attr{
+		slog.String("operation", o.Operation),
+		slog.String("backend", o.Backend),
+		slog.Duration("duration", o.Duration),
+	}
+	if len(o.Keys) == 1 {
+		attrs = append(attrs, slog.String("key", l.redactKey(o.Keys[0])))
+	} else if len(o.Keys) > 1 {
+		keys := make([]string, 0, len(o.Keys))
+		for _, key := range o.Keys {
+			keys = append(keys, l.redactKey(key))
+		}
+		attrs = append(attrs, slog.Any("keys", keys))
+	}
+	if o.Operation == OpPut && l.redactValue != nil {
+		attrs = append(attrs, slog.String("value", l.redactValue(inv.Val)))
+	}
+	switch o.Operation {
+	case OpGet, OpGetMulti, OpIsExist:
+		if o.Err == nil {
+			attrs = append(attrs, slog.Int("hits", o.Hits), slog.Int("misses", o.Misses))
+		}
+	}
+	if o.Err != nil {
+		code, _ := berror.FromError(o.Err)
+		attrs = append(attrs, slog.Any("error", o.Err),
+			slog.Uint64("code", uint64(code.Code())), slog.String("code_name", code.Name()))
+	}
+	return attrs
+}
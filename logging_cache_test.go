@@ -0,0 +1,94 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.21
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger(buf *bytes.Buffer) *slog.Logger {
+	return slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey || a.Key == "duration" {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+}
+
+func TestLoggingCache(t *testing.T) {
+	var buf bytes.Buffer
+	c, err := NewLoggingCache(NewMemoryCache(0), newTestLogger(&buf),
+		WithLoggingCacheKeyRedactor(HashString), WithLoggingCacheValueRedactor(HashValue))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.NoError(t, c.Put(ctx, "secret", "password", time.Minute))
+	_, err = c.Get(ctx, "missing")
+	assert.Error(t, err)
+	assert.Error(t, c.Incr(ctx, "secret"))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, `level=DEBUG msg="cache operation" operation=Put backend=cache.MemoryCache key=`+
+		HashString("secret")+" value="+HashValue("password"), lines[0])
+	assert.Contains(t, lines[1], "msg=\"cache operation\" operation=Get")
+	assert.Contains(t, lines[1], "hits=0 misses=1")
+	assert.Contains(t, lines[2], "level=ERROR msg=\"cache operation failed\" operation=Incr")
+	assert.Contains(t, lines[2], "code=4002006 code_name=NotIntegerType")
+	assert.NotContains(t, buf.String(), "secret")
+	assert.NotContains(t, buf.String(), "password")
+}
+
+func TestLoggingCache_SampleAndSlow(t *testing.T) {
+	var buf bytes.Buffer
+	slow := func(ctx context.Context, inv Invocation, next Invoker) Result {
+		if inv.Keys[0] == "slow" {
+			time.Sleep(20 * time.Millisecond)
+		}
+		return next(ctx, inv)
+	}
+	interceptor, err := LoggingInterceptor(newTestLogger(&buf),
+		WithLoggingCacheSampleRate(0), WithLoggingCacheSlowThreshold(10*time.Millisecond),
+		WithLoggingCacheKeyRedactor(RedactString))
+	require.NoError(t, err)
+	c := Chain(NewMemoryCache(0), interceptor, slow)
+	ctx := context.Background()
+
+	// the successful calls are not sampled
+	require.NoError(t, c.Put(ctx, "key", "value", time.Minute))
+	assert.Empty(t, buf.String())
+	// the slow calls are always logged
+	require.NoError(t, c.Put(ctx, "slow", "value", time.Minute))
+	assert.Contains(t, buf.String(), `level=WARN msg="slow cache operation" operation=Put`)
+	assert.Contains(t, buf.String(), "key=[REDACTED]")
+
+	_, err = NewLoggingCache(NewMemoryCache(0), nil)
+	assert.Error(t, err)
+	_, err = LoggingInterceptor(newTestLogger(&buf), WithLoggingCacheSampleRate(2))
+	assert.Error(t, err)
+}
@@ -16,6 +16,7 @@ package memcache
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -25,6 +26,22 @@ import (
 	"github.com/bradfitz/gomemcache/memcache"
 )
 
+func init() {
+	cache.RegisterErrorClassifier(classifyError)
+}
+
+// classifyError tells the misses and the failures of gomemcache,
+// the adapter wraps both in cache.MemCacheCurdFailed.
+func classifyError(err error) cache.ErrorClass {
+	switch {
+	case errors.Is(err, memcache.ErrCacheMiss):
+		return cache.ErrorClassMiss
+	case errors.Is(err, memcache.ErrNoServers), errors.Is(err, memcache.ErrServerError):
+		return cache.ErrorClassFailure
+	}
+	return cache.ErrorClassUnknown
+}
+
 // Cache Memcache adapter.
 type Cache struct {
 	conn     *memcache.Client
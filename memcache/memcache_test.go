@@ -0,0 +1,203 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memcache
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cache "github.com/beego/beego-cache/v2"
+	"github.com/beego/beego-cache/v2/server/memcached"
+)
+
+// newLocalMemCache returns a Cache connected to an in-process memcached server.
+func newLocalMemCache(t *testing.T) cache.Cache {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := memcached.NewServer(cache.NewMemoryCache(0))
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+	t.Cleanup(func() {
+		_ = srv.Close()
+	})
+	return NewMemCache(memcache.New(ln.Addr().String()))
+}
+
+func TestCache_Local_GetPut(t *testing.T) {
+	c := newLocalMemCache(t)
+	ctx := context.Background()
+
+	testCases := []struct {
+		name    string
+		key     string
+		value   any
+		want    []byte
+		wantErr bool
+	}{
+		{
+			name:  "string",
+			key:   "key1",
+			value: "author",
+			want:  []byte("author"),
+		},
+		{
+			name:  "bytes",
+			key:   "key2",
+			value: []byte("author"),
+			want:  []byte("author"),
+		},
+		{
+			name:    "invalid value",
+			key:     "key3",
+			value:   1,
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := c.Put(ctx, tc.key, tc.value, time.Second)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			val, err := c.Get(ctx, tc.key)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, val)
+		})
+	}
+
+	_, err := c.Get(ctx, "key3")
+	assert.Error(t, err)
+}
+
+func TestCache_Local_GetMulti(t *testing.T) {
+	c := newLocalMemCache(t)
+	ctx := context.Background()
+
+	require.NoError(t, c.Put(ctx, "key1", "value1", time.Second))
+	require.NoError(t, c.Put(ctx, "key2", "value2", time.Second))
+
+	vals, err := c.GetMulti(ctx, []string{"key1", "key2"})
+	require.NoError(t, err)
+	assert.Equal(t, []any{[]byte("value1"), []byte("value2")}, vals)
+
+	vals, err = c.GetMulti(ctx, []string{"key1", "key3"})
+	assert.Error(t, err)
+	assert.Equal(t, []any{[]byte("value1"), nil}, vals)
+}
+
+func TestCache_Local_DeleteAndIsExist(t *testing.T) {
+	c := newLocalMemCache(t)
+	ctx := context.Background()
+
+	require.NoError(t, c.Put(ctx, "key1", "value1", time.Second))
+	ok, err := c.IsExist(ctx, "key1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	require.NoError(t, c.Delete(ctx, "key1"))
+	ok, _ = c.IsExist(ctx, "key1")
+	assert.False(t, ok)
+}
+
+func TestCache_Local_IncrAndDecr(t *testing.T) {
+	c := newLocalMemCache(t)
+	ctx := context.Background()
+
+	require.NoError(t, c.Put(ctx, "counter", "1", time.Second))
+	require.NoError(t, c.Incr(ctx, "counter"))
+	val, err := c.Get(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("2"), val)
+
+	require.NoError(t, c.Decr(ctx, "counter"))
+	val, err = c.Get(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("1"), val)
+
+	assert.Error(t, c.Incr(ctx, "missing"))
+}
+
+func TestCache_Local_ClearAll(t *testing.T) {
+	c := newLocalMemCache(t)
+	ctx := context.Background()
+
+	require.NoError(t, c.Put(ctx, "key1", "value1", time.Second))
+	require.NoError(t, c.ClearAll(ctx))
+	ok, _ := c.IsExist(ctx, "key1")
+	assert.False(t, ok)
+}
+
+func TestCache_Local_Retry(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := memcached.NewServer(cache.NewMemoryCache(0))
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+	var calls int32
+	c, err := cache.NewRetryCache(cache.Chain(NewMemCache(memcache.New(ln.Addr().String())),
+		func(ctx context.Context, inv cache.Invocation, next cache.Invoker) cache.Result {
+			atomic.AddInt32(&calls, 1)
+			return next(ctx, inv)
+		}), cache.WithRetryCacheBackoff(time.Millisecond, time.Millisecond), cache.WithRetryCacheBudget(0, 0))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// a miss is not retried
+	_, err = c.Get(ctx, "missing")
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// a network error is
+	require.NoError(t, srv.Close())
+	_, err = c.Get(ctx, "missing")
+	assert.True(t, cache.IsRetriable(err))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+}
+
+func TestCache_Local_CircuitBreaker(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := memcached.NewServer(cache.NewMemoryCache(0))
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+	c, err := cache.NewCircuitBreakerCache(NewMemCache(memcache.New(ln.Addr().String())))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// the misses don't open the circuit
+	for i := 0; i < 10; i++ {
+		_, err = c.Get(ctx, "missing")
+		assert.Error(t, err)
+	}
+	assert.Equal(t, cache.CircuitClosed, c.State())
+
+	require.NoError(t, srv.Close())
+	for i := 0; i < 5; i++ {
+		_, _ = c.Get(ctx, "missing")
+	}
+	assert.Equal(t, cache.CircuitOpen, c.State())
+}
@@ -0,0 +1,210 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	berror "github.com/beego/beego-error/v2"
+)
+
+const defaultMetricsNamespace = "beego_cache"
+
+// defaultLatencyBuckets are the upper bounds of the latency histogram, from 100µs to 5s
+var defaultLatencyBuckets = []time.Duration{
+	100 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 500 * time.Millisecond, time.Second, 5 * time.Second,
+}
+
+// MemoryMetricsCollectorOption configures the MemoryMetricsCollector
+type MemoryMetricsCollectorOption func(c *MemoryMetricsCollector)
+
+// WithMemoryMetricsCollectorBuckets configures the upper bounds of the latency histogram,
+// default from 100µs to 5s.
+func WithMemoryMetricsCollectorBuckets(buckets ...time.Duration) MemoryMetricsCollectorOption {
+	return func(c *MemoryMetricsCollector) {
+		c.buckets = buckets
+	}
+}
+
+// WithMemoryMetricsCollectorNamespace configures the prefix of the metric names, default beego_cache.
+func WithMemoryMetricsCollectorNamespace(namespace string) MemoryMetricsCollectorOption {
+	return func(c *MemoryMetricsCollector) {
+		c.namespace = namespace
+	}
+}
+
+// OperationStats is the statistics of an operation collected by MemoryMetricsCollector.
+type OperationStats struct {
+	Count  uint64
+	Hits   uint64
+	Misses uint64
+	// error code -> count
+	Errors map[uint32]uint64
+	// BucketCounts[i] is the number of the operations no slower than the i-th bucket,
+	// the last one is the number of all the operations
+	BucketCounts  []uint64
+	TotalDuration time.Duration
+}
+
+// HitRatio returns Hits / (Hits + Misses), or 0 if there is no hit or miss.
+func (s OperationStats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// MemoryMetricsCollector is a MetricsCollector keeping the metrics in memory,
+// and Handler exposes them in the Prometheus text format.
+type MemoryMetricsCollector struct {
+	namespace string
+	buckets   []time.Duration
+
+	mu  sync.Mutex
+	ops map[string]*OperationStats
+}
+
+// NewMemoryMetricsCollector creates MemoryMetricsCollector
+func NewMemoryMetricsCollector(opts ...MemoryMetricsCollectorOption) (*MemoryMetricsCollector, error) {
+	c := &MemoryMetricsCollector{
+		namespace: defaultMetricsNamespace,
+		buckets:   defaultLatencyBuckets,
+		ops:       make(map[string]*OperationStats),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	for i := 1; i < len(c.buckets); i++ {
+		if c.buckets[i] <= c.buckets[i-1] {
+			return nil, berror.Errorf(InvalidInitParameters, "buckets should be increasing, but got %v", c.buckets)
+		}
+	}
+	return c, nil
+}
+
+// Collect records o.
+func (c *MemoryMetricsCollector) Collect(o Observation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.ops[o.Operation]
+	if !ok {
+		s = &OperationStats{
+			Errors:       make(map[uint32]uint64),
+			BucketCounts: make([]uint64, len(c.buckets)+1),
+		}
+		c.ops[o.Operation] = s
+	}
+	s.Count++
+	s.Hits += uint64(o.Hits)
+	s.Misses += uint64(o.Misses)
+	if o.Err != nil {
+		code, _ := berror.FromError(o.Err)
+		s.Errors[code.Code()]++
+	}
+	for i, bucket := range c.buckets {
+		if o.Duration <= bucket {
+			s.BucketCounts[i]++
+		}
+	}
+	s.BucketCounts[len(c.buckets)]++
+	s.TotalDuration += o.Duration
+}
+
+// Stats returns a copy of the statistics of op, like OpGet.
+func (c *MemoryMetricsCollector) Stats(op string) OperationStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.ops[op]
+	if !ok {
+		return OperationStats{Errors: map[uint32]uint64{}, BucketCounts: make([]uint64, len(c.buckets)+1)}
+	}
+	res := *s
+	res.Errors = make(map[uint32]uint64, len(s.Errors))
+	for code, n := range s.Errors {
+		res.Errors[code] = n
+	}
+	res.BucketCounts = append([]uint64(nil), s.BucketCounts...)
+	return res
+}
+
+// Handler returns a http.Handler writing the metrics in the Prometheus text format.
+func (c *MemoryMetricsCollector) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		c.writeTo(bw)
+		_ = bw.Flush()
+	})
+}
+
+func (c *MemoryMetricsCollector) writeTo(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ops := make([]string, 0, len(c.ops))
+	for op := range c.ops {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+
+	counters := []struct {
+		name, help string
+		value      func(s *OperationStats) uint64
+	}{
+		{"operations_total", "The number of cache operations.", func(s *OperationStats) uint64 { return s.Count }},
+		{"hits_total", "The number of keys found.", func(s *OperationStats) uint64 { return s.Hits }},
+		{"misses_total", "The number of keys not found.", func(s *OperationStats) uint64 { return s.Misses }},
+	}
+	for _, counter := range counters {
+		name := c.namespace + "_" + counter.name
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, counter.help, name)
+		for _, op := range ops {
+			fmt.Fprintf(w, "%s{operation=%q} %d\n", name, op, counter.value(c.ops[op]))
+		}
+	}
+
+	name := c.namespace + "_errors_total"
+	fmt.Fprintf(w, "# HELP %s The number of failed cache operations by error code.\n# TYPE %s counter\n", name, name)
+	for _, op := range ops {
+		codes := make([]uint32, 0, len(c.ops[op].Errors))
+		for code := range c.ops[op].Errors {
+			codes = append(codes, code)
+		}
+		sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+		for _, code := range codes {
+			fmt.Fprintf(w, "%s{operation=%q,code=\"%d\"} %d\n", name, op, code, c.ops[op].Errors[code])
+		}
+	}
+
+	name = c.namespace + "_operation_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s The latency of cache operations.\n# TYPE %s histogram\n", name, name)
+	for _, op := range ops {
+		s := c.ops[op]
+		for i, bucket := range c.buckets {
+			fmt.Fprintf(w, "%s_bucket{operation=%q,le=%q} %d\n",
+				name, op, strconv.FormatFloat(bucket.Seconds(), 'g', -1, 64), s.BucketCounts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{operation=%q,le=\"+Inf\"} %d\n", name, op, s.BucketCounts[len(c.buckets)])
+		fmt.Fprintf(w, "%s_sum{operation=%q} %s\n", name, op, strconv.FormatFloat(s.TotalDuration.Seconds(), 'g', -1, 64))
+		fmt.Fprintf(w, "%s_count{operation=%q} %d\n", name, op, s.Count)
+	}
+}
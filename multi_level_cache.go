@@ -0,0 +1,225 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	berror "github.com/beego/beego-error/v2"
+)
+
+const defaultL1Expiration = time.Minute
+
+// MultiLevelCacheOption configures MultiLevelCache
+type MultiLevelCacheOption func(c *MultiLevelCache)
+
+// WithMultiLevelCacheL1Expiration configures the max expiration of the items in L1, default one minute.
+// Values put into L1 never live longer than it, so that the stale data in L1 is bounded.
+// It must be positive, because the values backfilled from L2 expire after it.
+func WithMultiLevelCacheL1Expiration(expiration time.Duration) MultiLevelCacheOption {
+	return func(c *MultiLevelCache) {
+		c.l1Expiration = expiration
+	}
+}
+
+// MultiLevelCacheStats is the hit and miss counts of each level
+type MultiLevelCacheStats struct {
+	L1Hits   uint64
+	L1Misses uint64
+	L2Hits   uint64
+	L2Misses uint64
+}
+
+// MultiLevelCache is a two-level cache.
+// Usually L1 is a MemoryCache and L2 is a remote cache, like redis.
+// Get reads L1 first, and then reads L2 and backfills L1 if L1 misses.
+// Put writes through both levels, Delete and ClearAll are propagated to both levels.
+type MultiLevelCache struct {
+	l1           Cache
+	l2           Cache
+	l1Expiration time.Duration
+
+	l1Hits   uint64
+	l1Misses uint64
+	l2Hits   uint64
+	l2Misses uint64
+}
+
+// NewMultiLevelCache creates MultiLevelCache
+func NewMultiLevelCache(l1, l2 Cache, opts ...MultiLevelCacheOption) (*MultiLevelCache, error) {
+	if l1 == nil || l2 == nil {
+		return nil, berror.Error(InvalidInitParameters, "l1 and l2 can not be nil")
+	}
+	c := &MultiLevelCache{
+		l1:           l1,
+		l2:           l2,
+		l1Expiration: defaultL1Expiration,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.l1Expiration <= 0 {
+		return nil, berror.Errorf(InvalidInitParameters, "l1 expiration should be positive, but got %v", c.l1Expiration)
+	}
+	return c, nil
+}
+
+// Get reads L1 first. If L1 misses, it reads L2 and puts the value into L1.
+func (c *MultiLevelCache) Get(ctx context.Context, key string) (any, error) {
+	val, err := c.l1.Get(ctx, key)
+	if err == nil && val != nil {
+		atomic.AddUint64(&c.l1Hits, 1)
+		return val, nil
+	}
+	atomic.AddUint64(&c.l1Misses, 1)
+
+	val, err = c.l2.Get(ctx, key)
+	if err != nil || val == nil {
+		atomic.AddUint64(&c.l2Misses, 1)
+		return val, err
+	}
+	atomic.AddUint64(&c.l2Hits, 1)
+	// failing to backfill L1 only makes the next Get slower
+	_ = c.l1.Put(ctx, key, val, c.l1Expiration)
+	return val, nil
+}
+
+// GetMulti reads L1 first, and then reads the keys missed in L1 from L2.
+func (c *MultiLevelCache) GetMulti(ctx context.Context, keys []string) ([]any, error) {
+	vals, _ := c.l1.GetMulti(ctx, keys)
+	if len(vals) != len(keys) {
+		vals = make([]any, len(keys))
+	}
+	missed := make([]string, 0, len(keys))
+	missedIdx := make([]int, 0, len(keys))
+	for i, val := range vals {
+		if val == nil {
+			missed = append(missed, keys[i])
+			missedIdx = append(missedIdx, i)
+		}
+	}
+	atomic.AddUint64(&c.l1Hits, uint64(len(keys)-len(missed)))
+	atomic.AddUint64(&c.l1Misses, uint64(len(missed)))
+	if len(missed) == 0 {
+		return vals, nil
+	}
+
+	l2Vals, err := c.l2.GetMulti(ctx, missed)
+	if len(l2Vals) != len(missed) {
+		atomic.AddUint64(&c.l2Misses, uint64(len(missed)))
+		if err == nil {
+			err = berror.Errorf(MultiGetFailed, "expect %d values but got %d", len(missed), len(l2Vals))
+		}
+		return vals, err
+	}
+
+	keysErr := make([]string, 0)
+	for i, val := range l2Vals {
+		if val == nil {
+			keysErr = append(keysErr, fmt.Sprintf("key [%s] error: %s", missed[i], ErrKeyNotExist.Error()))
+			continue
+		}
+		vals[missedIdx[i]] = val
+		_ = c.l1.Put(ctx, missed[i], val, c.l1Expiration)
+	}
+	atomic.AddUint64(&c.l2Hits, uint64(len(missed)-len(keysErr)))
+	atomic.AddUint64(&c.l2Misses, uint64(len(keysErr)))
+
+	if len(keysErr) == 0 {
+		return vals, nil
+	}
+	return vals, berror.Error(MultiGetFailed, strings.Join(keysErr, "; "))
+}
+
+// Put writes L2 first and then L1, with the shorter expiration of L1.
+// If L1 fails, key is deleted from L1, so that the stale value is not served.
+func (c *MultiLevelCache) Put(ctx context.Context, key string, val any, timeout time.Duration) error {
+	if err := c.l2.Put(ctx, key, val, timeout); err != nil {
+		return err
+	}
+	err := c.l1.Put(ctx, key, val, c.l1Timeout(timeout))
+	if err != nil {
+		_ = c.l1.Delete(ctx, key)
+	}
+	return err
+}
+
+// Delete deletes key from both levels.
+// L1 is always cleaned even if L2 fails, so that the stale value does not stay in L1.
+func (c *MultiLevelCache) Delete(ctx context.Context, key string) error {
+	err := c.l2.Delete(ctx, key)
+	if er := c.l1.Delete(ctx, key); err == nil {
+		err = er
+	}
+	return err
+}
+
+// Incr increases the counter in L2, and then evicts it from L1.
+func (c *MultiLevelCache) Incr(ctx context.Context, key string) error {
+	err := c.l2.Incr(ctx, key)
+	if er := c.l1.Delete(ctx, key); err == nil {
+		err = er
+	}
+	return err
+}
+
+// Decr decreases the counter in L2, and then evicts it from L1.
+func (c *MultiLevelCache) Decr(ctx context.Context, key string) error {
+	err := c.l2.Decr(ctx, key)
+	if er := c.l1.Delete(ctx, key); err == nil {
+		err = er
+	}
+	return err
+}
+
+// IsExist checks L1 first and then L2.
+func (c *MultiLevelCache) IsExist(ctx context.Context, key string) (bool, error) {
+	ok, err := c.l1.IsExist(ctx, key)
+	if err == nil && ok {
+		return true, nil
+	}
+	return c.l2.IsExist(ctx, key)
+}
+
+// ClearAll clears both levels.
+func (c *MultiLevelCache) ClearAll(ctx context.Context) error {
+	err := c.l2.ClearAll(ctx)
+	if er := c.l1.ClearAll(ctx); err == nil {
+		err = er
+	}
+	return err
+}
+
+// Stats returns the hit and miss counts of each level.
+func (c *MultiLevelCache) Stats() MultiLevelCacheStats {
+	return MultiLevelCacheStats{
+		L1Hits:   atomic.LoadUint64(&c.l1Hits),
+		L1Misses: atomic.LoadUint64(&c.l1Misses),
+		L2Hits:   atomic.LoadUint64(&c.l2Hits),
+		L2Misses: atomic.LoadUint64(&c.l2Misses),
+	}
+}
+
+// l1Timeout returns the timeout of the value in L1, never longer than the L1 expiration.
+func (c *MultiLevelCache) l1Timeout(timeout time.Duration) time.Duration {
+	if timeout <= 0 || timeout > c.l1Expiration {
+		return c.l1Expiration
+	}
+	return timeout
+}
@@ -0,0 +1,227 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	berror "github.com/beego/beego-error/v2"
+)
+
+func TestNewMultiLevelCache(t *testing.T) {
+	_, err := NewMultiLevelCache(nil, NewMemoryCache(20))
+	assert.Equal(t, berror.Error(InvalidInitParameters, "l1 and l2 can not be nil"), err)
+	_, err = NewMultiLevelCache(NewMemoryCache(20), nil)
+	assert.NotNil(t, err)
+	_, err = NewMultiLevelCache(NewMemoryCache(20), NewMemoryCache(20), WithMultiLevelCacheL1Expiration(0))
+	assert.NotNil(t, err)
+}
+
+func TestMultiLevelCache_Get(t *testing.T) {
+	l1, l2 := NewMemoryCache(20), NewMemoryCache(20)
+	c, err := NewMultiLevelCache(l1, l2, WithMultiLevelCacheL1Expiration(100*time.Millisecond))
+	require.Nil(t, err)
+	ctx := context.Background()
+
+	require.Nil(t, l2.Put(ctx, "key1", "value1", time.Minute))
+
+	// read from l2 and backfill l1
+	val, err := c.Get(ctx, "key1")
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", val)
+	val, err = l1.Get(ctx, "key1")
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", val)
+
+	// read from l1
+	val, err = c.Get(ctx, "key1")
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", val)
+
+	_, err = c.Get(ctx, "key2")
+	assert.Equal(t, ErrKeyNotExist, err)
+
+	// l1 expires earlier than l2
+	time.Sleep(150 * time.Millisecond)
+	ok, _ := l1.IsExist(ctx, "key1")
+	assert.False(t, ok)
+	val, err = c.Get(ctx, "key1")
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", val)
+
+	assert.Equal(t, MultiLevelCacheStats{
+		L1Hits:   1,
+		L1Misses: 3,
+		L2Hits:   2,
+		L2Misses: 1,
+	}, c.Stats())
+}
+
+func TestMultiLevelCache_GetMulti(t *testing.T) {
+	l1, l2 := NewMemoryCache(20), NewMemoryCache(20)
+	c, err := NewMultiLevelCache(l1, l2)
+	require.Nil(t, err)
+	ctx := context.Background()
+
+	require.Nil(t, l1.Put(ctx, "key1", "value1", time.Minute))
+	require.Nil(t, l2.Put(ctx, "key2", "value2", time.Minute))
+
+	vals, err := c.GetMulti(ctx, []string{"key1", "key2"})
+	assert.Nil(t, err)
+	assert.Equal(t, []any{"value1", "value2"}, vals)
+	ok, _ := l1.IsExist(ctx, "key2")
+	assert.True(t, ok)
+
+	vals, err = c.GetMulti(ctx, []string{"key1", "key3"})
+	assert.NotNil(t, err)
+	assert.Equal(t, []any{"value1", nil}, vals)
+
+	assert.Equal(t, MultiLevelCacheStats{
+		L1Hits:   2,
+		L1Misses: 2,
+		L2Hits:   1,
+		L2Misses: 1,
+	}, c.Stats())
+}
+
+func TestMultiLevelCache_Put(t *testing.T) {
+	testCases := []struct {
+		name         string
+		l1Expiration time.Duration
+		timeout      time.Duration
+		wantL1       time.Duration
+	}{
+		{
+			name:         "shorter l1 expiration",
+			l1Expiration: 100 * time.Millisecond,
+			timeout:      time.Minute,
+			wantL1:       100 * time.Millisecond,
+		},
+		{
+			name:         "never expire",
+			l1Expiration: 100 * time.Millisecond,
+			timeout:      0,
+			wantL1:       100 * time.Millisecond,
+		},
+		{
+			name:         "shorter timeout",
+			l1Expiration: time.Minute,
+			timeout:      100 * time.Millisecond,
+			wantL1:       100 * time.Millisecond,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l1, l2 := NewMemoryCache(20), NewMemoryCache(20)
+			c, err := NewMultiLevelCache(l1, l2, WithMultiLevelCacheL1Expiration(tc.l1Expiration))
+			require.Nil(t, err)
+			ctx := context.Background()
+
+			assert.Nil(t, c.Put(ctx, "key", "value", tc.timeout))
+			assert.Equal(t, tc.wantL1, l1.(*MemoryCache).items["key"].lifespan)
+			assert.Equal(t, tc.timeout, l2.(*MemoryCache).items["key"].lifespan)
+		})
+	}
+}
+
+func TestMultiLevelCache_PutL1Failed(t *testing.T) {
+	l1 := &mockPutFailedCache{Cache: NewMemoryCache(20)}
+	c, err := NewMultiLevelCache(l1, NewMemoryCache(20))
+	require.Nil(t, err)
+	ctx := context.Background()
+
+	require.Nil(t, l1.Cache.Put(ctx, "key", "stale", time.Minute))
+	assert.Equal(t, errMockFailed, c.Put(ctx, "key", "value", time.Minute))
+	// the stale value is deleted from l1
+	val, err := c.Get(ctx, "key")
+	assert.Nil(t, err)
+	assert.Equal(t, "value", val)
+}
+
+func TestMultiLevelCache_Delete(t *testing.T) {
+	l1 := NewMemoryCache(20)
+	c, err := NewMultiLevelCache(l1, &mockFailedCache{Cache: NewMemoryCache(20)})
+	require.Nil(t, err)
+	ctx := context.Background()
+
+	require.Nil(t, l1.Put(ctx, "key", "value", time.Minute))
+	// l1 should be cleaned even if l2 fails
+	assert.Equal(t, errMockFailed, c.Delete(ctx, "key"))
+	ok, _ := l1.IsExist(ctx, "key")
+	assert.False(t, ok)
+
+	require.Nil(t, l1.Put(ctx, "key", "value", time.Minute))
+	assert.Equal(t, errMockFailed, c.ClearAll(ctx))
+	ok, _ = l1.IsExist(ctx, "key")
+	assert.False(t, ok)
+}
+
+func TestMultiLevelCache_IncrDecr(t *testing.T) {
+	l1, l2 := NewMemoryCache(20), NewMemoryCache(20)
+	c, err := NewMultiLevelCache(l1, l2)
+	require.Nil(t, err)
+	ctx := context.Background()
+
+	require.Nil(t, c.Put(ctx, "counter", 1, time.Minute))
+	assert.Nil(t, c.Incr(ctx, "counter"))
+	ok, _ := l1.IsExist(ctx, "counter")
+	assert.False(t, ok)
+	val, err := c.Get(ctx, "counter")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, val)
+
+	assert.Nil(t, c.Decr(ctx, "counter"))
+	val, err = c.Get(ctx, "counter")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, val)
+
+	ok, err = c.IsExist(ctx, "counter")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+var errMockFailed = errors.New("mock failed")
+
+// mockFailedCache fails all the write operations
+type mockFailedCache struct {
+	Cache
+}
+
+func (m *mockFailedCache) Put(context.Context, string, any, time.Duration) error {
+	return errMockFailed
+}
+
+func (m *mockFailedCache) Delete(context.Context, string) error {
+	return errMockFailed
+}
+
+func (m *mockFailedCache) ClearAll(context.Context) error {
+	return errMockFailed
+}
+
+// mockPutFailedCache fails Put only
+type mockPutFailedCache struct {
+	Cache
+}
+
+func (m *mockPutFailedCache) Put(context.Context, string, any, time.Duration) error {
+	return errMockFailed
+}
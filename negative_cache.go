@@ -0,0 +1,84 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	berror "github.com/beego/beego-error/v2"
+)
+
+// tombstone is cached for the records which don't exist, so that loadFunc isn't called again.
+// It is a string so that every adapter can store it.
+const tombstone = "\x00beego-cache:tombstone\x00"
+
+// isTombstone reports whether val is a tombstone read from any adapter.
+func isTombstone(val any) bool {
+	switch v := val.(type) {
+	case string:
+		return v == tombstone
+	case []byte:
+		return string(v) == tombstone
+	}
+	return false
+}
+
+// putTombstone caches a tombstone of key for ttl if ttl is positive,
+// and returns ErrKeyNotExist if the tombstone is put.
+func putTombstone(ctx context.Context, c Cache, key string, ttl time.Duration) error {
+	if ttl > 0 {
+		if err := c.Put(ctx, key, tombstone, ttl); err != nil {
+			return err
+		}
+	}
+	return ErrKeyNotExist
+}
+
+// getMultiSkipTombstones gets the values of keys from c, the tombstones are misses.
+func getMultiSkipTombstones(ctx context.Context, c Cache, keys []string) ([]any, error) {
+	vals, err := c.GetMulti(ctx, keys)
+	keysErr := make([]string, 0)
+	for i, val := range vals {
+		if isTombstone(val) && i < len(keys) {
+			vals[i] = nil
+			keysErr = append(keysErr, fmt.Sprintf("key [%s] error: %s", keys[i], ErrKeyNotExist.Error()))
+		}
+	}
+	if len(keysErr) == 0 {
+		return vals, err
+	}
+	if err != nil {
+		return vals, berror.Wrap(err, MultiGetFailed, strings.Join(keysErr, "; "))
+	}
+	return vals, berror.Error(MultiGetFailed, strings.Join(keysErr, "; "))
+}
+
+// isExistSkipTombstone reports whether key exists in c and is not a tombstone.
+// The value is got to tell, so an existing key costs two round trips.
+func isExistSkipTombstone(ctx context.Context, c Cache, key string) (bool, error) {
+	ok, err := c.IsExist(ctx, key)
+	if err != nil || !ok {
+		return ok, err
+	}
+	val, err := c.Get(ctx, key)
+	if err != nil {
+		// expired in between or failed, IsExist tells
+		return ok, nil
+	}
+	return !isTombstone(val), nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bits-and-blooms/bloom/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegativeCache(t *testing.T) {
+	testCases := []struct {
+		name     string
+		newCache func(loadFunc func(context.Context, string) (any, error), negativeTTL time.Duration) Cache
+	}{
+		{
+			name: "read through",
+			newCache: func(loadFunc func(context.Context, string) (any, error), negativeTTL time.Duration) Cache {
+				c, err := NewReadThroughCache(NewMemoryCache(0), time.Minute, loadFunc,
+					WithReadThroughCacheNegativeTTL(negativeTTL))
+				require.NoError(t, err)
+				return c
+			},
+		},
+		{
+			name: "singleflight",
+			newCache: func(loadFunc func(context.Context, string) (any, error), negativeTTL time.Duration) Cache {
+				c, err := NewSingleflightCache(NewMemoryCache(0), time.Minute, loadFunc,
+					WithSingleflightCacheNegativeTTL(negativeTTL))
+				require.NoError(t, err)
+				return c
+			},
+		},
+		{
+			name: "bloom filter",
+			newCache: func(loadFunc func(context.Context, string) (any, error), negativeTTL time.Duration) Cache {
+				blm := &BloomFilterMock{BloomFilter: bloom.NewWithEstimates(100, 0.01), lock: &sync.RWMutex{}}
+				blm.Add("missing")
+				c, err := NewBloomFilterCache(NewMemoryCache(0), loadFunc, blm, time.Minute,
+					WithBloomFilterCacheNegativeTTL(negativeTTL))
+				require.NoError(t, err)
+				return c
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var loads int32
+			loadFunc := func(ctx context.Context, key string) (any, error) {
+				atomic.AddInt32(&loads, 1)
+				return nil, fmt.Errorf("query %s: %w", key, ErrNotFound)
+			}
+			ctx := context.Background()
+
+			c := tc.newCache(loadFunc, 50*time.Millisecond)
+			for i := 0; i < 3; i++ {
+				_, err := c.Get(ctx, "missing")
+				assert.Equal(t, ErrKeyNotExist, err)
+			}
+			assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+			// the tombstone is hidden from GetMulti and IsExist
+			require.NoError(t, c.Put(ctx, "present", "value", time.Minute))
+			vals, err := c.GetMulti(ctx, []string{"missing", "present"})
+			assert.Error(t, err)
+			assert.Equal(t, []any{nil, "value"}, vals)
+			exist, err := c.IsExist(ctx, "missing")
+			require.NoError(t, err)
+			assert.False(t, exist)
+			exist, err = c.IsExist(ctx, "present")
+			require.NoError(t, err)
+			assert.True(t, exist)
+			// loaded again after the tombstone expires
+			time.Sleep(60 * time.Millisecond)
+			_, err = c.Get(ctx, "missing")
+			assert.Equal(t, ErrKeyNotExist, err)
+			assert.Equal(t, int32(2), atomic.LoadInt32(&loads))
+
+			// no tombstone by default
+			c = tc.newCache(loadFunc, 0)
+			for i := 0; i < 2; i++ {
+				_, err = c.Get(ctx, "missing")
+				assert.Equal(t, ErrKeyNotExist, err)
+			}
+			assert.Equal(t, int32(4), atomic.LoadInt32(&loads))
+		})
+	}
+}
+
+func TestIsTombstone(t *testing.T) {
+	assert.True(t, isTombstone(tombstone))
+	assert.True(t, isTombstone([]byte(tombstone)))
+	assert.False(t, isTombstone("value"))
+	assert.False(t, isTombstone(nil))
+}
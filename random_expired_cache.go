@@ -17,59 +17,142 @@ package cache
 import (
 	"context"
 	"math/rand"
-	"sync/atomic"
+	"sort"
+	"sync"
 	"time"
 )
 
+// defaultJitterFraction is the fraction of the timeout jittered by default
+const defaultJitterFraction = 0.1
+
+// JitterFunc returns the offset added to timeout, r is the random source of the cache.
+type JitterFunc func(r *rand.Rand, timeout time.Duration) time.Duration
+
+// ProportionalJitter returns a JitterFunc whose offset is uniform in [-fraction*timeout, fraction*timeout].
+// fraction should be in [0, 1).
+func ProportionalJitter(fraction float64) JitterFunc {
+	return func(r *rand.Rand, timeout time.Duration) time.Duration {
+		return time.Duration((r.Float64()*2 - 1) * fraction * float64(timeout))
+	}
+}
+
+// UniformJitter returns a JitterFunc whose offset is uniform in [min, max), regardless of the timeout.
+func UniformJitter(min, max time.Duration) JitterFunc {
+	return func(r *rand.Rand, timeout time.Duration) time.Duration {
+		if max <= min {
+			return min
+		}
+		return min + time.Duration(r.Int63n(int64(max-min)))
+	}
+}
+
+// ExponentialJitter returns a JitterFunc whose offset is exponentially distributed with mean,
+// and bounded by max. Most of the keys expire soon after their timeout, and a few expire much later.
+func ExponentialJitter(mean, max time.Duration) JitterFunc {
+	return func(r *rand.Rand, timeout time.Duration) time.Duration {
+		offset := time.Duration(r.ExpFloat64() * float64(mean))
+		if offset > max {
+			return max
+		}
+		return offset
+	}
+}
+
 // RandomExpireCacheOption implement genreate random time offset expired option
 type RandomExpireCacheOption func(*RandomExpireCache)
 
-// WithRandomExpireCacheOffsetFunc returns a RandomExpireCacheOption that configures the offset function
+// WithRandomExpireCacheOffsetFunc returns a RandomExpireCacheOption that configures the offset function,
+// the offset doesn't depend on the timeout. Prefer WithRandomExpireCacheJitter.
 func WithRandomExpireCacheOffsetFunc(fn func() time.Duration) RandomExpireCacheOption {
 	return func(cache *RandomExpireCache) {
-		cache.offset = fn
+		cache.jitter = func(*rand.Rand, time.Duration) time.Duration {
+			return fn()
+		}
+	}
+}
+
+// WithRandomExpireCacheJitter configures how the timeout is jittered, default ProportionalJitter(0.1).
+func WithRandomExpireCacheJitter(jitter JitterFunc) RandomExpireCacheOption {
+	return func(cache *RandomExpireCache) {
+		cache.jitter = jitter
+	}
+}
+
+// WithRandomExpireCacheSeed configures the seed of the random source, so that the jitter is reproducible.
+// By default the current time is used.
+func WithRandomExpireCacheSeed(seed int64) RandomExpireCacheOption {
+	return func(cache *RandomExpireCache) {
+		cache.rand = rand.New(rand.NewSource(seed))
 	}
 }
 
 // RandomExpireCache prevent cache batch invalidation
-// Cache random time offset expired
+// Cache random time offset expired.
+// The timeout 0, which means never expiring, is not jittered.
 type RandomExpireCache struct {
 	Cache
-	offset func() time.Duration
+	jitter JitterFunc
+
+	mu   sync.Mutex
+	rand *rand.Rand
 }
 
 // Put random time offset expired
 func (rec *RandomExpireCache) Put(ctx context.Context, key string, val interface{}, timeout time.Duration) error {
-	timeout += rec.offset()
-	return rec.Cache.Put(ctx, key, val, timeout)
+	return rec.Cache.Put(ctx, key, val, rec.timeout(timeout))
+}
+
+// PutMulti puts the values, the timeout of every key is jittered independently.
+// All the values are tried, the first error is returned.
+func (rec *RandomExpireCache) PutMulti(ctx context.Context, kvs map[string]any, timeout time.Duration) error {
+	keys := make([]string, 0, len(kvs))
+	for key := range kvs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var err error
+	for _, key := range keys {
+		if perr := rec.Put(ctx, key, kvs[key], timeout); perr != nil && err == nil {
+			err = perr
+		}
+	}
+	return err
+}
+
+// Expire resets the timeout of key with jitter.
+// Cache can't change the timeout, so the value is got and put again, which isn't atomic.
+func (rec *RandomExpireCache) Expire(ctx context.Context, key string, timeout time.Duration) error {
+	val, err := rec.Cache.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	return rec.Put(ctx, key, val, timeout)
+}
+
+// timeout returns the jittered timeout, or timeout itself if it is never expiring
+// or the jittered one isn't positive.
+func (rec *RandomExpireCache) timeout(timeout time.Duration) time.Duration {
+	if timeout <= 0 {
+		return timeout
+	}
+	rec.mu.Lock()
+	jittered := timeout + rec.jitter(rec.rand, timeout)
+	rec.mu.Unlock()
+	if jittered <= 0 {
+		return timeout
+	}
+	return jittered
 }
 
 // NewRandomExpireCache return random expire cache struct
 func NewRandomExpireCache(adapter Cache, opts ...RandomExpireCacheOption) Cache {
 	rec := RandomExpireCache{
 		Cache:  adapter,
-		offset: defaultExpiredFunc(),
+		jitter: ProportionalJitter(defaultJitterFraction),
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
 	}
 	for _, fn := range opts {
 		fn(&rec)
 	}
 	return &rec
 }
-
-// defaultExpiredFunc return a func that used to generate random time offset (range: [3s,8s)) expired
-func defaultExpiredFunc() func() time.Duration {
-	const size = 5
-	var randTimes [size]time.Duration
-	for i := range randTimes {
-		randTimes[i] = time.Duration(i+3) * time.Second
-	}
-	// shuffle values
-	for i := range randTimes {
-		n := rand.Intn(size)
-		randTimes[i], randTimes[n] = randTimes[n], randTimes[i]
-	}
-	var i uint64
-	return func() time.Duration {
-		return randTimes[atomic.AddUint64(&i, 1)%size]
-	}
-}
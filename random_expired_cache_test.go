@@ -27,9 +27,9 @@ import (
 func TestRandomExpireCache(t *testing.T) {
 
 	bm := NewMemoryCache(20)
-	cache := NewRandomExpireCache(bm)
+	cache := NewRandomExpireCache(bm, WithRandomExpireCacheJitter(UniformJitter(3*time.Second, 8*time.Second)))
 	// should not be nil
-	assert.NotNil(t, cache.(*RandomExpireCache).offset)
+	assert.NotNil(t, cache.(*RandomExpireCache).jitter)
 
 	timeoutDuration := 3 * time.Second
 
@@ -101,7 +101,7 @@ func TestRandomExpireCacheGet(t *testing.T) {
 				bm := NewMemoryCache(20)
 				cache := NewRandomExpireCache(bm)
 				// should not be nil
-				assert.NotNil(t, cache.(*RandomExpireCache).offset)
+				assert.NotNil(t, cache.(*RandomExpireCache).jitter)
 				return cache
 			}(),
 		},
@@ -113,7 +113,7 @@ func TestRandomExpireCacheGet(t *testing.T) {
 				bm := NewMemoryCache(20)
 				cache := NewRandomExpireCache(bm)
 				// should not be nil
-				assert.NotNil(t, cache.(*RandomExpireCache).offset)
+				assert.NotNil(t, cache.(*RandomExpireCache).jitter)
 				err := cache.Put(context.Background(), "key2", "author", 5*time.Second)
 				assert.Nil(t, err)
 				return cache
@@ -136,7 +136,7 @@ func TestRandomExpireCacheIsExist(t *testing.T) {
 	bm := NewMemoryCache(20)
 	cache := NewRandomExpireCache(bm)
 	// should not be nil
-	assert.NotNil(t, cache.(*RandomExpireCache).offset)
+	assert.NotNil(t, cache.(*RandomExpireCache).jitter)
 	testMemoryCacheIsExist(t, cache)
 }
 
@@ -144,7 +144,7 @@ func TestRandomExpireCacheDelete(t *testing.T) {
 	bm := NewMemoryCache(20)
 	cache := NewRandomExpireCache(bm)
 	// should not be nil
-	assert.NotNil(t, cache.(*RandomExpireCache).offset)
+	assert.NotNil(t, cache.(*RandomExpireCache).jitter)
 	testMemoryCacheDelete(t, cache)
 }
 
@@ -152,7 +152,7 @@ func TestRandomExpireCacheGetMulti(t *testing.T) {
 	bm := NewMemoryCache(1)
 	cache := NewRandomExpireCache(bm)
 	// should not be nil
-	assert.NotNil(t, cache.(*RandomExpireCache).offset)
+	assert.NotNil(t, cache.(*RandomExpireCache).jitter)
 	testMemoryCacheGetMulti(t, cache)
 }
 
@@ -163,5 +163,89 @@ func TestWithRandomExpireCacheOffsetFunc(t *testing.T) {
 		return magic
 	}))
 	// offset should return the magic value
-	assert.Equal(t, magic, cache.(*RandomExpireCache).offset())
+	assert.Equal(t, magic, cache.(*RandomExpireCache).jitter(nil, time.Minute))
+}
+
+func TestRandomExpireCache_Jitter(t *testing.T) {
+	testCases := []struct {
+		name     string
+		jitter   JitterFunc
+		timeout  time.Duration
+		min, max time.Duration
+	}{
+		{
+			name:    "proportional",
+			jitter:  ProportionalJitter(0.1),
+			timeout: time.Hour,
+			min:     54 * time.Minute,
+			max:     66 * time.Minute,
+		},
+		{
+			name:    "uniform",
+			jitter:  UniformJitter(time.Second, 2*time.Second),
+			timeout: time.Minute,
+			min:     time.Minute + time.Second,
+			max:     time.Minute + 2*time.Second,
+		},
+		{
+			name:    "exponential",
+			jitter:  ExponentialJitter(time.Second, 5*time.Second),
+			timeout: time.Minute,
+			min:     time.Minute,
+			max:     time.Minute + 5*time.Second,
+		},
+		{
+			name:    "never expire",
+			jitter:  UniformJitter(time.Second, 2*time.Second),
+			timeout: 0,
+			min:     0,
+			max:     0,
+		},
+		{
+			name:    "not positive",
+			jitter:  UniformJitter(-time.Hour, -time.Hour),
+			timeout: time.Minute,
+			min:     time.Minute,
+			max:     time.Minute,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := NewRandomExpireCache(NewMemoryCache(0), WithRandomExpireCacheJitter(tc.jitter)).(*RandomExpireCache)
+			for i := 0; i < 100; i++ {
+				timeout := rec.timeout(tc.timeout)
+				assert.GreaterOrEqual(t, timeout, tc.min)
+				assert.LessOrEqual(t, timeout, tc.max)
+			}
+		})
+	}
+}
+
+func TestRandomExpireCache_Seed(t *testing.T) {
+	newCache := func() *RandomExpireCache {
+		return NewRandomExpireCache(NewMemoryCache(0), WithRandomExpireCacheSeed(42)).(*RandomExpireCache)
+	}
+	c1, c2 := newCache(), newCache()
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, c1.timeout(time.Hour), c2.timeout(time.Hour))
+	}
+}
+
+func TestRandomExpireCache_PutMultiAndExpire(t *testing.T) {
+	ctx := context.Background()
+	rec := NewRandomExpireCache(NewMemoryCache(0),
+		WithRandomExpireCacheJitter(UniformJitter(0, 10*time.Millisecond))).(*RandomExpireCache)
+
+	assert.Nil(t, rec.PutMulti(ctx, map[string]any{"key1": "value1", "key2": "value2"}, 50*time.Millisecond))
+	vals, err := rec.GetMulti(ctx, []string{"key1", "key2"})
+	assert.Nil(t, err)
+	assert.Equal(t, []any{"value1", "value2"}, vals)
+
+	assert.Nil(t, rec.Expire(ctx, "key1", time.Minute))
+	assert.NotNil(t, rec.Expire(ctx, "missing", time.Minute))
+	time.Sleep(100 * time.Millisecond)
+	ok, _ := rec.IsExist(ctx, "key1")
+	assert.True(t, ok)
+	ok, _ = rec.IsExist(ctx, "key2")
+	assert.False(t, ok)
 }
@@ -16,46 +16,214 @@ package cache
 
 import (
 	"context"
+	"errors"
+	"sync"
 	"time"
 
 	berror "github.com/beego/beego-error/v2"
 )
 
+const defaultRefreshConcurrency = 16
+
+// ReadThroughCacheOption configures the cache created by NewReadThroughCache
+type ReadThroughCacheOption func(c *readThroughCache)
+
+// WithReadThroughCacheRefreshAhead enables refresh-ahead:
+// when a hit's remaining TTL falls below fraction of its TTL, loadFunc is invoked in background
+// to refresh the value, while the caller still gets the current one.
+// fraction should be in (0, 1).
+//
+// The TTL is only known for the values put by this cache, the values put by others are not refreshed.
+func WithReadThroughCacheRefreshAhead(fraction float64) ReadThroughCacheOption {
+	return func(c *readThroughCache) {
+		c.refreshFraction = fraction
+	}
+}
+
+// WithReadThroughCacheRefreshConcurrency configures the max number of the background refreshing, default 16.
+// The refreshing is skipped when the limit is reached, and tried again on the next hit.
+func WithReadThroughCacheRefreshConcurrency(n int) ReadThroughCacheOption {
+	return func(c *readThroughCache) {
+		c.refreshConcurrency = n
+	}
+}
+
+// WithReadThroughCacheNegativeTTL configures how long a tombstone is cached
+// when loadFunc returns ErrNotFound, during which Get returns ErrKeyNotExist without calling loadFunc.
+// By default no tombstone is cached.
+func WithReadThroughCacheNegativeTTL(ttl time.Duration) ReadThroughCacheOption {
+	return func(c *readThroughCache) {
+		c.negativeTTL = ttl
+	}
+}
+
 // readThroughCache is a decorator
 // add the read through function to the original Cache function
 type readThroughCache struct {
 	Cache
-	expiration time.Duration
-	loadFunc   func(ctx context.Context, key string) (any, error)
+	expiration  time.Duration
+	negativeTTL time.Duration
+	loadFunc    func(ctx context.Context, key string) (any, error)
+
+	refreshFraction    float64
+	refreshConcurrency int
+	// tokens of the background refreshing
+	refreshSem chan struct{}
+
+	// deadline and TTL of the values put by this cache
+	ttls *ttlTracker
+
+	mu         sync.Mutex
+	refreshing map[string]struct{}
 }
 
 // NewReadThroughCache create readThroughCache
 func NewReadThroughCache(cache Cache, expiration time.Duration,
-	loadFunc func(ctx context.Context, key string) (any, error),
+	loadFunc func(ctx context.Context, key string) (any, error), opts ...ReadThroughCacheOption,
 ) (Cache, error) {
 	if loadFunc == nil {
 		return nil, berror.Error(InvalidLoadFunc, "loadFunc cannot be nil")
 	}
-	return &readThroughCache{
-		Cache:      cache,
-		expiration: expiration,
-		loadFunc:   loadFunc,
-	}, nil
+	c := &readThroughCache{
+		Cache:              cache,
+		expiration:         expiration,
+		loadFunc:           loadFunc,
+		refreshConcurrency: defaultRefreshConcurrency,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.refreshFraction != 0 {
+		if c.refreshFraction < 0 || c.refreshFraction >= 1 {
+			return nil, berror.Errorf(InvalidInitParameters,
+				"refresh-ahead fraction should be in (0, 1), but got %v", c.refreshFraction)
+		}
+		if c.refreshConcurrency <= 0 {
+			return nil, berror.Errorf(InvalidInitParameters,
+				"refresh concurrency should be positive, but got %d", c.refreshConcurrency)
+		}
+		c.refreshSem = make(chan struct{}, c.refreshConcurrency)
+		c.ttls = newTTLTracker(0)
+		c.refreshing = make(map[string]struct{})
+	}
+	return c, nil
 }
 
 // Get will try to call the LoadFunc to load data if the Cache returns value nil or non-nil error.
+// With refresh-ahead, a hit close to expiring triggers a background refreshing.
+// ErrKeyNotExist is returned if loadFunc returns ErrNotFound.
 func (c *readThroughCache) Get(ctx context.Context, key string) (any, error) {
 	val, err := c.Cache.Get(ctx, key)
+	if err == nil && isTombstone(val) {
+		return nil, ErrKeyNotExist
+	}
 	if val == nil || err != nil {
 		val, err = c.loadFunc(ctx, key)
+		if errors.Is(err, ErrNotFound) {
+			return nil, putTombstone(ctx, c.Cache, key, c.negativeTTL)
+		}
 		if err != nil {
 			return nil, berror.Wrap(
 				err, LoadFuncFailed, "cache unable to load data")
 		}
-		err = c.Cache.Put(ctx, key, val, c.expiration)
+		err = c.Put(ctx, key, val, c.expiration)
 		if err != nil {
 			return val, err
 		}
+		return val, nil
 	}
+	c.refreshAhead(key)
 	return val, nil
 }
+
+// GetMulti gets the values of keys, the tombstones are misses.
+func (c *readThroughCache) GetMulti(ctx context.Context, keys []string) ([]any, error) {
+	return getMultiSkipTombstones(ctx, c.Cache, keys)
+}
+
+// IsExist reports whether key exists, a tombstone doesn't.
+func (c *readThroughCache) IsExist(ctx context.Context, key string) (bool, error) {
+	return isExistSkipTombstone(ctx, c.Cache, key)
+}
+
+// Put puts the value, and records its TTL for refresh-ahead.
+func (c *readThroughCache) Put(ctx context.Context, key string, val any, timeout time.Duration) error {
+	if err := c.Cache.Put(ctx, key, val, timeout); err != nil {
+		return err
+	}
+	if c.ttls != nil {
+		c.ttls.set(key, timeout)
+	}
+	return nil
+}
+
+// Delete deletes the value and its TTL.
+func (c *readThroughCache) Delete(ctx context.Context, key string) error {
+	if c.ttls != nil {
+		c.ttls.delete(key)
+	}
+	return c.Cache.Delete(ctx, key)
+}
+
+// ClearAll clears all the values and their TTL.
+func (c *readThroughCache) ClearAll(ctx context.Context) error {
+	if c.ttls != nil {
+		c.ttls.clear()
+	}
+	return c.Cache.ClearAll(ctx)
+}
+
+// refreshAhead starts refreshing key in background if it is about to expire.
+// At most one refreshing runs for a key.
+func (c *readThroughCache) refreshAhead(key string) {
+	if c.ttls == nil {
+		return
+	}
+	entry, ok := c.ttls.get(key)
+	if !ok {
+		return
+	}
+	remaining := time.Until(entry.deadline)
+	if remaining <= 0 || remaining > time.Duration(float64(entry.ttl)*c.refreshFraction) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok = c.refreshing[key]; ok {
+		return
+	}
+	select {
+	case c.refreshSem <- struct{}{}:
+	default:
+		return
+	}
+	c.refreshing[key] = struct{}{}
+	go c.refresh(key)
+}
+
+// refresh loads key and puts it. The caller's context is not used,
+// because the refreshing outlives the call. Errors are dropped,
+// the refreshing is tried again on the next hit.
+// The value is replaced by a tombstone or deleted if the record is gone.
+func (c *readThroughCache) refresh(key string) {
+	defer func() {
+		c.mu.Lock()
+		delete(c.refreshing, key)
+		c.mu.Unlock()
+		<-c.refreshSem
+	}()
+	ctx := context.Background()
+	val, err := c.loadFunc(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		if c.negativeTTL > 0 {
+			_ = putTombstone(ctx, c.Cache, key, c.negativeTTL)
+		} else {
+			_ = c.Cache.Delete(ctx, key)
+		}
+		return
+	}
+	if err != nil || val == nil {
+		return
+	}
+	_ = c.Put(ctx, key, val, c.expiration)
+}
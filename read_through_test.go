@@ -17,6 +17,9 @@ package cache
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -142,3 +145,97 @@ func (m *MockOrm) Load(key string) (any, error) {
 	}
 	return m.kvs[key], nil
 }
+
+func TestReadThroughCache_RefreshAhead(t *testing.T) {
+	var loads int32
+	loadFunc := func(ctx context.Context, key string) (any, error) {
+		return fmt.Sprintf("value%d", atomic.AddInt32(&loads, 1)), nil
+	}
+	c, err := NewReadThroughCache(NewMemoryCache(0), 200*time.Millisecond, loadFunc,
+		WithReadThroughCacheRefreshAhead(0.5))
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	val, err := c.Get(ctx, "key")
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", val)
+	// far from expiring
+	val, err = c.Get(ctx, "key")
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", val)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+
+	time.Sleep(120 * time.Millisecond)
+	// the current value is returned while refreshing
+	val, err = c.Get(ctx, "key")
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", val)
+	assert.Eventually(t, func() bool {
+		val, err := c.Get(ctx, "key")
+		return err == nil && val == "value2"
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&loads))
+
+	// the values put by others are not refreshed
+	mc := NewMemoryCache(0)
+	assert.Nil(t, mc.Put(ctx, "other", "value", 200*time.Millisecond))
+	c, err = NewReadThroughCache(mc, 200*time.Millisecond, loadFunc, WithReadThroughCacheRefreshAhead(0.5))
+	assert.Nil(t, err)
+	time.Sleep(120 * time.Millisecond)
+	_, err = c.Get(ctx, "other")
+	assert.Nil(t, err)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&loads))
+}
+
+func TestReadThroughCache_RefreshAheadDeduplicated(t *testing.T) {
+	var loads int32
+	release := make(chan struct{})
+	loadFunc := func(ctx context.Context, key string) (any, error) {
+		if atomic.AddInt32(&loads, 1) > 2 {
+			<-release
+		}
+		return "value", nil
+	}
+	c, err := NewReadThroughCache(NewMemoryCache(0), 100*time.Millisecond, loadFunc,
+		WithReadThroughCacheRefreshAhead(0.9), WithReadThroughCacheRefreshConcurrency(1))
+	assert.Nil(t, err)
+	ctx := context.Background()
+	for _, key := range []string{"key1", "key2"} {
+		_, err = c.Get(ctx, key)
+		assert.Nil(t, err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, key := range []string{"key1", "key2"} {
+				val, err := c.Get(ctx, key)
+				assert.Nil(t, err)
+				assert.Equal(t, "value", val)
+			}
+		}()
+	}
+	wg.Wait()
+	// one refreshing for key1, and key2 is skipped by the concurrency limit
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&loads) == 3
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&loads))
+	close(release)
+}
+
+func TestNewReadThroughCache_InvalidRefreshAhead(t *testing.T) {
+	loadFunc := func(ctx context.Context, key string) (any, error) {
+		return "value", nil
+	}
+	_, err := NewReadThroughCache(NewMemoryCache(0), time.Minute, loadFunc, WithReadThroughCacheRefreshAhead(1))
+	assert.NotNil(t, err)
+	_, err = NewReadThroughCache(NewMemoryCache(0), time.Minute, loadFunc,
+		WithReadThroughCacheRefreshAhead(0.2), WithReadThroughCacheRefreshConcurrency(0))
+	assert.NotNil(t, err)
+}
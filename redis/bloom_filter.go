@@ -0,0 +1,175 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"context"
+	"time"
+
+	"github.com/bits-and-blooms/bloom/v3"
+	"github.com/redis/go-redis/v9"
+
+	cache "github.com/beego/beego-cache/v2"
+	berror "github.com/beego/beego-error/v2"
+)
+
+// maxBloomFilterBits is the max size of a redis string, 512MB.
+const maxBloomFilterBits = 1 << 32
+
+var _ cache.ContextBloomFilter = (*BloomFilter)(nil)
+
+// EstimateBloomFilterParameters returns the number of bits m and hash functions k
+// of a bloom filter for n items with the false positive rate fp.
+func EstimateBloomFilterParameters(n uint, fp float64) (m uint, k uint) {
+	return bloom.EstimateParameters(n, fp)
+}
+
+// EstimateBloomFilterFalsePositiveRate returns the false positive rate
+// of a bloom filter with m bits and k hash functions after adding n items.
+func EstimateBloomFilterFalsePositiveRate(m, k, n uint) float64 {
+	return bloom.EstimateFalsePositiveRate(m, k, n)
+}
+
+type BloomFilterOptions func(f *BloomFilter)
+
+// BloomFilterWithTimeout configures the timeout of Test and Add, by default there is no timeout.
+func BloomFilterWithTimeout(timeout time.Duration) BloomFilterOptions {
+	return func(f *BloomFilter) {
+		f.timeout = timeout
+	}
+}
+
+// BloomFilterWithErrorHandler configures the function to handle the errors of Test and Add,
+// by default errors are ignored.
+func BloomFilterWithErrorHandler(fn func(err error)) BloomFilterOptions {
+	return func(f *BloomFilter) {
+		f.onError = fn
+	}
+}
+
+// BloomFilter is a cache.BloomFilter stored in a redis bitmap, so that all the instances using the same redis
+// share one filter. Its key is prefix/bloom:name, outside the keys of the cache, so that ClearAll keeps it.
+// The k bits of a key are set and read by SETBIT and GETBIT in one pipeline.
+//
+// Test reports true when redis fails, so that the keys are loaded as if there is no filter.
+// It is a cache.ContextBloomFilter, BloomFilterCache returns the errors of adding from Put.
+type BloomFilter struct {
+	client  redis.Cmdable
+	key     string
+	m       uint
+	k       uint
+	timeout time.Duration
+	onError func(err error)
+}
+
+// NewBloomFilter creates a BloomFilter with m bits and k hash functions stored in the key name of rc,
+// see EstimateBloomFilterParameters. m should not be greater than 2^32.
+// The instances sharing the filter should use the same m and k.
+func NewBloomFilter(rc *Cache, name string, m, k uint, opts ...BloomFilterOptions) (*BloomFilter, error) {
+	if m == 0 || m > maxBloomFilterBits || k == 0 {
+		return nil, berror.Errorf(cache.InvalidInitParameters,
+			"bits should be in (0, 2^32] and hash functions should be positive, but got %d bits and %d hash functions", m, k)
+	}
+	f := &BloomFilter{
+		client:  rc.client,
+		key:     bloomFilterKey(rc.prefix, name),
+		m:       m,
+		k:       k,
+		onError: func(error) {},
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f, nil
+}
+
+// Test reports whether data may be added.
+func (f *BloomFilter) Test(data string) bool {
+	ctx, cancel := f.context()
+	defer cancel()
+	ok, err := f.TestContext(ctx, data)
+	if err != nil {
+		f.onError(err)
+		return true
+	}
+	return ok
+}
+
+// Add adds data, the errors are handled by the error handler.
+func (f *BloomFilter) Add(data string) {
+	ctx, cancel := f.context()
+	defer cancel()
+	if err := f.AddContext(ctx, data); err != nil {
+		f.onError(err)
+	}
+}
+
+// TestContext reports whether data may be added.
+func (f *BloomFilter) TestContext(ctx context.Context, data string) (bool, error) {
+	locs := f.locations(data)
+	cmds := make([]*redis.IntCmd, 0, len(locs))
+	_, err := f.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, loc := range locs {
+			cmds = append(cmds, pipe.GetBit(ctx, f.key, loc))
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, cmd := range cmds {
+		if cmd.Val() == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// AddContext adds data.
+func (f *BloomFilter) AddContext(ctx context.Context, data string) error {
+	_, err := f.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, loc := range f.locations(data) {
+			pipe.SetBit(ctx, f.key, loc, 1)
+		}
+		return nil
+	})
+	return err
+}
+
+// Clear deletes the filter from redis.
+func (f *BloomFilter) Clear(ctx context.Context) error {
+	return f.client.Del(ctx, f.key).Err()
+}
+
+// bloomFilterKey returns the key of the filter name, which doesn't match prefix:*
+func bloomFilterKey(prefix, name string) string {
+	return prefix + "/bloom:" + name
+}
+
+func (f *BloomFilter) context() (context.Context, context.CancelFunc) {
+	if f.timeout > 0 {
+		return context.WithTimeout(context.Background(), f.timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+func (f *BloomFilter) locations(data string) []int64 {
+	hashes := bloom.Locations([]byte(data), f.k)
+	locs := make([]int64, 0, len(hashes))
+	for _, h := range hashes {
+		locs = append(locs, int64(h%uint64(f.m)))
+	}
+	return locs
+}
@@ -0,0 +1,131 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cache "github.com/beego/beego-cache/v2"
+	"github.com/beego/beego-cache/v2/redis/internal/redistest"
+	berror "github.com/beego/beego-error/v2"
+)
+
+func TestBloomFilter(t *testing.T) {
+	srv, err := redistest.NewServer()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = srv.Close() })
+	newCache := func() *Cache {
+		client := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+		t.Cleanup(func() { _ = client.Close() })
+		return NewRedisCache(client, CacheWithPrefix("bloom")).(*Cache)
+	}
+	m, k := EstimateBloomFilterParameters(1000, 0.01)
+	assert.InDelta(t, 0.01, EstimateBloomFilterFalsePositiveRate(m, k, 1000), 0.001)
+
+	// two instances share one filter
+	f1, err := NewBloomFilter(newCache(), "filter", m, k)
+	require.NoError(t, err)
+	f2, err := NewBloomFilter(newCache(), "filter", m, k)
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		f1.Add(fmt.Sprintf("key_%d", i))
+	}
+	for i := 0; i < 100; i++ {
+		assert.True(t, f2.Test(fmt.Sprintf("key_%d", i)))
+	}
+	falsePositives := 0
+	for i := 0; i < 100; i++ {
+		if f2.Test(fmt.Sprintf("other_%d", i)) {
+			falsePositives++
+		}
+	}
+	assert.Less(t, falsePositives, 5)
+
+	// used by BloomFilterCache
+	bfc, err := cache.NewBloomFilterCache(cache.NewMemoryCache(0), func(ctx context.Context, key string) (any, error) {
+		return "value", nil
+	}, f2, time.Minute)
+	require.NoError(t, err)
+	val, err := bfc.Get(context.Background(), "key_1")
+	require.NoError(t, err)
+	assert.Equal(t, "value", val)
+	val, err = bfc.Get(context.Background(), "other")
+	require.NoError(t, err)
+	assert.Nil(t, val)
+
+	// kept by ClearAll of the cache
+	rc := newCache()
+	require.NoError(t, rc.Put(context.Background(), "key", "value", time.Minute))
+	require.NoError(t, rc.ClearAll(context.Background()))
+	assert.True(t, f2.Test("key_1"))
+
+	require.NoError(t, f1.Clear(context.Background()))
+	assert.False(t, f2.Test("key_1"))
+}
+
+func TestBloomFilter_Failed(t *testing.T) {
+	srv, err := redistest.NewServer()
+	require.NoError(t, err)
+	client := redis.NewClient(&redis.Options{Addr: srv.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { _ = client.Close() })
+	var errs []error
+	f, err := NewBloomFilter(NewRedisCache(client).(*Cache), "filter", 1024, 3,
+		BloomFilterWithTimeout(time.Second), BloomFilterWithErrorHandler(func(err error) {
+			errs = append(errs, err)
+		}))
+	require.NoError(t, err)
+	require.NoError(t, srv.Close())
+
+	// every key may exist when redis fails
+	assert.True(t, f.Test("key"))
+	f.Add("key")
+	assert.Len(t, errs, 2)
+
+	// BloomFilterCache returns the error of adding
+	f, err = NewBloomFilter(NewRedisCache(client).(*Cache), "filter", 1024, 3)
+	require.NoError(t, err)
+	bfc, err := cache.NewBloomFilterCache(cache.NewMemoryCache(0), func(ctx context.Context, key string) (any, error) {
+		return "value", nil
+	}, f, time.Minute)
+	require.NoError(t, err)
+	assert.Error(t, bfc.Put(context.Background(), "key", "value", time.Minute))
+}
+
+func TestNewBloomFilter(t *testing.T) {
+	rc := NewRedisCache(redis.NewClient(&redis.Options{})).(*Cache)
+	testCases := []struct {
+		name string
+		m    uint
+		k    uint
+	}{
+		{name: "no bits", m: 0, k: 3},
+		{name: "too many bits", m: maxBloomFilterBits + 1, k: 3},
+		{name: "no hash functions", m: 1024, k: 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewBloomFilter(rc, "filter", tc.m, tc.k)
+			code, _ := berror.FromError(err)
+			assert.Equal(t, cache.InvalidInitParameters.Code(), code.Code())
+		})
+	}
+}
@@ -0,0 +1,460 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const defaultMaxLocalEntries = 10000
+
+// TrackingMode is the mode of the redis server side tracking.
+type TrackingMode int
+
+const (
+	// TrackingDefault makes redis remember the keys read from it,
+	// and only notify the changes of those keys.
+	TrackingDefault TrackingMode = iota
+	// TrackingBroadcast makes redis notify the changes of all the keys under the prefix of the cache,
+	// no memory is used by redis but more invalidations are received.
+	TrackingBroadcast
+)
+
+type ClientSideCachingOptions func(t *tracker)
+
+// ClientSideCachingWithMode configures the tracking mode, default TrackingDefault.
+func ClientSideCachingWithMode(mode TrackingMode) ClientSideCachingOptions {
+	return func(t *tracker) {
+		t.mode = mode
+	}
+}
+
+// ClientSideCachingWithMaxEntries configures the max number of the keys kept locally, default 10000.
+// The least recently used key is dropped when the limit is exceeded.
+func ClientSideCachingWithMaxEntries(n int) ClientSideCachingOptions {
+	if n <= 0 {
+		panic("max entries should be positive")
+	}
+	return func(t *tracker) {
+		t.maxEntries = n
+	}
+}
+
+// ClientSideCachingWithExpiration configures how long a key is kept locally.
+// By default, a key is kept until it is invalidated by redis or dropped by the limit.
+func ClientSideCachingWithExpiration(expiration time.Duration) ClientSideCachingOptions {
+	return func(t *tracker) {
+		t.expiration = expiration
+	}
+}
+
+// ClientSideCachingWithRetryInterval configures how long to wait before reconnecting
+// when the tracking connection is broken, default one second.
+func ClientSideCachingWithRetryInterval(interval time.Duration) ClientSideCachingOptions {
+	return func(t *tracker) {
+		t.retryInterval = interval
+	}
+}
+
+// ClientSideCachingWithErrorHandler configures the function to handle the errors
+// of the tracking connection, by default errors are ignored.
+func ClientSideCachingWithErrorHandler(fn func(err error)) ClientSideCachingOptions {
+	return func(t *tracker) {
+		t.onError = fn
+	}
+}
+
+// CacheWithClientSideCaching serves Get and GetMulti from a bounded local map,
+// the keys are invalidated by the push messages of redis server assisted client side caching,
+// so redis 6.0 or later is required, and the client must be a *redis.Client.
+//
+// A dedicated RESP3 connection receives the invalidations.
+// Nothing is cached locally while it is disconnected,
+// and the local map is cleared because the invalidations may be lost.
+// Call Cache.Close to close the connection.
+func CacheWithClientSideCaching(opts ...ClientSideCachingOptions) CacheOptions {
+	return func(c *Cache) {
+		c.clientSideCaching = true
+		c.trackingOpts = opts
+	}
+}
+
+type localEntry struct {
+	key      string
+	val      interface{}
+	deadline time.Time
+}
+
+// tracker keeps the local map of CacheWithClientSideCaching.
+type tracker struct {
+	mode          TrackingMode
+	maxEntries    int
+	expiration    time.Duration
+	retryInterval time.Duration
+	onError       func(err error)
+
+	client *redis.Client
+	prefix string
+
+	mu sync.Mutex
+	// ready is true when the invalidations are being received
+	ready bool
+	// reader reads the keys cached locally.
+	// In TrackingDefault mode all its connections are tracked and redirected to the tracking connection.
+	reader  *redis.Client
+	entries map[string]*list.Element
+	lru     *list.List
+	// key -> token of the latest loading, a loading is only saved if it is not invalidated
+	loading map[string]uint64
+	token   uint64
+	conn    net.Conn
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newTracker(rc *Cache) *tracker {
+	client, ok := rc.client.(*redis.Client)
+	if !ok {
+		panic("client side caching requires *redis.Client")
+	}
+	t := &tracker{
+		mode:          TrackingDefault,
+		maxEntries:    defaultMaxLocalEntries,
+		retryInterval: defaultRetryInterval,
+		onError:       func(error) {},
+		client:        client,
+		prefix:        rc.prefix + ":",
+		reader:        client,
+		entries:       make(map[string]*list.Element),
+		lru:           list.New(),
+		loading:       make(map[string]uint64),
+	}
+	for _, opt := range rc.trackingOpts {
+		opt(t)
+	}
+	t.ctx, t.cancel = context.WithCancel(context.Background())
+	t.wg.Add(1)
+	go t.run()
+	return t
+}
+
+func (t *tracker) get(ctx context.Context, key string) (interface{}, error) {
+	t.mu.Lock()
+	if val, ok := t.lookup(key); ok {
+		t.mu.Unlock()
+		return val, nil
+	}
+	reader, tokens := t.begin([]string{key})
+	t.mu.Unlock()
+
+	val, err := reader.Get(ctx, key).Result()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil {
+		t.finish(key, tokens, nil)
+		return nil, err
+	}
+	t.finish(key, tokens, val)
+	return val, nil
+}
+
+func (t *tracker) getMulti(ctx context.Context, keys []string) ([]interface{}, error) {
+	res := make([]interface{}, len(keys))
+	missing := make([]string, 0, len(keys))
+	idx := make([]int, 0, len(keys))
+	t.mu.Lock()
+	for i, key := range keys {
+		if val, ok := t.lookup(key); ok {
+			res[i] = val
+			continue
+		}
+		missing = append(missing, key)
+		idx = append(idx, i)
+	}
+	if len(missing) == 0 {
+		t.mu.Unlock()
+		return res, nil
+	}
+	reader, tokens := t.begin(missing)
+	t.mu.Unlock()
+
+	vals, err := reader.MGet(ctx, missing...).Result()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, key := range missing {
+		var val interface{}
+		if err == nil {
+			val = vals[i]
+			res[idx[i]] = val
+		}
+		t.finish(key, tokens, val)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// lookup returns the value of key kept locally. The caller must hold t.mu.
+func (t *tracker) lookup(key string) (interface{}, bool) {
+	elem, ok := t.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*localEntry)
+	if !entry.deadline.IsZero() && time.Now().After(entry.deadline) {
+		t.remove(elem)
+		return nil, false
+	}
+	t.lru.MoveToFront(elem)
+	return entry.val, true
+}
+
+// begin registers the loadings of keys, and returns the client to read them.
+// tokens is nil if the invalidations are not being received. The caller must hold t.mu.
+func (t *tracker) begin(keys []string) (*redis.Client, map[string]uint64) {
+	if !t.ready {
+		return t.client, nil
+	}
+	tokens := make(map[string]uint64, len(keys))
+	for _, key := range keys {
+		t.token++
+		t.loading[key] = t.token
+		tokens[key] = t.token
+	}
+	return t.reader, tokens
+}
+
+// finish saves val of key if key is not invalidated since begin.
+// A nil val is not saved. The caller must hold t.mu.
+func (t *tracker) finish(key string, tokens map[string]uint64, val interface{}) {
+	token, ok := tokens[key]
+	if !ok || t.loading[key] != token {
+		return
+	}
+	delete(t.loading, key)
+	if val == nil {
+		return
+	}
+	entry := &localEntry{key: key, val: val}
+	if t.expiration > 0 {
+		entry.deadline = time.Now().Add(t.expiration)
+	}
+	if elem, ok := t.entries[key]; ok {
+		t.remove(elem)
+	}
+	t.entries[key] = t.lru.PushFront(entry)
+	for t.lru.Len() > t.maxEntries {
+		t.remove(t.lru.Back())
+	}
+}
+
+func (t *tracker) remove(elem *list.Element) {
+	t.lru.Remove(elem)
+	delete(t.entries, elem.Value.(*localEntry).key)
+}
+
+// invalidate drops keys, and all the keys if keys is nil.
+func (t *tracker) invalidate(keys []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.drop(keys)
+}
+
+// drop drops keys, and all the keys if keys is nil. The caller must hold t.mu.
+func (t *tracker) drop(keys []string) {
+	if keys == nil {
+		t.entries = make(map[string]*list.Element)
+		t.lru.Init()
+		t.loading = make(map[string]uint64)
+		return
+	}
+	for _, key := range keys {
+		if elem, ok := t.entries[key]; ok {
+			t.remove(elem)
+		}
+		delete(t.loading, key)
+	}
+}
+
+func (t *tracker) close() error {
+	t.cancel()
+	t.mu.Lock()
+	if t.conn != nil {
+		_ = t.conn.Close()
+	}
+	t.mu.Unlock()
+	t.wg.Wait()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.reader != t.client {
+		return t.reader.Close()
+	}
+	return nil
+}
+
+func (t *tracker) run() {
+	defer t.wg.Done()
+	for {
+		err := t.track()
+		t.setReady(false, nil)
+		if t.ctx.Err() != nil {
+			return
+		}
+		t.onError(err)
+		select {
+		case <-time.After(t.retryInterval):
+		case <-t.ctx.Done():
+			return
+		}
+	}
+}
+
+// track connects redis with RESP3, enables tracking and receives the invalidations until an error occurs.
+func (t *tracker) track() error {
+	opts := t.client.Options()
+	conn, err := opts.Dialer(t.ctx, opts.Network, opts.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	t.mu.Lock()
+	if t.ctx.Err() != nil {
+		t.mu.Unlock()
+		return t.ctx.Err()
+	}
+	t.conn = conn
+	t.mu.Unlock()
+
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	do := func(args ...string) (interface{}, error) {
+		if err := writeCommand(w, args...); err != nil {
+			return nil, err
+		}
+		for {
+			val, err := readValue(r)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := val.(respPush); ok {
+				continue
+			}
+			if e, ok := val.(respError); ok {
+				return nil, e
+			}
+			return val, nil
+		}
+	}
+
+	hello := []string{"HELLO", "3"}
+	username, password := opts.Username, opts.Password
+	if opts.CredentialsProvider != nil {
+		username, password = opts.CredentialsProvider()
+	}
+	if password != "" {
+		if username == "" {
+			username = "default"
+		}
+		hello = append(hello, "AUTH", username, password)
+	}
+	if _, err = do(hello...); err != nil {
+		return err
+	}
+
+	reader := t.client
+	if t.mode == TrackingBroadcast {
+		if _, err = do("CLIENT", "TRACKING", "ON", "BCAST", "PREFIX", t.prefix); err != nil {
+			return err
+		}
+	} else {
+		val, err := do("CLIENT", "ID")
+		if err != nil {
+			return err
+		}
+		id, ok := val.(int64)
+		if !ok {
+			return fmt.Errorf("redis: unexpected reply of CLIENT ID: %v", val)
+		}
+		reader = t.newReader(id)
+	}
+	t.setReady(true, reader)
+
+	for {
+		val, err := readValue(r)
+		if err != nil {
+			return err
+		}
+		msg, ok := val.(respPush)
+		if !ok || len(msg) != 2 || msg[0] != "invalidate" {
+			continue
+		}
+		// a nil key list means that the database is flushed
+		var keys []string
+		if list, ok := msg[1].([]interface{}); ok {
+			keys = make([]string, 0, len(list))
+			for _, key := range list {
+				if s, ok := key.(string); ok {
+					keys = append(keys, s)
+				}
+			}
+		}
+		t.invalidate(keys)
+	}
+}
+
+// newReader returns a client whose connections redirect the invalidations to the client id.
+func (t *tracker) newReader(id int64) *redis.Client {
+	opts := *t.client.Options()
+	onConnect := opts.OnConnect
+	opts.OnConnect = func(ctx context.Context, cn *redis.Conn) error {
+		if onConnect != nil {
+			if err := onConnect(ctx, cn); err != nil {
+				return err
+			}
+		}
+		return cn.Process(ctx, redis.NewCmd(ctx, "CLIENT", "TRACKING", "ON", "REDIRECT", strconv.FormatInt(id, 10)))
+	}
+	return redis.NewClient(&opts)
+}
+
+// setReady switches the state of receiving invalidations, and clears the local map.
+// The previous reader is closed if reader replaces it.
+func (t *tracker) setReady(ready bool, reader *redis.Client) {
+	t.mu.Lock()
+	t.drop(nil)
+	t.ready = ready
+	var old *redis.Client
+	if reader != nil && reader != t.reader {
+		old, t.reader = t.reader, reader
+	}
+	t.mu.Unlock()
+	if old != nil && old != t.client {
+		_ = old.Close()
+	}
+}
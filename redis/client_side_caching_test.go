@@ -0,0 +1,171 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/beego/beego-cache/v2/redis/internal/redistest"
+)
+
+// newTrackingCache returns a cache with client side caching and a cache changing the same keys.
+func newTrackingCache(t *testing.T, opts ...ClientSideCachingOptions) (*redistest.Server, *Cache, *Cache) {
+	srv, err := redistest.NewServer()
+	require.NoError(t, err)
+	client := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	opts = append(opts, ClientSideCachingWithRetryInterval(10*time.Millisecond))
+	c := NewRedisCache(client, CacheWithPrefix("app"), CacheWithClientSideCaching(opts...)).(*Cache)
+	t.Cleanup(func() {
+		assert.NoError(t, c.Close())
+		_ = client.Close()
+		_ = srv.Close()
+	})
+	waitTracking(t, c)
+	other := NewRedisCache(client, CacheWithPrefix("app")).(*Cache)
+	return srv, c, other
+}
+
+func waitTracking(t *testing.T, c *Cache) {
+	require.Eventually(t, func() bool {
+		c.tracker.mu.Lock()
+		defer c.tracker.mu.Unlock()
+		return c.tracker.ready
+	}, time.Second, 10*time.Millisecond)
+}
+
+func isLocal(c *Cache, key string) bool {
+	c.tracker.mu.Lock()
+	defer c.tracker.mu.Unlock()
+	_, ok := c.tracker.entries[c.associate(key)]
+	return ok
+}
+
+func TestCache_ClientSideCaching(t *testing.T) {
+	for name, mode := range map[string]TrackingMode{
+		"default":   TrackingDefault,
+		"broadcast": TrackingBroadcast,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, c, other := newTrackingCache(t, ClientSideCachingWithMode(mode))
+			ctx := context.Background()
+
+			require.NoError(t, other.Put(ctx, "key1", "value1", time.Minute))
+			require.NoError(t, other.Put(ctx, "key2", 2, time.Minute))
+			val, err := c.Get(ctx, "key1")
+			require.NoError(t, err)
+			assert.Equal(t, "value1", val)
+			vals, err := c.GetMulti(ctx, []string{"key1", "key2", "missing"})
+			require.NoError(t, err)
+			assert.Equal(t, []interface{}{"value1", "2", nil}, vals)
+			assert.True(t, isLocal(c, "key1"))
+			assert.True(t, isLocal(c, "key2"))
+			assert.False(t, isLocal(c, "missing"))
+
+			require.NoError(t, other.Put(ctx, "key1", "new", time.Minute))
+			assert.Eventually(t, func() bool {
+				return !isLocal(c, "key1")
+			}, time.Second, 10*time.Millisecond)
+			val, err = c.Get(ctx, "key1")
+			require.NoError(t, err)
+			assert.Equal(t, "new", val)
+
+			require.NoError(t, other.Incr(ctx, "key2"))
+			assert.Eventually(t, func() bool {
+				return !isLocal(c, "key2")
+			}, time.Second, 10*time.Millisecond)
+
+			// the changes made by the cache itself are evicted at once
+			require.NoError(t, c.Delete(ctx, "key1"))
+			assert.False(t, isLocal(c, "key1"))
+			_, err = c.Get(ctx, "key1")
+			assert.Equal(t, redis.Nil, err)
+		})
+	}
+}
+
+func TestCache_ClientSideCaching_Broadcast(t *testing.T) {
+	_, c, other := newTrackingCache(t, ClientSideCachingWithMode(TrackingBroadcast))
+	ctx := context.Background()
+
+	require.NoError(t, other.Put(ctx, "key", "value", time.Minute))
+	_, err := c.Get(ctx, "key")
+	require.NoError(t, err)
+
+	// the keys out of the prefix are not notified
+	otherPrefix := NewRedisCache(other.client, CacheWithPrefix("other"))
+	require.NoError(t, otherPrefix.Put(ctx, "key", "value", time.Minute))
+
+	require.NoError(t, other.client.FlushDB(ctx).Err())
+	assert.Eventually(t, func() bool {
+		return !isLocal(c, "key")
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestCache_ClientSideCaching_Limit(t *testing.T) {
+	_, c, other := newTrackingCache(t, ClientSideCachingWithMaxEntries(2),
+		ClientSideCachingWithExpiration(50*time.Millisecond))
+	ctx := context.Background()
+
+	for _, key := range []string{"key1", "key2", "key3"} {
+		require.NoError(t, other.Put(ctx, key, key, time.Minute))
+	}
+	_, err := c.GetMulti(ctx, []string{"key1", "key2"})
+	require.NoError(t, err)
+	_, err = c.Get(ctx, "key1")
+	require.NoError(t, err)
+	_, err = c.Get(ctx, "key3")
+	require.NoError(t, err)
+	assert.True(t, isLocal(c, "key1"))
+	assert.False(t, isLocal(c, "key2"))
+	assert.True(t, isLocal(c, "key3"))
+
+	time.Sleep(60 * time.Millisecond)
+	c.tracker.mu.Lock()
+	_, ok := c.tracker.lookup(c.associate("key1"))
+	c.tracker.mu.Unlock()
+	assert.False(t, ok)
+}
+
+func TestCache_ClientSideCaching_Reconnect(t *testing.T) {
+	srv, c, other := newTrackingCache(t)
+	ctx := context.Background()
+
+	require.NoError(t, other.Put(ctx, "key", "value", time.Minute))
+	_, err := c.Get(ctx, "key")
+	require.NoError(t, err)
+	require.True(t, isLocal(c, "key"))
+
+	// the invalidations may be lost while disconnected
+	srv.DropConnections()
+	assert.Eventually(t, func() bool {
+		return !isLocal(c, "key")
+	}, time.Second, 10*time.Millisecond)
+
+	waitTracking(t, c)
+	assert.Eventually(t, func() bool {
+		val, err := c.Get(ctx, "key")
+		return err == nil && val == "value" && isLocal(c, "key")
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, other.Put(ctx, "key", "new", time.Minute))
+	assert.Eventually(t, func() bool {
+		return !isLocal(c, "key")
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,56 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+
+	cache "github.com/beego/beego-cache/v2"
+)
+
+// busyPrefixes are the prefixes of the redis errors replied when the server can't serve for a while
+var busyPrefixes = []string{"LOADING ", "READONLY ", "CLUSTERDOWN ", "TRYAGAIN ", "MASTERDOWN "}
+
+func init() {
+	cache.RegisterErrorClassifier(classifyError)
+}
+
+// classifyError tells the misses and the failures of go-redis, the network errors are known by cache.ClassifyError.
+func classifyError(err error) cache.ErrorClass {
+	if errors.Is(err, redis.Nil) {
+		return cache.ErrorClassMiss
+	}
+	// the pool timeout of go-redis isn't exported
+	if strings.Contains(err.Error(), "redis: connection pool timeout") {
+		return cache.ErrorClassFailure
+	}
+	var redisErr redis.Error
+	if !errors.As(err, &redisErr) {
+		return cache.ErrorClassUnknown
+	}
+	msg := strings.TrimPrefix(redisErr.Error(), "ERR ")
+	if msg == "max number of clients reached" {
+		return cache.ErrorClassFailure
+	}
+	for _, prefix := range busyPrefixes {
+		if strings.HasPrefix(msg, prefix) {
+			return cache.ErrorClassFailure
+		}
+	}
+	return cache.ErrorClassUnknown
+}
@@ -0,0 +1,96 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cache "github.com/beego/beego-cache/v2"
+)
+
+func TestInstrumentedCache_Miss(t *testing.T) {
+	collector, err := cache.NewMemoryMetricsCollector()
+	require.NoError(t, err)
+	c, err := cache.NewInstrumentedCache(newLocalRedisCache(t), collector)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.NoError(t, c.Put(ctx, "key", "value", time.Minute))
+	_, err = c.Get(ctx, "key")
+	require.NoError(t, err)
+	_, err = c.Get(ctx, "missing")
+	assert.Error(t, err)
+
+	stats := collector.Stats(cache.OpGet)
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Empty(t, stats.Errors)
+	assert.Equal(t, 0.5, stats.HitRatio())
+}
+
+// recordingSpan records the attributes and errors of a span
+type recordingSpan struct {
+	mu    sync.Mutex
+	attrs map[string]any
+	errs  []error
+}
+
+func (s *recordingSpan) SetAttributes(attrs ...cache.Attribute) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, attr := range attrs {
+		s.attrs[attr.Key] = attr.Value
+	}
+}
+
+func (s *recordingSpan) RecordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errs = append(s.errs, err)
+}
+
+func (s *recordingSpan) End() {}
+
+type recordingTracer struct {
+	spans []*recordingSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, spanName string,
+	attrs ...cache.Attribute,
+) (context.Context, cache.Span) {
+	span := &recordingSpan{attrs: make(map[string]any)}
+	span.SetAttributes(attrs...)
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+func TestTracingHook_Miss(t *testing.T) {
+	tracer := &recordingTracer{}
+	c, err := cache.NewHookCache(newLocalRedisCache(t), cache.NewTracingHook(tracer))
+	require.NoError(t, err)
+
+	_, err = c.Get(context.Background(), "missing")
+	assert.Error(t, err)
+	require.Len(t, tracer.spans, 1)
+	// a miss is not an error of the span
+	assert.Empty(t, tracer.spans[0].errs)
+	assert.Equal(t, false, tracer.spans[0].attrs[cache.AttrHit])
+}
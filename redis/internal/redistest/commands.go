@@ -0,0 +1,315 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redistest
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/beego/beego-cache/v2/server/resp"
+)
+
+var (
+	errSyntax     = resp.Error("ERR syntax error")
+	errNotInteger = resp.Error("ERR value is not an integer or out of range")
+	errNoScript   = resp.Error("NOSCRIPT No matching script. Please use EVAL.")
+)
+
+type command struct {
+	// arity follows the redis convention:
+	// positive means exact number of arguments, negative means at least -arity.
+	// The command name itself is counted.
+	arity   int
+	handler func(s *Server, c *client, args []string, next resp.Invoker) any
+}
+
+// commands are the commands added to resp.Server
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"scan":        {arity: -2, handler: (*Server).cmdScan},
+		"eval":        {arity: -3, handler: (*Server).cmdEval},
+		"evalsha":     {arity: -3, handler: (*Server).cmdEval},
+		"script":      {arity: -2, handler: (*Server).cmdScript},
+		"publish":     {arity: 3, handler: (*Server).cmdPublish},
+		"subscribe":   {arity: -2, handler: (*Server).cmdSubscribe},
+		"unsubscribe": {arity: -1, handler: (*Server).cmdUnsubscribe},
+		"client":      {arity: -2, handler: (*Server).cmdClient},
+		"setbit":      {arity: 4, handler: (*Server).cmdSetBit},
+		"getbit":      {arity: 3, handler: (*Server).cmdGetBit},
+	}
+}
+
+// exec runs a command for c, the commands of resp.Server are run by next.
+// The caller must hold s.mu.
+func (s *Server) exec(c *client, args []string, next resp.Invoker) any {
+	name := strings.ToLower(args[0])
+	if name == "ping" && len(c.channels) > 0 {
+		msg := ""
+		if len(args) > 1 {
+			msg = args[1]
+		}
+		return []any{"pong", msg}
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		res := next(c.conn, args)
+		s.trackRead(c, args)
+		return res
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		return resp.Error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+	}
+	return cmd.handler(s, c, args, next)
+}
+
+// cmdScan supports SCAN cursor [MATCH pattern] [COUNT count].
+// The cursor is the offset in the sorted key list.
+func (s *Server) cmdScan(c *client, args []string, next resp.Invoker) any {
+	cursor, err := strconv.Atoi(args[1])
+	if err != nil || cursor < 0 {
+		return resp.Error("ERR invalid cursor")
+	}
+	pattern, count := "*", 10
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return errSyntax
+		}
+		switch strings.ToLower(args[i]) {
+		case "match":
+			pattern = args[i+1]
+		case "count":
+			if count, err = strconv.Atoi(args[i+1]); err != nil || count < 1 {
+				return errSyntax
+			}
+		default:
+			return errSyntax
+		}
+	}
+
+	keys := make([]string, 0, len(s.keys))
+	for key := range s.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	res := make([]any, 0, count)
+	end := cursor + count
+	if end >= len(keys) {
+		end = len(keys)
+	}
+	for i := cursor; i < end; i++ {
+		if match(pattern, keys[i]) && next(c.conn, []string{"exists", keys[i]}) == int64(1) {
+			res = append(res, keys[i])
+		}
+	}
+	if end >= len(keys) {
+		end = 0
+	}
+	return []any{strconv.Itoa(end), res}
+}
+
+// cmdEval supports EVAL and EVALSHA of the scripts registered by RegisterScript.
+func (s *Server) cmdEval(c *client, args []string, next resp.Invoker) any {
+	sha := args[1]
+	if strings.EqualFold(args[0], "eval") {
+		sha = scriptSHA(args[1])
+	}
+	fn, ok := s.scripts[strings.ToLower(sha)]
+	if !ok {
+		if strings.EqualFold(args[0], "eval") {
+			return resp.Error("ERR lua scripts are not supported, register the script first")
+		}
+		return errNoScript
+	}
+	numKeys, err := strconv.Atoi(args[2])
+	if err != nil || numKeys < 0 {
+		return errNotInteger
+	}
+	if numKeys > len(args)-3 {
+		return resp.Error("ERR Number of keys can't be greater than number of args")
+	}
+	keys, argv := args[3:3+numKeys], args[3+numKeys:]
+	return fn(func(cmdArgs ...string) any {
+		return s.exec(c, cmdArgs, next)
+	}, keys, argv)
+}
+
+// cmdScript supports SCRIPT LOAD, SCRIPT EXISTS and SCRIPT FLUSH.
+func (s *Server) cmdScript(_ *client, args []string, _ resp.Invoker) any {
+	switch strings.ToLower(args[1]) {
+	case "load":
+		if len(args) != 3 {
+			return errSyntax
+		}
+		return scriptSHA(args[2])
+	case "exists":
+		res := make([]any, 0, len(args)-2)
+		for _, sha := range args[2:] {
+			_, ok := s.scripts[strings.ToLower(sha)]
+			res = append(res, ok)
+		}
+		return res
+	case "flush":
+		return resp.Status("OK")
+	default:
+		return resp.Error(fmt.Sprintf("ERR unknown subcommand '%s'", args[1]))
+	}
+}
+
+// match reports whether str matches the glob-style pattern used by SCAN and KEYS.
+func match(pattern, str string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(str); i++ {
+				if match(pattern, str[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 {
+				return pattern == str
+			}
+			class := pattern[1 : end+1]
+			if !matchClass(class, str[0]) {
+				return false
+			}
+			pattern = pattern[end+2:]
+			str = str[1:]
+			continue
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(str) == 0 || pattern[0] != str[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		str = str[1:]
+	}
+	return len(str) == 0
+}
+
+func matchClass(class string, c byte) bool {
+	negate := false
+	if len(class) > 0 && class[0] == '^' {
+		negate = true
+		class = class[1:]
+	}
+	matched := false
+	for i := 0; i < len(class); i++ {
+		if i+2 < len(class) && class[i+1] == '-' {
+			lo, hi := class[i], class[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if c >= lo && c <= hi {
+				matched = true
+			}
+			i += 2
+			continue
+		}
+		if class[i] == c {
+			matched = true
+		}
+	}
+	return matched != negate
+}
+
+// cmdSetBit supports SETBIT key offset value, and returns the original bit.
+func (s *Server) cmdSetBit(c *client, args []string, next resp.Invoker) any {
+	offset, err := strconv.ParseUint(args[2], 10, 32)
+	if err != nil {
+		return resp.Error("ERR bit offset is not an integer or out of range")
+	}
+	if args[3] != "0" && args[3] != "1" {
+		return resp.Error("ERR bit is not an integer or out of range")
+	}
+	val, res := get(c, args[1], next)
+	if res != nil {
+		return res
+	}
+	buf := []byte(val)
+	idx := int(offset / 8)
+	if idx >= len(buf) {
+		buf = append(buf, make([]byte, idx+1-len(buf))...)
+	}
+	mask := byte(0x80) >> (offset % 8)
+	var old int64
+	if buf[idx]&mask != 0 {
+		old = 1
+	}
+	if args[3] == "1" {
+		buf[idx] |= mask
+	} else {
+		buf[idx] &^= mask
+	}
+	if res = next(c.conn, []string{"set", args[1], string(buf), "keepttl"}); res != resp.Status("OK") {
+		return res
+	}
+	return old
+}
+
+// cmdGetBit supports GETBIT key offset.
+func (s *Server) cmdGetBit(c *client, args []string, next resp.Invoker) any {
+	offset, err := strconv.ParseUint(args[2], 10, 32)
+	if err != nil {
+		return resp.Error("ERR bit offset is not an integer or out of range")
+	}
+	val, res := get(c, args[1], next)
+	if res != nil {
+		return res
+	}
+	idx := int(offset / 8)
+	if idx >= len(val) || val[idx]&(byte(0x80)>>(offset%8)) == 0 {
+		return int64(0)
+	}
+	return int64(1)
+}
+
+// get returns the value of key, a missing key is empty.
+// The reply is returned if GET fails.
+func get(c *client, key string, next resp.Invoker) (string, any) {
+	switch res := next(c.conn, []string{"get", key}).(type) {
+	case string:
+		return res, nil
+	case nil:
+		return "", nil
+	default:
+		return "", res
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redistest
+
+import (
+	"github.com/beego/beego-cache/v2/server/resp"
+)
+
+// client is the state of a connection, guarded by Server.mu.
+type client struct {
+	conn *resp.Conn
+	// channels subscribed by the client
+	channels map[string]struct{}
+	// tracking state set by CLIENT TRACKING
+	tracking tracking
+}
+
+// send writes the replies to c out of band. A client which can not be written is closed,
+// so it is dropped. The caller must hold s.mu.
+func (s *Server) send(c *client, replies ...any) bool {
+	if err := c.conn.Send(replies...); err != nil {
+		s.drop(c)
+		return false
+	}
+	return true
+}
+
+// drop forgets the closed client c.
+func (s *Server) drop(c *client) {
+	s.unsubscribeAll(c)
+	s.untrack(c)
+	delete(s.clients, c.conn)
+}
+
+// cmdSubscribe supports SUBSCRIBE channel [channel ...].
+func (s *Server) cmdSubscribe(c *client, args []string, _ resp.Invoker) any {
+	res := make(resp.Replies, 0, len(args)-1)
+	for _, ch := range args[1:] {
+		s.subscribe(c, ch)
+		res = append(res, resp.Push{"subscribe", ch, int64(len(c.channels))})
+	}
+	return res
+}
+
+// cmdUnsubscribe supports UNSUBSCRIBE [channel [channel ...]].
+func (s *Server) cmdUnsubscribe(c *client, args []string, _ resp.Invoker) any {
+	channels := args[1:]
+	if len(channels) == 0 {
+		for ch := range c.channels {
+			channels = append(channels, ch)
+		}
+	}
+	if len(channels) == 0 {
+		return resp.Push{"unsubscribe", nil, int64(0)}
+	}
+	res := make(resp.Replies, 0, len(channels))
+	for _, ch := range channels {
+		s.unsubscribe(c, ch)
+		res = append(res, resp.Push{"unsubscribe", ch, int64(len(c.channels))})
+	}
+	return res
+}
+
+func (s *Server) subscribe(c *client, ch string) {
+	c.channels[ch] = struct{}{}
+	subs, ok := s.channels[ch]
+	if !ok {
+		subs = make(map[*client]struct{})
+		s.channels[ch] = subs
+	}
+	subs[c] = struct{}{}
+}
+
+func (s *Server) unsubscribe(c *client, ch string) {
+	delete(c.channels, ch)
+	if subs, ok := s.channels[ch]; ok {
+		delete(subs, c)
+		if len(subs) == 0 {
+			delete(s.channels, ch)
+		}
+	}
+}
+
+func (s *Server) unsubscribeAll(c *client) {
+	for ch := range c.channels {
+		s.unsubscribe(c, ch)
+	}
+}
+
+// cmdPublish delivers the message to the subscribers, and returns the number of them.
+func (s *Server) cmdPublish(_ *client, args []string, _ resp.Invoker) any {
+	var n int64
+	for c := range s.channels[args[1]] {
+		if s.send(c, resp.Push{"message", args[1], args[2]}) {
+			n++
+		}
+	}
+	return n
+}
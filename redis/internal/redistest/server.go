@@ -0,0 +1,212 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package redistest provides an in-process server speaking the redis protocol,
+// so that the redis adapter can be tested with a real go-redis client without Docker.
+//
+// It is a resp.Server backed by a MemoryCache, extended by an interceptor with the commands
+// only needed by the tests: SCAN, scripts, pub/sub, client side caching and bitmaps.
+package redistest
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"net"
+	"sync"
+	"time"
+
+	cache "github.com/beego/beego-cache/v2"
+	"github.com/beego/beego-cache/v2/server/resp"
+)
+
+// ScriptFunc is the Go implementation of a lua script.
+// call executes a redis command in the same way as redis.call does in lua,
+// keys and args are KEYS and ARGV of the script.
+type ScriptFunc func(call func(args ...string) any, keys []string, args []string) any
+
+// Server is an in-process redis server.
+// Commands are executed one by one, so every command and script is atomic.
+type Server struct {
+	srv  *resp.Server
+	ln   *listener
+	done chan struct{}
+
+	// mu is held by every command
+	mu sync.Mutex
+	// keys are the keys in the store, for SCAN
+	keys    map[string]struct{}
+	scripts map[string]ScriptFunc
+	clients map[*resp.Conn]*client
+	// channel -> subscribers
+	channels map[string]map[*client]struct{}
+	// key -> clients reading it with tracking enabled
+	tracked map[string]map[*client]struct{}
+}
+
+// NewServer starts a server listening on a random local port.
+func NewServer() (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		ln:       &listener{Listener: ln, conns: make(map[net.Conn]struct{})},
+		done:     make(chan struct{}),
+		keys:     make(map[string]struct{}),
+		scripts:  make(map[string]ScriptFunc),
+		clients:  make(map[*resp.Conn]*client),
+		channels: make(map[string]map[*client]struct{}),
+		tracked:  make(map[string]map[*client]struct{}),
+	}
+	s.srv = resp.NewServer(&store{Cache: cache.NewMemoryCache(0), s: s}, resp.ServerWithInterceptors(s.intercept))
+	go func() {
+		defer close(s.done)
+		_ = s.srv.Serve(s.ln)
+	}()
+	return s, nil
+}
+
+// Addr returns the address the server listening on.
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// RegisterScript registers fn as the implementation of the lua script src.
+// Both EVAL and EVALSHA of src will invoke fn.
+func (s *Server) RegisterScript(src string, fn ScriptFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts[scriptSHA(src)] = fn
+}
+
+// DropConnections closes all connections but keeps listening,
+// so that the reconnection of clients can be tested.
+func (s *Server) DropConnections() {
+	s.ln.closeConns()
+}
+
+// Close stops listening and closes all connections.
+func (s *Server) Close() error {
+	err := s.srv.Close()
+	<-s.done
+	return err
+}
+
+// intercept runs the commands of the tests, and the others by next, holding s.mu.
+func (s *Server) intercept(conn *resp.Conn, args []string, next resp.Invoker) any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.clients[conn]
+	if !ok {
+		c = &client{conn: conn, channels: make(map[string]struct{})}
+		s.clients[conn] = c
+	}
+	return s.exec(c, args, next)
+}
+
+// store is the cache of Server. It indexes the keys for SCAN,
+// and notifies the tracking clients of the changed keys.
+// It is only called by the commands, so s.mu is held.
+type store struct {
+	cache.Cache
+	s *Server
+}
+
+func (st *store) Get(ctx context.Context, key string) (any, error) {
+	val, err := st.Cache.Get(ctx, key)
+	if err != nil {
+		st.s.expire(key)
+	}
+	return val, err
+}
+
+func (st *store) IsExist(ctx context.Context, key string) (bool, error) {
+	ok, err := st.Cache.IsExist(ctx, key)
+	if err == nil && !ok {
+		st.s.expire(key)
+	}
+	return ok, err
+}
+
+func (st *store) Put(ctx context.Context, key string, val any, timeout time.Duration) error {
+	if err := st.Cache.Put(ctx, key, val, timeout); err != nil {
+		return err
+	}
+	st.s.keys[key] = struct{}{}
+	st.s.invalidate(key)
+	return nil
+}
+
+func (st *store) Delete(ctx context.Context, key string) error {
+	st.s.expire(key)
+	return st.Cache.Delete(ctx, key)
+}
+
+func (st *store) ClearAll(ctx context.Context) error {
+	st.s.keys = make(map[string]struct{})
+	st.s.invalidateAll()
+	return st.Cache.ClearAll(ctx)
+}
+
+// expire drops key which doesn't exist any more from the index, and notifies the tracking clients.
+func (s *Server) expire(key string) {
+	if _, ok := s.keys[key]; ok {
+		delete(s.keys, key)
+		s.invalidate(key)
+	}
+}
+
+// listener keeps the accepted connections for DropConnections
+type listener struct {
+	net.Listener
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.conns[conn] = struct{}{}
+	return &trackedConn{Conn: conn, l: l}, nil
+}
+
+func (l *listener) closeConns() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for conn := range l.conns {
+		_ = conn.Close()
+	}
+}
+
+type trackedConn struct {
+	net.Conn
+	l *listener
+}
+
+func (c *trackedConn) Close() error {
+	c.l.mu.Lock()
+	delete(c.l.conns, c.Conn)
+	c.l.mu.Unlock()
+	return c.Conn.Close()
+}
+
+func scriptSHA(src string) string {
+	sum := sha1.Sum([]byte(src))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,313 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redistest
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestClient(t *testing.T) (*Server, *redis.Client) {
+	s, err := NewServer()
+	require.NoError(t, err)
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = s.Close()
+	})
+	return s, client
+}
+
+func TestServer_String(t *testing.T) {
+	_, client := newTestClient(t)
+	ctx := context.Background()
+
+	require.NoError(t, client.Ping(ctx).Err())
+
+	_, err := client.Get(ctx, "key").Result()
+	assert.Equal(t, redis.Nil, err)
+
+	require.NoError(t, client.Set(ctx, "key", "value", 0).Err())
+	val, err := client.Get(ctx, "key").Result()
+	require.NoError(t, err)
+	assert.Equal(t, "value", val)
+
+	ok, err := client.SetNX(ctx, "key", "other", 0).Result()
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	vals, err := client.MGet(ctx, "key", "missing").Result()
+	require.NoError(t, err)
+	assert.Equal(t, []any{"value", nil}, vals)
+
+	n, err := client.Exists(ctx, "key", "missing").Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	n, err = client.Del(ctx, "key", "missing").Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+}
+
+func TestServer_Counter(t *testing.T) {
+	_, client := newTestClient(t)
+	ctx := context.Background()
+
+	n, err := client.Incr(ctx, "counter").Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	n, err = client.DecrBy(ctx, "counter", 3).Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(-2), n)
+
+	require.NoError(t, client.Set(ctx, "str", "abc", 0).Err())
+	assert.Error(t, client.Incr(ctx, "str").Err())
+}
+
+func TestServer_Bit(t *testing.T) {
+	_, client := newTestClient(t)
+	ctx := context.Background()
+
+	old, err := client.SetBit(ctx, "bits", 9, 1).Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), old)
+	val, err := client.Get(ctx, "bits").Result()
+	require.NoError(t, err)
+	assert.Equal(t, "\x00\x40", val)
+
+	bit, err := client.GetBit(ctx, "bits", 9).Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), bit)
+	bit, err = client.GetBit(ctx, "bits", 100).Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), bit)
+
+	old, err = client.SetBit(ctx, "bits", 9, 0).Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), old)
+	assert.Error(t, client.SetBit(ctx, "bits", -1, 1).Err())
+}
+
+func TestServer_Expire(t *testing.T) {
+	_, client := newTestClient(t)
+	ctx := context.Background()
+
+	require.NoError(t, client.Set(ctx, "key", "1", 50*time.Millisecond).Err())
+	ttl, err := client.PTTL(ctx, "key").Result()
+	require.NoError(t, err)
+	assert.True(t, ttl > 0 && ttl <= 50*time.Millisecond)
+
+	// incr keeps the time to live
+	require.NoError(t, client.Incr(ctx, "key").Err())
+	ok, err := client.PExpire(ctx, "key", 100*time.Millisecond).Result()
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	time.Sleep(150 * time.Millisecond)
+	_, err = client.Get(ctx, "key").Result()
+	assert.Equal(t, redis.Nil, err)
+
+	ok, err = client.PExpire(ctx, "key", time.Second).Result()
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestServer_Scan(t *testing.T) {
+	_, client := newTestClient(t)
+	ctx := context.Background()
+
+	for i := 0; i < 25; i++ {
+		require.NoError(t, client.Set(ctx, fmt.Sprintf("prefix:%d", i), i, 0).Err())
+	}
+	require.NoError(t, client.Set(ctx, "other", "val", 0).Err())
+
+	var (
+		cursor uint64
+		keys   []string
+	)
+	for {
+		ks, next, err := client.Scan(ctx, cursor, "prefix:*", 10).Result()
+		require.NoError(t, err)
+		keys = append(keys, ks...)
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	assert.Len(t, keys, 25)
+}
+
+func TestServer_Eval(t *testing.T) {
+	s, client := newTestClient(t)
+	ctx := context.Background()
+
+	const src = `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`
+	script := redis.NewScript(src)
+	s.RegisterScript(src, func(call func(args ...string) any, keys []string, args []string) any {
+		if call("GET", keys[0]) == args[0] {
+			return call("DEL", keys[0])
+		}
+		return int64(0)
+	})
+
+	require.NoError(t, client.Set(ctx, "lock", "owner", 0).Err())
+	n, err := script.Run(ctx, client, []string{"lock"}, "other").Int64()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+
+	n, err = script.Run(ctx, client, []string{"lock"}, "owner").Int64()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	err = redis.NewScript("return 1").Run(ctx, client, nil).Err()
+	assert.Error(t, err)
+}
+
+func TestMatch(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		str     string
+		want    bool
+	}{
+		{pattern: "*", str: "", want: true},
+		{pattern: "prefix:*", str: "prefix:key", want: true},
+		{pattern: "prefix:*", str: "other:key", want: false},
+		{pattern: "h?llo", str: "hello", want: true},
+		{pattern: "h[ae]llo", str: "hallo", want: true},
+		{pattern: "h[^e]llo", str: "hello", want: false},
+		{pattern: "h[a-c]llo", str: "hbllo", want: true},
+		{pattern: `h\*llo`, str: "h*llo", want: true},
+		{pattern: `h\*llo`, str: "hello", want: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.pattern+"_"+tc.str, func(t *testing.T) {
+			assert.Equal(t, tc.want, match(tc.pattern, tc.str))
+		})
+	}
+}
+
+func TestServer_PubSub(t *testing.T) {
+	s, client := newTestClient(t)
+	ctx := context.Background()
+
+	ps := client.Subscribe(ctx, "channel")
+	defer ps.Close()
+	msg, err := ps.Receive(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, &redis.Subscription{Kind: "subscribe", Channel: "channel", Count: 1}, msg)
+
+	n, err := client.Publish(ctx, "channel", "hello").Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	msg, err = ps.Receive(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", msg.(*redis.Message).Payload)
+
+	// the subscription is restored after reconnecting
+	s.DropConnections()
+	_, err = ps.Receive(ctx)
+	assert.Error(t, err)
+	msg, err = ps.Receive(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, &redis.Subscription{Kind: "subscribe", Channel: "channel", Count: 1}, msg)
+
+	require.NoError(t, ps.Unsubscribe(ctx, "channel"))
+	msg, err = ps.Receive(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, &redis.Subscription{Kind: "unsubscribe", Channel: "channel", Count: 0}, msg)
+	n, err = client.Publish(ctx, "channel", "hello").Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+}
+
+func TestServer_Tracking(t *testing.T) {
+	s, client := newTestClient(t)
+	ctx := context.Background()
+
+	// the connection receiving the invalidations
+	conn, err := net.Dial("tcp", s.Addr())
+	require.NoError(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	send := func(cmd string) string {
+		_, err := conn.Write([]byte(cmd + "\r\n"))
+		require.NoError(t, err)
+		return readFrame(t, r)
+	}
+	assert.Contains(t, send("HELLO 3"), "%7\r\n")
+	id := send("CLIENT ID")
+
+	// default mode, the reads of another connection are redirected
+	cn := client.Conn()
+	defer cn.Close()
+	require.NoError(t, cn.Process(ctx, redis.NewCmd(ctx, "CLIENT", "TRACKING", "ON", "REDIRECT", id[1:len(id)-2])))
+	require.NoError(t, client.Set(ctx, "key", "value", 0).Err())
+	require.NoError(t, cn.Get(ctx, "key").Err())
+	require.NoError(t, client.Set(ctx, "key", "other", 0).Err())
+	assert.Equal(t, ">2\r\n$10\r\ninvalidate\r\n*1\r\n$3\r\nkey\r\n", readFrame(t, r))
+	// notified only once until it is read again
+	require.NoError(t, client.Del(ctx, "key").Err())
+	require.NoError(t, client.FlushDB(ctx).Err())
+	assert.Equal(t, ">2\r\n$10\r\ninvalidate\r\n_\r\n", readFrame(t, r))
+
+	// broadcast mode
+	assert.Equal(t, "+OK\r\n", send("CLIENT TRACKING ON BCAST PREFIX app:"))
+	require.NoError(t, client.Set(ctx, "other", "value", 0).Err())
+	require.NoError(t, client.Incr(ctx, "app:counter").Err())
+	assert.Equal(t, ">2\r\n$10\r\ninvalidate\r\n*1\r\n$11\r\napp:counter\r\n", readFrame(t, r))
+
+	assert.Equal(t, "-ERR PREFIX option requires BCAST mode to be enabled\r\n",
+		send("CLIENT TRACKING ON PREFIX app:"))
+}
+
+// readFrame reads a reply made of simple frames, aggregates are read with their elements.
+func readFrame(t *testing.T, r *bufio.Reader) string {
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	switch line[0] {
+	case '$':
+		n, err := strconv.Atoi(line[1 : len(line)-2])
+		require.NoError(t, err)
+		buf := make([]byte, n+2)
+		_, err = io.ReadFull(r, buf)
+		require.NoError(t, err)
+		return line + string(buf)
+	case '*', '>', '%':
+		n, err := strconv.Atoi(line[1 : len(line)-2])
+		require.NoError(t, err)
+		if line[0] == '%' {
+			n *= 2
+		}
+		for i := 0; i < n; i++ {
+			line += readFrame(t, r)
+		}
+	}
+	return line
+}
@@ -0,0 +1,192 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redistest
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/beego/beego-cache/v2/server/resp"
+)
+
+// trackingChannel receives the invalidations redirected to RESP2 clients.
+const trackingChannel = "__redis__:invalidate"
+
+// tracking is the state of CLIENT TRACKING of a client.
+type tracking struct {
+	on       bool
+	bcast    bool
+	prefixes []string
+	// id of the client receiving the invalidations, zero means the client itself
+	redirect int64
+}
+
+// cmdClient supports CLIENT ID, CLIENT SETNAME and
+// CLIENT TRACKING ON|OFF [REDIRECT client-id] [PREFIX prefix ...] [BCAST].
+func (s *Server) cmdClient(c *client, args []string, _ resp.Invoker) any {
+	switch strings.ToLower(args[1]) {
+	case "id":
+		return c.conn.ID()
+	case "setname":
+		return resp.Status("OK")
+	case "tracking":
+		if len(args) < 3 {
+			return resp.Error("ERR wrong number of arguments for 'client|tracking' command")
+		}
+		return s.clientTracking(c, args[2:])
+	}
+	return resp.Error("ERR unknown subcommand '" + args[1] + "'")
+}
+
+func (s *Server) clientTracking(c *client, args []string) any {
+	switch strings.ToLower(args[0]) {
+	case "off":
+		s.untrack(c)
+		c.tracking = tracking{}
+		return resp.Status("OK")
+	case "on":
+	default:
+		return errSyntax
+	}
+	t := tracking{on: true}
+	for i := 1; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "bcast":
+			t.bcast = true
+		case "prefix":
+			if i+1 >= len(args) {
+				return errSyntax
+			}
+			i++
+			t.prefixes = append(t.prefixes, args[i])
+		case "redirect":
+			if i+1 >= len(args) {
+				return errSyntax
+			}
+			i++
+			id, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return errNotInteger
+			}
+			if s.clientByID(id) == nil {
+				return resp.Error("ERR The client ID you want redirect to does not exist")
+			}
+			t.redirect = id
+		default:
+			return errSyntax
+		}
+	}
+	if len(t.prefixes) > 0 && !t.bcast {
+		return resp.Error("ERR PREFIX option requires BCAST mode to be enabled")
+	}
+	s.untrack(c)
+	c.tracking = t
+	return resp.Status("OK")
+}
+
+// trackRead remembers the keys read by c if c tracks keys in the default mode.
+func (s *Server) trackRead(c *client, args []string) {
+	if !c.tracking.on || c.tracking.bcast {
+		return
+	}
+	switch strings.ToLower(args[0]) {
+	case "get", "mget":
+	default:
+		return
+	}
+	for _, key := range args[1:] {
+		clients, ok := s.tracked[key]
+		if !ok {
+			clients = make(map[*client]struct{})
+			s.tracked[key] = clients
+		}
+		clients[c] = struct{}{}
+	}
+}
+
+// untrack forgets the keys read by c.
+func (s *Server) untrack(c *client) {
+	for key, clients := range s.tracked {
+		delete(clients, c)
+		if len(clients) == 0 {
+			delete(s.tracked, key)
+		}
+	}
+}
+
+// invalidate notifies the clients tracking key that it is changed.
+// Like redis, a key read in the default mode is notified only once.
+func (s *Server) invalidate(key string) {
+	for c := range s.tracked[key] {
+		s.sendInvalidation(c, []any{key})
+	}
+	delete(s.tracked, key)
+	for _, c := range s.clients {
+		if !c.tracking.bcast {
+			continue
+		}
+		for _, prefix := range c.tracking.prefixes {
+			if strings.HasPrefix(key, prefix) {
+				s.sendInvalidation(c, []any{key})
+				break
+			}
+		}
+		if len(c.tracking.prefixes) == 0 {
+			s.sendInvalidation(c, []any{key})
+		}
+	}
+}
+
+// invalidateAll notifies all the tracking clients that the database is flushed.
+func (s *Server) invalidateAll() {
+	s.tracked = make(map[string]map[*client]struct{})
+	for _, c := range s.clients {
+		if c.tracking.on {
+			s.sendInvalidation(c, nil)
+		}
+	}
+}
+
+// sendInvalidation sends keys to c or the client c redirects to.
+// A nil keys means all the keys are invalid.
+// The message is a push for RESP3, or a message of trackingChannel for subscribed RESP2 clients.
+func (s *Server) sendInvalidation(c *client, keys []any) {
+	target := c
+	if c.tracking.redirect != 0 {
+		if target = s.clientByID(c.tracking.redirect); target == nil {
+			return
+		}
+	}
+	var payload any
+	if keys != nil {
+		payload = keys
+	}
+	if target.conn.Protocol() > 2 {
+		s.send(target, resp.Push{"invalidate", payload})
+		return
+	}
+	if _, ok := target.channels[trackingChannel]; ok {
+		s.send(target, resp.Push{"message", trackingChannel, payload})
+	}
+}
+
+func (s *Server) clientByID(id int64) *client {
+	for conn, c := range s.clients {
+		if conn.ID() == id {
+			return c
+		}
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	cache "github.com/beego/beego-cache/v2"
+)
+
+const defaultRetryInterval = time.Second
+
+// invalidationMessage is published on the invalidation channel.
+// All means that all the keys of the prefix are invalid.
+type invalidationMessage struct {
+	Keys []string `json:"keys,omitempty"`
+	All  bool     `json:"all,omitempty"`
+}
+
+// invalidationChannel returns the channel to publish the invalidations of prefix.
+func invalidationChannel(prefix string) string {
+	return prefix + ":__invalidation__"
+}
+
+// publishInvalidation publishes msg, the errors are handled by the invalidation error handler
+// instead of failing the write which succeeds already.
+func (rc *Cache) publishInvalidation(ctx context.Context, msg invalidationMessage) {
+	if !rc.invalidation {
+		return
+	}
+	data, err := json.Marshal(msg)
+	if err == nil {
+		err = rc.client.Publish(ctx, invalidationChannel(rc.prefix), data).Err()
+	}
+	if err != nil {
+		rc.onInvalidationError(err)
+	}
+}
+
+type InvalidationOptions func(s *InvalidationSubscriber)
+
+// InvalidationWithPrefix configures the prefix of the redis cache publishing invalidations.
+// prefix should not be empty string
+func InvalidationWithPrefix(prefix string) InvalidationOptions {
+	if prefix == "" {
+		panic("prefix should not be empty")
+	}
+	return func(s *InvalidationSubscriber) {
+		s.prefix = prefix
+	}
+}
+
+// InvalidationWithRetryInterval configures how long to wait before receiving again
+// when the connection is broken, default one second.
+func InvalidationWithRetryInterval(interval time.Duration) InvalidationOptions {
+	return func(s *InvalidationSubscriber) {
+		s.retryInterval = interval
+	}
+}
+
+// InvalidationWithErrorHandler configures the function to handle the errors of receiving
+// and evicting, by default errors are ignored.
+func InvalidationWithErrorHandler(fn func(err error)) InvalidationOptions {
+	return func(s *InvalidationSubscriber) {
+		s.onError = fn
+	}
+}
+
+// InvalidationSubscriber evicts the keys published by the redis caches
+// created with CacheWithInvalidation from a local cache, usually a MemoryCache in front of redis.
+//
+// The messages published while the subscriber is disconnected are lost,
+// so the local cache is cleared after the subscriber reconnects.
+type InvalidationSubscriber struct {
+	local         cache.Cache
+	prefix        string
+	retryInterval time.Duration
+	onError       func(err error)
+
+	pubsub *redis.PubSub
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewInvalidationSubscriber subscribes the invalidation channel and
+// evicts the invalid keys from local until Close is called.
+// It returns after the subscription is confirmed by redis.
+func NewInvalidationSubscriber(ctx context.Context, client redis.UniversalClient,
+	local cache.Cache, opts ...InvalidationOptions,
+) (*InvalidationSubscriber, error) {
+	s := &InvalidationSubscriber{
+		local:         local,
+		prefix:        defaultPrefix,
+		retryInterval: defaultRetryInterval,
+		onError:       func(error) {},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.pubsub = client.Subscribe(ctx, invalidationChannel(s.prefix))
+	if _, err := s.pubsub.Receive(ctx); err != nil {
+		_ = s.pubsub.Close()
+		return nil, err
+	}
+
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.wg.Add(1)
+	go s.loop()
+	return s, nil
+}
+
+// Close unsubscribes and stops evicting.
+func (s *InvalidationSubscriber) Close() error {
+	s.cancel()
+	err := s.pubsub.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *InvalidationSubscriber) loop() {
+	defer s.wg.Done()
+	for {
+		msg, err := s.pubsub.Receive(s.ctx)
+		if s.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			s.onError(err)
+			// PubSub reconnects and subscribes again on next receiving
+			select {
+			case <-time.After(s.retryInterval):
+			case <-s.ctx.Done():
+				return
+			}
+			continue
+		}
+
+		switch m := msg.(type) {
+		case *redis.Subscription:
+			// the first confirmation is received by NewInvalidationSubscriber,
+			// so this one means the connection is rebuilt.
+			if m.Kind == "subscribe" {
+				if err = s.local.ClearAll(s.ctx); err != nil {
+					s.onError(err)
+				}
+			}
+		case *redis.Message:
+			s.evict(m.Payload)
+		}
+	}
+}
+
+func (s *InvalidationSubscriber) evict(payload string) {
+	var msg invalidationMessage
+	if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+		s.onError(err)
+		return
+	}
+	if msg.All {
+		if err := s.local.ClearAll(s.ctx); err != nil {
+			s.onError(err)
+		}
+		return
+	}
+	for _, key := range msg.Keys {
+		if err := s.local.Delete(s.ctx, key); err != nil {
+			s.onError(err)
+		}
+	}
+}
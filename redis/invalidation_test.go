@@ -0,0 +1,181 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cache "github.com/beego/beego-cache/v2"
+	"github.com/beego/beego-cache/v2/redis/internal/redistest"
+)
+
+type invalidationPod struct {
+	local cache.Cache
+	cache cache.Cache
+}
+
+// newInvalidationPods returns n pods sharing one redis server,
+// each of them has a local cache in front of redis.
+func newInvalidationPods(t *testing.T, n int) (*redistest.Server, []invalidationPod) {
+	srv, err := redistest.NewServer()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = srv.Close()
+	})
+
+	pods := make([]invalidationPod, 0, n)
+	for i := 0; i < n; i++ {
+		client := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+		local := cache.NewMemoryCache(0)
+		sub, err := NewInvalidationSubscriber(context.Background(), client, local,
+			InvalidationWithPrefix("app"), InvalidationWithRetryInterval(10*time.Millisecond))
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			assert.NoError(t, sub.Close())
+			_ = client.Close()
+		})
+
+		remote := NewRedisCache(client, CacheWithPrefix("app"), CacheWithInvalidation())
+		c, err := cache.NewMultiLevelCache(local, remote)
+		require.NoError(t, err)
+		pods = append(pods, invalidationPod{local: local, cache: c})
+	}
+	return srv, pods
+}
+
+func TestInvalidationSubscriber_Evict(t *testing.T) {
+	_, pods := newInvalidationPods(t, 2)
+	ctx := context.Background()
+
+	require.NoError(t, pods[0].cache.Put(ctx, "key1", "value1", time.Minute))
+	require.NoError(t, pods[0].cache.Put(ctx, "key2", "value2", time.Minute))
+	for _, key := range []string{"key1", "key2"} {
+		_, err := pods[1].cache.Get(ctx, key)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, pods[0].cache.Delete(ctx, "key1"))
+	assert.Eventually(t, func() bool {
+		ok, _ := pods[1].local.IsExist(ctx, "key1")
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+	ok, _ := pods[1].local.IsExist(ctx, "key2")
+	assert.True(t, ok)
+
+	require.NoError(t, pods[0].cache.ClearAll(ctx))
+	assert.Eventually(t, func() bool {
+		ok, _ := pods[1].local.IsExist(ctx, "key2")
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestInvalidationSubscriber_Update(t *testing.T) {
+	_, pods := newInvalidationPods(t, 2)
+	ctx := context.Background()
+
+	require.NoError(t, pods[0].cache.Put(ctx, "key", "old", time.Minute))
+	val, err := pods[1].cache.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "old", val)
+
+	require.NoError(t, pods[0].cache.Put(ctx, "key", "new", time.Minute))
+	assert.Eventually(t, func() bool {
+		val, err := pods[1].cache.Get(ctx, "key")
+		return err == nil && val == "new"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestInvalidationSubscriber_Reconnect(t *testing.T) {
+	srv, pods := newInvalidationPods(t, 1)
+	ctx := context.Background()
+	local := pods[0].local
+
+	require.NoError(t, local.Put(ctx, "key", "value", time.Minute))
+	// the invalidations published while disconnected are lost,
+	// so the local cache should be cleared after reconnecting
+	srv.DropConnections()
+	assert.Eventually(t, func() bool {
+		ok, _ := local.IsExist(ctx, "key")
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+
+	// the subscription still works
+	require.NoError(t, local.Put(ctx, "key", "value", time.Minute))
+	require.NoError(t, pods[0].cache.Delete(ctx, "other"))
+	require.NoError(t, pods[0].cache.Delete(ctx, "key"))
+	assert.Eventually(t, func() bool {
+		ok, _ := local.IsExist(ctx, "key")
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestNewInvalidationSubscriber_Failed(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	defer client.Close()
+	_, err := NewInvalidationSubscriber(context.Background(), client, cache.NewMemoryCache(0))
+	assert.Error(t, err)
+}
+
+// publishFailingHook fails the PUBLISH commands
+type publishFailingHook struct{}
+
+func (publishFailingHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (publishFailingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if cmd.Name() == "publish" {
+			cmd.SetErr(errors.New("publish failed"))
+			return cmd.Err()
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (publishFailingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestCache_InvalidationPublishFailed(t *testing.T) {
+	srv, err := redistest.NewServer()
+	require.NoError(t, err)
+	client := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = srv.Close()
+	})
+	client.AddHook(publishFailingHook{})
+	var errs []error
+	c := NewRedisCache(client, CacheWithInvalidation(), CacheWithInvalidationErrorHandler(func(err error) {
+		errs = append(errs, err)
+	}))
+	ctx := context.Background()
+
+	// the writes succeed, and the publishing errors are handled
+	require.NoError(t, c.Put(ctx, "counter", 1, time.Minute))
+	require.NoError(t, c.Incr(ctx, "counter"))
+	require.NoError(t, c.Decr(ctx, "counter"))
+	require.NoError(t, c.Delete(ctx, "counter"))
+	require.NoError(t, c.ClearAll(ctx))
+	assert.Len(t, errs, 5)
+}
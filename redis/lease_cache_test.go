@@ -0,0 +1,72 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cache "github.com/beego/beego-cache/v2"
+	"github.com/beego/beego-cache/v2/redis/internal/redistest"
+)
+
+// TestLeaseCache checks that a lease issued by one process blocks the stale fill of another.
+func TestLeaseCache(t *testing.T) {
+	srv, err := redistest.NewServer()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = srv.Close() })
+	newCache := func() *Cache {
+		client := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+		t.Cleanup(func() { _ = client.Close() })
+		return NewRedisCache(client, CacheWithPrefix("lease")).(*Cache)
+	}
+	ctx := context.Background()
+
+	loading, loaded := make(chan struct{}), make(chan struct{})
+	reader, err := cache.NewLeaseCache(newCache(), time.Minute, func(ctx context.Context, key string) (any, error) {
+		close(loading)
+		<-loaded
+		return "old", nil
+	}, cache.WithLeaseCacheDoubleDelete(0))
+	require.NoError(t, err)
+	writer, err := cache.NewLeaseCache(newCache(), time.Minute, func(ctx context.Context, key string) (any, error) {
+		return "new", nil
+	}, cache.WithLeaseCacheDoubleDelete(0))
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		val, err := reader.Get(ctx, "key")
+		assert.NoError(t, err)
+		assert.Equal(t, "old", val)
+	}()
+	<-loading
+	require.NoError(t, writer.Delete(ctx, "key"))
+	close(loaded)
+	<-done
+
+	ok, err := writer.IsExist(ctx, "key")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	val, err := writer.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "new", val)
+}
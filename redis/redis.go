@@ -28,9 +28,16 @@ const defaultPrefix = "beecacheRedis"
 
 // Cache is Redis cache adapter.
 type Cache struct {
-	client    redis.Cmdable // redis client
-	prefix    string
-	scanCount int64
+	client       redis.Cmdable // redis client
+	prefix       string
+	scanCount    int64
+	invalidation bool
+	// onInvalidationError handles the errors of publishing invalidations
+	onInvalidationError func(err error)
+
+	clientSideCaching bool
+	trackingOpts      []ClientSideCachingOptions
+	tracker           *tracker
 }
 
 type CacheOptions func(c *Cache)
@@ -53,20 +60,66 @@ func CacheWithPrefix(key string) CacheOptions {
 	}
 }
 
+// CacheWithInvalidation makes the cache publish the keys it changes
+// on the invalidation channel of its prefix.
+// Use NewInvalidationSubscriber with the same prefix to evict those keys from local caches.
+func CacheWithInvalidation() CacheOptions {
+	return func(c *Cache) {
+		c.invalidation = true
+	}
+}
+
+// CacheWithInvalidationErrorHandler configures the function to handle the errors of publishing
+// the invalidations, by default errors are ignored.
+// The writes succeed even if the publishing fails, so the local caches may keep the stale values until they expire.
+func CacheWithInvalidationErrorHandler(fn func(err error)) CacheOptions {
+	return func(c *Cache) {
+		c.onInvalidationError = fn
+	}
+}
+
 // NewRedisCache creates a new redis cache with default collection name.
 func NewRedisCache(client redis.Cmdable, opts ...CacheOptions) cache.Cache {
 	res := &Cache{
-		client:    client,
-		prefix:    defaultPrefix,
-		scanCount: 1024,
+		client:              client,
+		prefix:              defaultPrefix,
+		scanCount:           1024,
+		onInvalidationError: func(error) {},
 	}
 
 	for _, opt := range opts {
 		opt(res)
 	}
+	if res.clientSideCaching {
+		res.tracker = newTracker(res)
+	}
 	return res
 }
 
+// Close stops the client side caching enabled by CacheWithClientSideCaching.
+// The redis client is not closed.
+func (rc *Cache) Close() error {
+	if rc.tracker == nil {
+		return nil
+	}
+	return rc.tracker.close()
+}
+
+// evictLocal drops keys from the local map of client side caching, all the keys if keys is nil.
+func (rc *Cache) evictLocal(keys ...string) {
+	if rc.tracker == nil {
+		return
+	}
+	if keys == nil {
+		rc.tracker.invalidate(nil)
+		return
+	}
+	for i, key := range keys {
+		keys[i] = rc.associate(key)
+	}
+	rc.tracker.invalidate(keys)
+}
+
 // associate with config prefix.
 func (rc *Cache) associate(originKey interface{}) string {
 	return fmt.Sprintf("%s:%s", rc.prefix, originKey)
@@ -74,6 +127,9 @@ func (rc *Cache) associate(originKey interface{}) string {
 
 // Get cache from redis.
 func (rc *Cache) Get(ctx context.Context, key string) (interface{}, error) {
+	if rc.tracker != nil {
+		return rc.tracker.get(ctx, rc.associate(key))
+	}
 	return rc.client.Get(ctx, rc.associate(key)).Result()
 }
 
@@ -83,17 +139,30 @@ func (rc *Cache) GetMulti(ctx context.Context, keys []string) ([]interface{}, er
 	for _, key := range keys {
 		args = append(args, rc.associate(key))
 	}
+	if rc.tracker != nil {
+		return rc.tracker.getMulti(ctx, args)
+	}
 	return rc.client.MGet(ctx, args...).Result()
 }
 
 // Put puts cache into redis.
 func (rc *Cache) Put(ctx context.Context, key string, val interface{}, timeout time.Duration) error {
-	return rc.client.Set(ctx, rc.associate(key), val, timeout).Err()
+	if err := rc.client.Set(ctx, rc.associate(key), val, timeout).Err(); err != nil {
+		return err
+	}
+	rc.evictLocal(key)
+	rc.publishInvalidation(ctx, invalidationMessage{Keys: []string{key}})
+	return nil
 }
 
 // Delete deletes a prefix's cache in redis.
 func (rc *Cache) Delete(ctx context.Context, key string) error {
-	return rc.client.Del(ctx, rc.associate(key)).Err()
+	if err := rc.client.Del(ctx, rc.associate(key)).Err(); err != nil {
+		return err
+	}
+	rc.evictLocal(key)
+	rc.publishInvalidation(ctx, invalidationMessage{Keys: []string{key}})
+	return nil
 }
 
 // IsExist checks cache's existence in redis.
@@ -104,12 +173,22 @@ func (rc *Cache) IsExist(ctx context.Context, key string) (bool, error) {
 
 // Incr increases a prefix's counter in redis.
 func (rc *Cache) Incr(ctx context.Context, key string) error {
-	return rc.client.Incr(ctx, rc.associate(key)).Err()
+	if err := rc.client.Incr(ctx, rc.associate(key)).Err(); err != nil {
+		return err
+	}
+	rc.evictLocal(key)
+	rc.publishInvalidation(ctx, invalidationMessage{Keys: []string{key}})
+	return nil
 }
 
 // Decr decreases a prefix's counter in redis.
 func (rc *Cache) Decr(ctx context.Context, key string) error {
-	return rc.client.Decr(ctx, rc.associate(key)).Err()
+	if err := rc.client.Decr(ctx, rc.associate(key)).Err(); err != nil {
+		return err
+	}
+	rc.evictLocal(key)
+	rc.publishInvalidation(ctx, invalidationMessage{Keys: []string{key}})
+	return nil
 }
 
 // ClearAll deletes all cache in the redis collection
@@ -122,8 +201,12 @@ func (rc *Cache) ClearAll(ctx context.Context) error {
 		return err
 	}
 	if len(cachedKeys) > 0 {
-		return rc.client.Del(ctx, cachedKeys...).Err()
+		if err = rc.client.Del(ctx, cachedKeys...).Err(); err != nil {
+			return err
+		}
 	}
+	rc.evictLocal()
+	rc.publishInvalidation(ctx, invalidationMessage{All: true})
 	return nil
 }
 
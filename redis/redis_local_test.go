@@ -0,0 +1,230 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cache "github.com/beego/beego-cache/v2"
+	"github.com/beego/beego-cache/v2/redis/internal/redistest"
+)
+
+// newLocalRedisCache returns a Cache connected to an in-process redis server.
+func newLocalRedisCache(t *testing.T, opts ...CacheOptions) *Cache {
+	srv, err := redistest.NewServer()
+	require.NoError(t, err)
+	client := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = srv.Close()
+	})
+	return NewRedisCache(client, opts...).(*Cache)
+}
+
+func TestCache_Local_GetPut(t *testing.T) {
+	c := newLocalRedisCache(t)
+	ctx := context.Background()
+
+	testCases := []struct {
+		name    string
+		key     string
+		value   string
+		timeout time.Duration
+		wait    time.Duration
+		wantErr error
+	}{
+		{
+			name:    "get val",
+			key:     "key1",
+			value:   "author",
+			timeout: time.Second,
+		},
+		{
+			name:    "never expire",
+			key:     "key2",
+			value:   "author",
+			timeout: 0,
+		},
+		{
+			name:    "expired",
+			key:     "key3",
+			value:   "author",
+			timeout: 50 * time.Millisecond,
+			wait:    100 * time.Millisecond,
+			wantErr: redis.Nil,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.NoError(t, c.Put(ctx, tc.key, tc.value, tc.timeout))
+			time.Sleep(tc.wait)
+			val, err := c.Get(ctx, tc.key)
+			assert.Equal(t, tc.wantErr, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tc.value, val)
+		})
+	}
+}
+
+func TestCache_Local_GetMulti(t *testing.T) {
+	c := newLocalRedisCache(t)
+	ctx := context.Background()
+
+	require.NoError(t, c.Put(ctx, "key1", "value1", time.Second))
+	require.NoError(t, c.Put(ctx, "key2", "value2", time.Second))
+
+	vals, err := c.GetMulti(ctx, []string{"key1", "key2", "key3"})
+	require.NoError(t, err)
+	assert.Equal(t, []any{"value1", "value2", nil}, vals)
+}
+
+func TestCache_Local_DeleteAndIsExist(t *testing.T) {
+	c := newLocalRedisCache(t)
+	ctx := context.Background()
+
+	require.NoError(t, c.Put(ctx, "key1", "value1", time.Second))
+	ok, err := c.IsExist(ctx, "key1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	require.NoError(t, c.Delete(ctx, "key1"))
+	ok, err = c.IsExist(ctx, "key1")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// delete a key not found should not return error
+	assert.NoError(t, c.Delete(ctx, "key1"))
+}
+
+func TestCache_Local_IncrAndDecr(t *testing.T) {
+	c := newLocalRedisCache(t)
+	ctx := context.Background()
+
+	require.NoError(t, c.Put(ctx, "counter", 1, time.Second))
+	require.NoError(t, c.Incr(ctx, "counter"))
+	val, err := c.Get(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, "2", val)
+
+	require.NoError(t, c.Decr(ctx, "counter"))
+	require.NoError(t, c.Decr(ctx, "counter"))
+	val, err = c.Get(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, "0", val)
+
+	require.NoError(t, c.Put(ctx, "str", "author", time.Second))
+	assert.Error(t, c.Incr(ctx, "str"))
+}
+
+func TestCache_Local_ScanAndClearAll(t *testing.T) {
+	c := newLocalRedisCache(t, CacheWithScanCount(10))
+	other := NewRedisCache(c.client, CacheWithPrefix("other"))
+	ctx := context.Background()
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, c.Put(ctx, fmt.Sprintf("astaxie%d", i), fmt.Sprintf("author%d", i), time.Second))
+	}
+	require.NoError(t, other.Put(ctx, "astaxie", "author", time.Second))
+
+	keys, err := c.Scan(ctx, defaultPrefix+":*")
+	require.NoError(t, err)
+	assert.Len(t, keys, 100)
+
+	require.NoError(t, c.ClearAll(ctx))
+	keys, err = c.Scan(ctx, defaultPrefix+":*")
+	require.NoError(t, err)
+	assert.Len(t, keys, 0)
+
+	// keys of other prefix should not be deleted
+	ok, err := other.IsExist(ctx, "astaxie")
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestCache_Local_Retry(t *testing.T) {
+	srv, err := redistest.NewServer()
+	require.NoError(t, err)
+	client := redis.NewClient(&redis.Options{Addr: srv.Addr(), MaxRetries: -1})
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	var calls int32
+	c, err := cache.NewRetryCache(cache.Chain(NewRedisCache(client),
+		func(ctx context.Context, inv cache.Invocation, next cache.Invoker) cache.Result {
+			atomic.AddInt32(&calls, 1)
+			return next(ctx, inv)
+		}), cache.WithRetryCacheBackoff(time.Millisecond, time.Millisecond), cache.WithRetryCacheBudget(0, 0))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// a miss is not retried
+	_, err = c.Get(ctx, "missing")
+	assert.Equal(t, redis.Nil, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// a network error is
+	require.NoError(t, srv.Close())
+	_, err = c.Get(ctx, "missing")
+	assert.True(t, cache.IsRetriable(err))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+
+	assert.Equal(t, cache.ErrorClassFailure, classifyError(replyError("LOADING Redis is loading the dataset in memory")))
+	assert.Equal(t, cache.ErrorClassUnknown, classifyError(replyError("WRONGTYPE Operation against a key")))
+	assert.Equal(t, cache.ErrorClassUnknown, classifyError(errors.New("LOADING")))
+}
+
+func TestCache_Local_CircuitBreaker(t *testing.T) {
+	srv, err := redistest.NewServer()
+	require.NoError(t, err)
+	client := redis.NewClient(&redis.Options{Addr: srv.Addr(), MaxRetries: -1})
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	c, err := cache.NewCircuitBreakerCache(NewRedisCache(client))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// the misses don't open the circuit
+	for i := 0; i < 10; i++ {
+		_, err = c.Get(ctx, "missing")
+		assert.Equal(t, redis.Nil, err)
+	}
+	assert.Equal(t, cache.CircuitClosed, c.State())
+
+	// the network errors do
+	require.NoError(t, srv.Close())
+	for i := 0; i < 5; i++ {
+		_, _ = c.Get(ctx, "missing")
+	}
+	assert.Equal(t, cache.CircuitOpen, c.State())
+}
+
+// replyError is an error replied by redis
+type replyError string
+
+func (e replyError) Error() string { return string(e) }
+
+func (replyError) RedisError() {}
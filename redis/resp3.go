@@ -0,0 +1,112 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// go-redis can not read the push messages sent out of band,
+// so the tracking connection speaks RESP3 by itself with the following helpers.
+
+// respPush is a RESP3 push message.
+type respPush []interface{}
+
+// respError is an error reply.
+type respError string
+
+func (e respError) Error() string {
+	return string(e)
+}
+
+// writeCommand writes a command as an array of bulk strings, and flushes w.
+func writeCommand(w *bufio.Writer, args ...string) error {
+	_, _ = fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		_, _ = fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return w.Flush()
+}
+
+// readValue reads a RESP2 or RESP3 value.
+// Error replies are returned as respError values instead of errors.
+// Maps are returned as slices with the keys and values interleaved, and attributes are skipped.
+func readValue(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: invalid reply %q", line)
+	}
+	typ, payload := line[0], line[1:len(line)-2]
+	switch typ {
+	case '+', ',', '(':
+		return payload, nil
+	case '-':
+		return respError(payload), nil
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '_':
+		return nil, nil
+	case '#':
+		return payload == "t", nil
+	case '$', '=':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		if typ == '=' && n >= 4 {
+			// skip the format of verbatim strings, such as txt:
+			return string(buf[4:n]), nil
+		}
+		return string(buf[:n]), nil
+	case '*', '~', '>', '%', '|':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		if typ == '%' || typ == '|' {
+			n *= 2
+		}
+		elems := make([]interface{}, n)
+		for i := range elems {
+			if elems[i], err = readValue(r); err != nil {
+				return nil, err
+			}
+		}
+		switch typ {
+		case '>':
+			return respPush(elems), nil
+		case '|':
+			return readValue(r)
+		}
+		return elems, nil
+	}
+	return nil, fmt.Errorf("redis: invalid reply %q", line)
+}
@@ -0,0 +1,62 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cache "github.com/beego/beego-cache/v2"
+)
+
+func TestXFetchCache_Redis(t *testing.T) {
+	ctx := context.Background()
+	bm := newLocalRedisCache(t)
+	c, err := cache.NewXFetchCache(bm, time.Minute, func(ctx context.Context, key string) (any, error) {
+		time.Sleep(time.Millisecond)
+		return 1, nil
+	}, cache.WithXFetchCacheBeta(1e12))
+	require.NoError(t, err)
+
+	val, err := c.Get(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, 1, val)
+
+	// the loaded value is stored as it is, so Incr and the other readers still work
+	require.NoError(t, bm.Incr(ctx, "counter"))
+	val, err = bm.Get(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, "2", val)
+
+	// the delta and expiry are read back, so the value is recomputed with a huge beta
+	val, err = c.Get(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, 1, val)
+
+	// Put drops the delta, the value is no longer recomputed
+	require.NoError(t, c.Put(ctx, "counter", "value", time.Minute))
+	val, err = c.Get(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, "value", val)
+
+	require.NoError(t, c.Delete(ctx, "counter"))
+	vals, err := bm.GetMulti(ctx, []string{"counter", "__xfetch__:counter"})
+	require.NoError(t, err)
+	assert.Equal(t, []any{nil, nil}, vals)
+}
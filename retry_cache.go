@@ -0,0 +1,251 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	berror "github.com/beego/beego-error/v2"
+)
+
+// RetryCacheOption configures the cache created by NewRetryCache
+type RetryCacheOption func(r *retrier)
+
+// WithRetryCacheTimeout configures the timeout of every attempt of all the operations, default no timeout.
+// The backend must respect the context, like the redis adapter, for the timeout to take effect.
+func WithRetryCacheTimeout(timeout time.Duration) RetryCacheOption {
+	return func(r *retrier) {
+		r.timeout = timeout
+	}
+}
+
+// WithRetryCacheOperationTimeout configures the timeout of every attempt of op, like OpGet,
+// overriding WithRetryCacheTimeout.
+func WithRetryCacheOperationTimeout(op string, timeout time.Duration) RetryCacheOption {
+	return func(r *retrier) {
+		r.opTimeouts[op] = timeout
+	}
+}
+
+// WithRetryCacheMaxAttempts configures the max number of attempts of an operation, default 3.
+func WithRetryCacheMaxAttempts(n int) RetryCacheOption {
+	return func(r *retrier) {
+		r.maxAttempts = n
+	}
+}
+
+// WithRetryCacheBackoff configures the backoff between the attempts, default from 10ms to 1s.
+// The n-th retry waits a random duration in [0, min(max, base * 2^(n-1))].
+func WithRetryCacheBackoff(base, max time.Duration) RetryCacheOption {
+	return func(r *retrier) {
+		r.baseBackoff = base
+		r.maxBackoff = max
+	}
+}
+
+// WithRetryCacheRetriableCodes configures the error codes to retry instead of IsRetriable.
+// The misses are never retried, see ClassifyError.
+func WithRetryCacheRetriableCodes(codes ...berror.Code) RetryCacheOption {
+	return func(r *retrier) {
+		r.codes = make(map[uint32]struct{}, len(codes))
+		for _, code := range codes {
+			r.codes[code.Code()] = struct{}{}
+		}
+	}
+}
+
+// WithRetryCacheRetriable configures the function telling if an error is retriable,
+// overriding the codes, default IsRetriable.
+func WithRetryCacheRetriable(fn func(err error) bool) RetryCacheOption {
+	return func(r *retrier) {
+		r.retriable = fn
+	}
+}
+
+// WithRetryCacheBudget configures the retry budget, default 10 tokens and a ratio of 0.1.
+// Every retriable failure takes a token and every success gives back ratio token, up to maxTokens.
+// The operations are not retried when there are maxTokens/2 or less tokens,
+// so that the retries do not amplify the load during an outage.
+// Zero maxTokens disables the budget.
+func WithRetryCacheBudget(maxTokens, ratio float64) RetryCacheOption {
+	return func(r *retrier) {
+		r.maxTokens = maxTokens
+		r.tokenRatio = ratio
+	}
+}
+
+// idempotentOps are the operations which can be retried, Incr and Decr are not
+var idempotentOps = map[string]struct{}{
+	OpGet: {}, OpGetMulti: {}, OpPut: {}, OpDelete: {}, OpIsExist: {}, OpClearAll: {},
+}
+
+// retrier retries the operations
+type retrier struct {
+	timeout     time.Duration
+	opTimeouts  map[string]time.Duration
+	maxAttempts int
+	baseBackoff time.Duration
+	maxBackoff  time.Duration
+	codes       map[uint32]struct{}
+	retriable   func(err error) bool
+	maxTokens   float64
+	tokenRatio  float64
+
+	mu     sync.Mutex
+	tokens float64
+	rand   *rand.Rand
+}
+
+// NewRetryCache creates a Cache applying timeouts to the operations of c and retrying them,
+// which is Chain(c, RetryInterceptor(opts...)).
+//
+// Only the idempotent operations are retried, which are all but Incr and Decr,
+// and only when they fail with a retriable error or the timeout of the attempt,
+// see WithRetryCacheRetriableCodes and WithRetryCacheRetriable.
+// The operations stop retrying when ctx is done.
+func NewRetryCache(c Cache, opts ...RetryCacheOption) (Cache, error) {
+	if c == nil {
+		return nil, berror.Error(InvalidInitParameters, "cache can not be nil")
+	}
+	interceptor, err := RetryInterceptor(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return Chain(c, interceptor), nil
+}
+
+// RetryInterceptor returns an Interceptor applying timeouts to every operation and retrying it, see NewRetryCache.
+func RetryInterceptor(opts ...RetryCacheOption) (Interceptor, error) {
+	r := &retrier{
+		opTimeouts:  make(map[string]time.Duration),
+		maxAttempts: 3,
+		baseBackoff: 10 * time.Millisecond,
+		maxBackoff:  time.Second,
+		maxTokens:   10,
+		tokenRatio:  0.1,
+		rand:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.maxAttempts < 1 || r.baseBackoff < 0 || r.maxBackoff < r.baseBackoff ||
+		r.maxTokens < 0 || r.tokenRatio < 0 {
+		return nil, berror.Errorf(InvalidInitParameters,
+			"invalid max attempts %d, backoff [%v, %v] or budget [%v, %v]",
+			r.maxAttempts, r.baseBackoff, r.maxBackoff, r.maxTokens, r.tokenRatio)
+	}
+	if r.retriable == nil && r.codes != nil {
+		r.retriable = r.isRetriableCode
+	}
+	if r.retriable == nil {
+		r.retriable = IsRetriable
+	}
+	r.tokens = r.maxTokens
+	return r.intercept, nil
+}
+
+func (r *retrier) intercept(ctx context.Context, inv Invocation, next Invoker) Result {
+	_, idempotent := idempotentOps[inv.Operation]
+	for attempt := 1; ; attempt++ {
+		res, timedOut := r.attempt(ctx, inv, next)
+		if res.Err == nil {
+			r.succeed()
+			return res
+		}
+		if !timedOut && !r.retriable(res.Err) {
+			return res
+		}
+		// the operations never retried don't take the tokens
+		if !idempotent || attempt >= r.maxAttempts || !r.fail() {
+			return res
+		}
+		timer := time.NewTimer(r.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return res
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt runs inv once with the timeout, timedOut is true if the timeout of the attempt expired
+func (r *retrier) attempt(ctx context.Context, inv Invocation, next Invoker) (res Result, timedOut bool) {
+	timeout, ok := r.opTimeouts[inv.Operation]
+	if !ok {
+		timeout = r.timeout
+	}
+	if timeout <= 0 {
+		return next(ctx, inv), false
+	}
+	attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	res = next(attemptCtx, inv)
+	timedOut = res.Err != nil && ctx.Err() == nil && errors.Is(attemptCtx.Err(), context.DeadlineExceeded)
+	return res, timedOut
+}
+
+func (r *retrier) isRetriableCode(err error) bool {
+	if ClassifyError(err) == ErrorClassMiss {
+		return false
+	}
+	code, _ := berror.FromError(err)
+	_, ok := r.codes[code.Code()]
+	return ok
+}
+
+// backoff returns the random delay before the retry following the attempt-th attempt
+func (r *retrier) backoff(attempt int) time.Duration {
+	d := r.maxBackoff
+	if shift := attempt - 1; shift < 62 && r.baseBackoff<<shift < r.maxBackoff && r.baseBackoff<<shift > 0 {
+		d = r.baseBackoff << shift
+	}
+	if d <= 0 {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return time.Duration(r.rand.Int63n(int64(d) + 1))
+}
+
+// succeed gives back tokenRatio token to the budget
+func (r *retrier) succeed() {
+	if r.maxTokens == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tokens += r.tokenRatio
+	if r.tokens > r.maxTokens {
+		r.tokens = r.maxTokens
+	}
+}
+
+// fail takes a token from the budget and reports whether the operation may be retried
+func (r *retrier) fail() bool {
+	if r.maxTokens == 0 {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.tokens > 0 {
+		r.tokens--
+	}
+	return r.tokens > r.maxTokens/2
+}
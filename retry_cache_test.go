@@ -0,0 +1,157 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	berror "github.com/beego/beego-error/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingInterceptor fails the first n calls with code
+func failingInterceptor(n int32, code berror.Code, calls *int32) Interceptor {
+	return func(ctx context.Context, inv Invocation, next Invoker) Result {
+		if atomic.AddInt32(calls, 1) <= n {
+			return Result{Err: berror.Error(code, "connection reset")}
+		}
+		return next(ctx, inv)
+	}
+}
+
+func TestRetryCache(t *testing.T) {
+	ctx := context.Background()
+	newCache := func(n int32, code berror.Code, calls *int32, opts ...RetryCacheOption) Cache {
+		opts = append([]RetryCacheOption{WithRetryCacheBackoff(time.Millisecond, 2*time.Millisecond)}, opts...)
+		interceptor, err := RetryInterceptor(opts...)
+		require.NoError(t, err)
+		return Chain(NewMemoryCache(0), interceptor, failingInterceptor(n, code, calls))
+	}
+
+	// retried until it succeeds
+	var calls int32
+	c := newCache(2, RedisCacheCurdFailed, &calls)
+	require.NoError(t, c.Put(ctx, "key", 1, time.Minute))
+	assert.Equal(t, int32(3), calls)
+
+	// gives up after the max attempts
+	calls = 0
+	c = newCache(5, MemCacheCurdFailed, &calls)
+	_, err := c.Get(ctx, "key")
+	code, _ := berror.FromError(err)
+	assert.Equal(t, MemCacheCurdFailed.Code(), code.Code())
+	assert.Equal(t, int32(3), calls)
+
+	// not retriable code
+	calls = 0
+	c = newCache(5, InvalidMemCacheValue, &calls)
+	_, err = c.Get(ctx, "key")
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), calls)
+
+	// not idempotent
+	calls = 0
+	c = newCache(5, SsdbCacheCurdFailed, &calls)
+	assert.Error(t, c.Incr(ctx, "key"))
+	assert.Equal(t, int32(1), calls)
+
+	// the budget stops retrying during an outage
+	calls = 0
+	c = newCache(100, DialFailed, &calls, WithRetryCacheBudget(4, 1))
+	_, err = c.Get(ctx, "key")
+	assert.Error(t, err)
+	assert.Equal(t, int32(2), calls)
+	_, err = c.Get(ctx, "key")
+	assert.Error(t, err)
+	assert.Equal(t, int32(3), calls)
+
+	// the failures never retried don't drain the budget
+	calls = 0
+	c = newCache(6, DialFailed, &calls, WithRetryCacheBudget(4, 1))
+	for i := 0; i < 5; i++ {
+		assert.Error(t, c.Incr(ctx, "key"))
+	}
+	require.NoError(t, c.Put(ctx, "key", 1, time.Minute))
+	assert.Equal(t, int32(7), calls)
+
+	_, err = NewRetryCache(nil)
+	assert.Error(t, err)
+	_, err = RetryInterceptor(WithRetryCacheMaxAttempts(0))
+	assert.Error(t, err)
+}
+
+func TestRetryCache_Timeout(t *testing.T) {
+	var calls int32
+	slow := func(ctx context.Context, inv Invocation, next Invoker) Result {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			<-ctx.Done()
+			return Result{Err: ctx.Err()}
+		}
+		return next(ctx, inv)
+	}
+	interceptor, err := RetryInterceptor(WithRetryCacheTimeout(time.Second),
+		WithRetryCacheOperationTimeout(OpGet, 10*time.Millisecond), WithRetryCacheBackoff(0, 0))
+	require.NoError(t, err)
+	bm := NewMemoryCache(0)
+	require.NoError(t, bm.Put(context.Background(), "key", "value", time.Minute))
+	c := Chain(bm, interceptor, slow)
+
+	val, err := c.Get(context.Background(), "key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", val)
+	assert.Equal(t, int32(2), calls)
+
+	// the context of the caller is done
+	calls = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.Get(ctx, "key")
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), calls)
+}
+
+func TestIsRetriable(t *testing.T) {
+	assert.False(t, IsRetriable(nil))
+	assert.True(t, IsRetriable(berror.Error(RedisCacheCurdFailed, "timeout")))
+	assert.False(t, IsRetriable(ErrKeyNotExist))
+	assert.False(t, IsRetriable(errors.New("WRONGTYPE")))
+
+	// the raw network errors
+	assert.True(t, IsRetriable(io.EOF))
+	assert.True(t, IsRetriable(fmt.Errorf("read: %w", io.ErrUnexpectedEOF)))
+	assert.True(t, IsRetriable(&net.OpError{Op: "dial", Err: errors.New("connection refused")}))
+	assert.True(t, IsRetriable(context.DeadlineExceeded))
+
+	// the misses known by the classifiers are never retriable
+	miss := errors.New("test: miss")
+	RegisterErrorClassifier(func(err error) ErrorClass {
+		if errors.Is(err, miss) {
+			return ErrorClassMiss
+		}
+		return ErrorClassUnknown
+	})
+	err := berror.Wrap(miss, MemCacheCurdFailed, "could not read data")
+	assert.Equal(t, ErrorClassMiss, ClassifyError(err))
+	assert.False(t, IsRetriable(err))
+	assert.True(t, IsRetriable(berror.Error(MemCacheCurdFailed, "could not read data")))
+}
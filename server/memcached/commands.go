@@ -0,0 +1,523 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memcached
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cache "github.com/beego/beego-cache/v2"
+)
+
+const (
+	// Version is returned by the version command.
+	Version = "1.6.0-beego"
+
+	maxKeyLength  = 250
+	maxLineLength = 2048
+	// get and gets may have many keys, so their lines can be much longer, like real memcached
+	maxGetLineLength = 1024 * 1024
+	// exptime larger than it is an absolute unix timestamp
+	maxRelativeExptime = 60 * 60 * 24 * 30
+)
+
+var errLineTooLong = errors.New("memcached: line too long")
+
+// itemMeta is the part of a memcached item which can not be stored in cache.Cache.
+type itemMeta struct {
+	flags uint32
+	cas   uint64
+	// zero means never expire
+	deadline time.Time
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if err == nil && len(line) <= maxLineLength {
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+	buf := append([]byte(nil), line...)
+	for errors.Is(err, bufio.ErrBufferFull) && isGetLine(buf) && len(buf) <= maxGetLineLength {
+		line, err = r.ReadSlice('\n')
+		buf = append(buf, line...)
+	}
+	if errors.Is(err, bufio.ErrBufferFull) || len(buf) > maxGetLineLength ||
+		(len(buf) > maxLineLength && !isGetLine(buf)) {
+		return "", errLineTooLong
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(buf), "\r\n"), nil
+}
+
+// isGetLine reports whether line is a get or gets command
+func isGetLine(line []byte) bool {
+	return bytes.HasPrefix(line, []byte("get ")) || bytes.HasPrefix(line, []byte("gets "))
+}
+
+// handle executes one command and writes the response to w.
+// It returns false if the connection should be closed.
+func (s *Server) handle(line string, r *bufio.Reader, w *bufio.Writer) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		_, _ = w.WriteString("ERROR\r\n")
+		return true
+	}
+	switch cmd := fields[0]; cmd {
+	case "get", "gets":
+		s.get(w, fields[1:], cmd == "gets")
+	case "set", "add", "replace", "append", "prepend", "cas":
+		return s.store(r, w, cmd, fields[1:])
+	case "delete":
+		s.delete(w, fields[1:])
+	case "incr", "decr":
+		s.incrDecr(w, cmd == "incr", fields[1:])
+	case "touch":
+		s.touch(w, fields[1:])
+	case "flush_all":
+		s.flushAll(w, fields[1:])
+	case "version":
+		_, _ = fmt.Fprintf(w, "VERSION %s\r\n", Version)
+	case "quit":
+		return false
+	default:
+		_, _ = w.WriteString("ERROR\r\n")
+	}
+	return true
+}
+
+func (s *Server) get(w *bufio.Writer, keys []string, withCas bool) {
+	if len(keys) == 0 {
+		_, _ = w.WriteString("ERROR\r\n")
+		return
+	}
+	for _, key := range keys {
+		if !validKey(key) {
+			clientError(w, "bad command line format")
+			return
+		}
+	}
+	for _, key := range keys {
+		mu := s.lock(key)
+		val, meta, ok, err := s.lookup(key)
+		mu.Unlock()
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		if !ok {
+			continue
+		}
+		if withCas {
+			_, _ = fmt.Fprintf(w, "VALUE %s %d %d %d\r\n", key, meta.flags, len(val), meta.cas)
+		} else {
+			_, _ = fmt.Fprintf(w, "VALUE %s %d %d\r\n", key, meta.flags, len(val))
+		}
+		_, _ = w.Write(val)
+		_, _ = w.WriteString("\r\n")
+	}
+	_, _ = w.WriteString("END\r\n")
+}
+
+// store handles <cmd> <key> <flags> <exptime> <bytes> [<cas unique>] [noreply]
+func (s *Server) store(r *bufio.Reader, w *bufio.Writer, cmd string, args []string) bool {
+	argc := 4
+	if cmd == "cas" {
+		argc = 5
+	}
+	noreply := len(args) == argc+1 && args[argc] == "noreply"
+	if len(args) != argc && !noreply {
+		clientError(w, "bad command line format")
+		return true
+	}
+	key := args[0]
+	flags, err1 := strconv.ParseUint(args[1], 10, 32)
+	exptime, err2 := strconv.ParseInt(args[2], 10, 64)
+	size, err3 := strconv.Atoi(args[3])
+	if !validKey(key) || err1 != nil || err2 != nil || err3 != nil || size < 0 {
+		clientError(w, "bad command line format")
+		return true
+	}
+	var casUnique uint64
+	if cmd == "cas" {
+		var err error
+		if casUnique, err = strconv.ParseUint(args[4], 10, 64); err != nil {
+			clientError(w, "bad command line format")
+			return true
+		}
+	}
+
+	if size > s.maxItemSize {
+		// swallow the data block so that the connection can still be used
+		if _, err := io.CopyN(io.Discard, r, int64(size)+2); err != nil {
+			return false
+		}
+		serverErrorMsg(w, "object too large for cache")
+		return true
+	}
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return false
+	}
+	if !bytes.HasSuffix(data, []byte("\r\n")) {
+		clientError(w, "bad data chunk")
+		return false
+	}
+	data = data[:size]
+
+	res, err := s.storeItem(cmd, key, uint32(flags), exptime, data, casUnique)
+	if err != nil {
+		serverError(w, err)
+		return true
+	}
+	if !noreply {
+		_, _ = w.WriteString(res)
+	}
+	return true
+}
+
+func (s *Server) storeItem(cmd, key string, flags uint32, exptime int64, data []byte, casUnique uint64) (string, error) {
+	mu := s.lock(key)
+	defer mu.Unlock()
+
+	old, meta, exist, err := s.lookup(key)
+	if err != nil {
+		return "", err
+	}
+	switch cmd {
+	case "add":
+		if exist {
+			return "NOT_STORED\r\n", nil
+		}
+	case "replace":
+		if !exist {
+			return "NOT_STORED\r\n", nil
+		}
+	case "append", "prepend":
+		if !exist {
+			return "NOT_STORED\r\n", nil
+		}
+		// append and prepend ignore flags and exptime
+		flags = meta.flags
+		if cmd == "append" {
+			data = append(append(make([]byte, 0, len(old)+len(data)), old...), data...)
+		} else {
+			data = append(append(make([]byte, 0, len(old)+len(data)), data...), old...)
+		}
+		return "STORED\r\n", s.save(key, data, flags, meta.deadline)
+	case "cas":
+		if !exist {
+			return "NOT_FOUND\r\n", nil
+		}
+		if meta.cas != casUnique {
+			return "EXISTS\r\n", nil
+		}
+	}
+
+	deadline, expired := parseExptime(exptime)
+	if expired {
+		// a negative exptime makes the item expire immediately
+		return "STORED\r\n", s.remove(key)
+	}
+	return "STORED\r\n", s.save(key, data, flags, deadline)
+}
+
+// delete handles delete <key> [noreply]
+func (s *Server) delete(w *bufio.Writer, args []string) {
+	noreply := len(args) == 2 && args[1] == "noreply"
+	if (len(args) != 1 && !noreply) || !validKey(args[0]) {
+		clientError(w, "bad command line format.  Usage: delete <key> [noreply]")
+		return
+	}
+	key := args[0]
+	mu := s.lock(key)
+	_, _, exist, err := s.lookup(key)
+	if err == nil && exist {
+		err = s.remove(key)
+	}
+	mu.Unlock()
+
+	switch {
+	case err != nil:
+		serverError(w, err)
+	case noreply:
+	case exist:
+		_, _ = w.WriteString("DELETED\r\n")
+	default:
+		_, _ = w.WriteString("NOT_FOUND\r\n")
+	}
+}
+
+// incrDecr handles incr|decr <key> <value> [noreply].
+// Like memcached, incr wraps around on overflow and decr stops at 0.
+func (s *Server) incrDecr(w *bufio.Writer, incr bool, args []string) {
+	noreply := len(args) == 3 && args[2] == "noreply"
+	if (len(args) != 2 && !noreply) || !validKey(args[0]) {
+		clientError(w, "bad command line format")
+		return
+	}
+	delta, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		clientError(w, "invalid numeric delta argument")
+		return
+	}
+
+	key := args[0]
+	mu := s.lock(key)
+	defer mu.Unlock()
+
+	val, meta, exist, err := s.lookup(key)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if !exist {
+		if !noreply {
+			_, _ = w.WriteString("NOT_FOUND\r\n")
+		}
+		return
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(string(val)), 10, 64)
+	if err != nil {
+		clientError(w, "cannot increment or decrement non-numeric value")
+		return
+	}
+	switch {
+	case incr:
+		n += delta
+	case delta > n:
+		n = 0
+	default:
+		n -= delta
+	}
+	res := strconv.FormatUint(n, 10)
+	if err = s.save(key, []byte(res), meta.flags, meta.deadline); err != nil {
+		serverError(w, err)
+		return
+	}
+	if !noreply {
+		_, _ = w.WriteString(res + "\r\n")
+	}
+}
+
+// touch handles touch <key> <exptime> [noreply]
+func (s *Server) touch(w *bufio.Writer, args []string) {
+	noreply := len(args) == 3 && args[2] == "noreply"
+	if (len(args) != 2 && !noreply) || !validKey(args[0]) {
+		clientError(w, "bad command line format")
+		return
+	}
+	exptime, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		clientError(w, "invalid exptime argument")
+		return
+	}
+
+	key := args[0]
+	mu := s.lock(key)
+	defer mu.Unlock()
+
+	val, meta, exist, err := s.lookup(key)
+	if err == nil && exist {
+		deadline, expired := parseExptime(exptime)
+		if expired {
+			err = s.remove(key)
+		} else {
+			err = s.save(key, val, meta.flags, deadline)
+		}
+	}
+	switch {
+	case err != nil:
+		serverError(w, err)
+	case noreply:
+	case exist:
+		_, _ = w.WriteString("TOUCHED\r\n")
+	default:
+		_, _ = w.WriteString("NOT_FOUND\r\n")
+	}
+}
+
+// flushAll handles flush_all [delay] [noreply]
+func (s *Server) flushAll(w *bufio.Writer, args []string) {
+	noreply := len(args) > 0 && args[len(args)-1] == "noreply"
+	if noreply {
+		args = args[:len(args)-1]
+	}
+	var delay int64
+	if len(args) > 0 {
+		var err error
+		if delay, err = strconv.ParseInt(args[0], 10, 64); err != nil || len(args) > 1 || delay < 0 {
+			clientError(w, "bad command line format")
+			return
+		}
+	}
+
+	if delay > 0 {
+		go func() {
+			t := time.NewTimer(time.Duration(delay) * time.Second)
+			defer t.Stop()
+			select {
+			case <-t.C:
+				_ = s.flush()
+			case <-s.ctx.Done():
+			}
+		}()
+	} else if err := s.flush(); err != nil {
+		serverError(w, err)
+		return
+	}
+	if !noreply {
+		_, _ = w.WriteString("OK\r\n")
+	}
+}
+
+func (s *Server) flush() error {
+	s.metaMu.Lock()
+	defer s.metaMu.Unlock()
+	s.metas = make(map[string]*itemMeta)
+	return s.cache.ClearAll(s.ctx)
+}
+
+// lock locks and returns the mutex guarding key.
+func (s *Server) lock(key string) *sync.Mutex {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	mu := &s.locks[h.Sum32()%lockStripes]
+	mu.Lock()
+	return mu
+}
+
+// lookup returns the value and metadata of key.
+// The caller must hold the lock of key.
+func (s *Server) lookup(key string) ([]byte, itemMeta, bool, error) {
+	val, err := s.cache.Get(s.ctx, key)
+	if err != nil || val == nil {
+		// adapters report missing key in different ways, so double check it
+		exist, er := s.cache.IsExist(s.ctx, key)
+		if er == nil && !exist {
+			s.dropMeta(key)
+			return nil, itemMeta{}, false, nil
+		}
+		if err == nil {
+			err = er
+		}
+		return nil, itemMeta{}, false, err
+	}
+
+	s.metaMu.Lock()
+	defer s.metaMu.Unlock()
+	meta, ok := s.metas[key]
+	if !ok {
+		// the item is put by other users of the cache
+		s.casSeq++
+		meta = &itemMeta{cas: s.casSeq}
+		s.metas[key] = meta
+	}
+	return toBytes(val), *meta, true, nil
+}
+
+// save puts the value into cache and assigns a new cas unique to it.
+// The caller must hold the lock of key.
+func (s *Server) save(key string, val []byte, flags uint32, deadline time.Time) error {
+	var timeout time.Duration
+	if !deadline.IsZero() {
+		if timeout = time.Until(deadline); timeout <= 0 {
+			return s.remove(key)
+		}
+	}
+	if err := s.cache.Put(s.ctx, key, val, timeout); err != nil {
+		return err
+	}
+	s.metaMu.Lock()
+	defer s.metaMu.Unlock()
+	s.casSeq++
+	s.metas[key] = &itemMeta{flags: flags, cas: s.casSeq, deadline: deadline}
+	return nil
+}
+
+// remove deletes key from cache.
+// The caller must hold the lock of key.
+func (s *Server) remove(key string) error {
+	s.dropMeta(key)
+	return s.cache.Delete(s.ctx, key)
+}
+
+func (s *Server) dropMeta(key string) {
+	s.metaMu.Lock()
+	defer s.metaMu.Unlock()
+	delete(s.metas, key)
+}
+
+// parseExptime converts memcached exptime to deadline.
+// Zero means never expire, a negative value means the item is expired immediately.
+func parseExptime(exptime int64) (deadline time.Time, expired bool) {
+	switch {
+	case exptime == 0:
+		return time.Time{}, false
+	case exptime < 0:
+		return time.Time{}, true
+	case exptime > maxRelativeExptime:
+		deadline = time.Unix(exptime, 0)
+		return deadline, !deadline.After(time.Now())
+	default:
+		return time.Now().Add(time.Duration(exptime) * time.Second), false
+	}
+}
+
+func toBytes(val any) []byte {
+	switch v := val.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	default:
+		return []byte(cache.GetString(v))
+	}
+}
+
+func validKey(key string) bool {
+	if len(key) == 0 || len(key) > maxKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+func clientError(w *bufio.Writer, msg string) {
+	_, _ = fmt.Fprintf(w, "CLIENT_ERROR %s\r\n", msg)
+}
+
+func serverError(w *bufio.Writer, err error) {
+	serverErrorMsg(w, err.Error())
+}
+
+func serverErrorMsg(w *bufio.Writer, msg string) {
+	// the message must be a single line
+	msg = strings.NewReplacer("\r", " ", "\n", " ").Replace(msg)
+	_, _ = fmt.Fprintf(w, "SERVER_ERROR %s\r\n", msg)
+}
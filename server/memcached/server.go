@@ -0,0 +1,281 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package memcached serves any cache.Cache over the memcached text protocol.
+//
+// It can be used as a hermetic test double for the memcache adapter,
+// or to expose a Go-side cache to services written in other languages.
+//
+// Supported commands: get, gets, set, add, replace, append, prepend, cas,
+// delete, incr, decr, touch, flush_all, version and quit.
+package memcached
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	cache "github.com/beego/beego-cache/v2"
+)
+
+// ErrServerClosed is returned by Serve after Close is called.
+var ErrServerClosed = errors.New("memcached: server closed")
+
+const (
+	defaultMaxItemSize   = 1024 * 1024
+	defaultSweepInterval = time.Minute
+	lockStripes          = 256
+)
+
+type ServerOptions func(s *Server)
+
+// ServerWithMaxItemSize configures the max size of a value in bytes, default 1MB.
+func ServerWithMaxItemSize(size int) ServerOptions {
+	return func(s *Server) {
+		s.maxItemSize = size
+	}
+}
+
+// ServerWithIdleTimeout configures how long an idle connection is kept.
+// Zero means connections never time out, which is the default.
+func ServerWithIdleTimeout(timeout time.Duration) ServerOptions {
+	return func(s *Server) {
+		s.idleTimeout = timeout
+	}
+}
+
+// ServerWithSweepInterval configures how often the metadata of the items expired or evicted
+// from the cache is dropped, default 1 minute.
+func ServerWithSweepInterval(interval time.Duration) ServerOptions {
+	return func(s *Server) {
+		s.sweepInterval = interval
+	}
+}
+
+// Server is a memcached server backed by a cache.Cache.
+//
+// Flags, cas unique and deadline of the items are kept by Server
+// because cache.Cache can not store them. Values are stored as []byte.
+// Values put by other users of the Cache are served as well,
+// they are converted by cache.GetString.
+type Server struct {
+	cache         cache.Cache
+	maxItemSize   int
+	idleTimeout   time.Duration
+	sweepInterval time.Duration
+
+	// locks make the read-modify-write commands, like cas and incr, atomic per key
+	locks  [lockStripes]sync.Mutex
+	metaMu sync.Mutex
+	metas  map[string]*itemMeta
+	casSeq uint64
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	sweepOnce sync.Once
+	sweepDone chan struct{}
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// NewServer creates a Server serving c.
+func NewServer(c cache.Cache, opts ...ServerOptions) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		cache:         c,
+		maxItemSize:   defaultMaxItemSize,
+		sweepInterval: defaultSweepInterval,
+		metas:         make(map[string]*itemMeta),
+		ctx:           ctx,
+		cancel:        cancel,
+		sweepDone:     make(chan struct{}),
+		listeners:     make(map[net.Listener]struct{}),
+		conns:         make(map[net.Conn]struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// ListenAndServe listens on the TCP network address addr and then calls Serve.
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts connections on ln and serves them until Close is called.
+// It always returns a non-nil error, ErrServerClosed after Close.
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = ln.Close()
+		return ErrServerClosed
+	}
+	s.listeners[ln] = struct{}{}
+	s.wg.Add(1)
+	s.mu.Unlock()
+	defer s.wg.Done()
+
+	s.sweepOnce.Do(func() {
+		go s.sweep()
+	})
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			delete(s.listeners, ln)
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = conn.Close()
+			continue
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Close closes all listeners and connections, and waits for the handlers to return.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	var err error
+	for ln := range s.listeners {
+		if er := ln.Close(); er != nil && err == nil {
+			err = er
+		}
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.cancel()
+	s.sweepOnce.Do(func() {
+		close(s.sweepDone)
+	})
+	<-s.sweepDone
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		if s.idleTimeout > 0 {
+			_ = conn.SetReadDeadline(time.Now().Add(s.idleTimeout))
+		}
+		line, err := readLine(r)
+		if err != nil {
+			if errors.Is(err, errLineTooLong) {
+				_, _ = w.WriteString("CLIENT_ERROR line too long\r\n")
+				_ = w.Flush()
+			}
+			return
+		}
+		if !s.handle(line, r, w) {
+			_ = w.Flush()
+			return
+		}
+		// flush once the pipelined commands are all handled
+		if r.Buffered() == 0 {
+			if err = w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// sweep drops the metadata of the items expired or evicted from the cache periodically,
+// because the items may never be accessed via the Server again.
+func (s *Server) sweep() {
+	defer close(s.sweepDone)
+	if s.sweepInterval <= 0 {
+		<-s.ctx.Done()
+		return
+	}
+	ticker := time.NewTicker(s.sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.sweepMetas()
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// sweepMetas drops the metadata of the expired items,
+// and of the items which don't exist in the cache any more, like the evicted ones.
+func (s *Server) sweepMetas() {
+	now := time.Now()
+	s.metaMu.Lock()
+	keys := make([]string, 0, len(s.metas))
+	for key, meta := range s.metas {
+		if !meta.deadline.IsZero() && meta.deadline.Before(now) {
+			delete(s.metas, key)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	s.metaMu.Unlock()
+
+	for _, key := range keys {
+		if s.ctx.Err() != nil {
+			return
+		}
+		mu := s.lock(key)
+		if exist, err := s.cache.IsExist(s.ctx, key); err == nil && !exist {
+			s.dropMeta(key)
+		}
+		mu.Unlock()
+	}
+}
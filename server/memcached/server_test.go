@@ -0,0 +1,265 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memcached
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cache "github.com/beego/beego-cache/v2"
+)
+
+func startServer(t *testing.T, c cache.Cache, opts ...ServerOptions) (*Server, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := NewServer(c, opts...)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve(ln)
+	}()
+	t.Cleanup(func() {
+		require.NoError(t, s.Close())
+		assert.Equal(t, ErrServerClosed, <-done)
+	})
+	return s, ln.Addr().String()
+}
+
+func TestServer_SetGet(t *testing.T) {
+	_, addr := startServer(t, cache.NewMemoryCache(0))
+	client := memcache.New(addr)
+
+	_, err := client.Get("key")
+	assert.Equal(t, memcache.ErrCacheMiss, err)
+
+	require.NoError(t, client.Set(&memcache.Item{Key: "key", Value: []byte("value"), Flags: 7}))
+	item, err := client.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), item.Value)
+	assert.Equal(t, uint32(7), item.Flags)
+
+	assert.Equal(t, memcache.ErrNotStored, client.Add(&memcache.Item{Key: "key", Value: []byte("other")}))
+	require.NoError(t, client.Add(&memcache.Item{Key: "key2", Value: []byte("value2")}))
+	assert.Equal(t, memcache.ErrNotStored, client.Replace(&memcache.Item{Key: "key3", Value: []byte("value3")}))
+	require.NoError(t, client.Replace(&memcache.Item{Key: "key2", Value: []byte("new")}))
+	require.NoError(t, client.Append(&memcache.Item{Key: "key2", Value: []byte("er")}))
+	require.NoError(t, client.Prepend(&memcache.Item{Key: "key2", Value: []byte("the ")}))
+
+	items, err := client.GetMulti([]string{"key", "key2", "key3"})
+	require.NoError(t, err)
+	assert.Len(t, items, 2)
+	assert.Equal(t, []byte("the newer"), items["key2"].Value)
+
+	require.NoError(t, client.Delete("key"))
+	assert.Equal(t, memcache.ErrCacheMiss, client.Delete("key"))
+}
+
+func TestServer_CompareAndSwap(t *testing.T) {
+	_, addr := startServer(t, cache.NewMemoryCache(0))
+	client := memcache.New(addr)
+
+	require.NoError(t, client.Set(&memcache.Item{Key: "key", Value: []byte("v1")}))
+	item, err := client.Get("key")
+	require.NoError(t, err)
+
+	// the item is modified by someone else
+	require.NoError(t, client.Set(&memcache.Item{Key: "key", Value: []byte("v2")}))
+	item.Value = []byte("v3")
+	assert.Equal(t, memcache.ErrCASConflict, client.CompareAndSwap(item))
+
+	item, err = client.Get("key")
+	require.NoError(t, err)
+	item.Value = []byte("v3")
+	require.NoError(t, client.CompareAndSwap(item))
+
+	require.NoError(t, client.Delete("key"))
+	assert.Equal(t, memcache.ErrCacheMiss, client.CompareAndSwap(item))
+}
+
+func TestServer_IncrDecr(t *testing.T) {
+	_, addr := startServer(t, cache.NewMemoryCache(0))
+	client := memcache.New(addr)
+
+	_, err := client.Increment("counter", 1)
+	assert.Equal(t, memcache.ErrCacheMiss, err)
+
+	require.NoError(t, client.Set(&memcache.Item{Key: "counter", Value: []byte("10")}))
+	n, err := client.Increment("counter", 5)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(15), n)
+
+	n, err = client.Decrement("counter", 20)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), n)
+
+	require.NoError(t, client.Set(&memcache.Item{Key: "str", Value: []byte("abc")}))
+	_, err = client.Increment("str", 1)
+	assert.Error(t, err)
+}
+
+func TestServer_Expiration(t *testing.T) {
+	_, addr := startServer(t, cache.NewMemoryCache(0))
+	client := memcache.New(addr)
+
+	require.NoError(t, client.Set(&memcache.Item{Key: "key", Value: []byte("value"), Expiration: 1}))
+	require.NoError(t, client.Set(&memcache.Item{Key: "gone", Value: []byte("value"), Expiration: -1}))
+	require.NoError(t, client.Touch("key", 10))
+	assert.Equal(t, memcache.ErrCacheMiss, client.Touch("gone", 10))
+
+	time.Sleep(1100 * time.Millisecond)
+	_, err := client.Get("key")
+	assert.NoError(t, err)
+
+	require.NoError(t, client.Touch("key", -1))
+	_, err = client.Get("key")
+	assert.Equal(t, memcache.ErrCacheMiss, err)
+}
+
+func TestServer_LongLines(t *testing.T) {
+	_, addr := startServer(t, cache.NewMemoryCache(0))
+	client := memcache.New(addr)
+
+	keys := make([]string, 0, 30)
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("%s-%d", strings.Repeat("k", 100), i)
+		keys = append(keys, key)
+		require.NoError(t, client.Set(&memcache.Item{Key: key, Value: []byte("value")}))
+	}
+	items, err := client.GetMulti(keys)
+	require.NoError(t, err)
+	assert.Len(t, items, 30)
+
+	// the other commands are still limited
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("delete " + strings.Repeat("k", 5000) + "\r\n"))
+	require.NoError(t, err)
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "CLIENT_ERROR line too long\r\n", line)
+}
+
+func TestServer_Sweep(t *testing.T) {
+	bm := cache.NewMemoryCache(0)
+	s, addr := startServer(t, bm, ServerWithSweepInterval(20*time.Millisecond))
+	client := memcache.New(addr)
+
+	require.NoError(t, client.Set(&memcache.Item{Key: "expired", Value: []byte("value"), Expiration: 1}))
+	require.NoError(t, client.Set(&memcache.Item{Key: "evicted", Value: []byte("value")}))
+	require.NoError(t, client.Set(&memcache.Item{Key: "kept", Value: []byte("value")}))
+	require.NoError(t, bm.Delete(context.Background(), "evicted"))
+
+	assert.Eventually(t, func() bool {
+		s.metaMu.Lock()
+		defer s.metaMu.Unlock()
+		_, ok := s.metas["kept"]
+		return len(s.metas) == 1 && ok
+	}, 3*time.Second, 20*time.Millisecond)
+}
+
+func TestServer_FlushAll(t *testing.T) {
+	bm := cache.NewMemoryCache(0)
+	_, addr := startServer(t, bm)
+	client := memcache.New(addr)
+
+	require.NoError(t, client.Set(&memcache.Item{Key: "key", Value: []byte("value")}))
+	require.NoError(t, client.FlushAll())
+	ok, err := bm.IsExist(context.Background(), "key")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestServer_ValuesFromGoSide(t *testing.T) {
+	bm := cache.NewMemoryCache(0)
+	_, addr := startServer(t, bm)
+	client := memcache.New(addr)
+	ctx := context.Background()
+
+	require.NoError(t, bm.Put(ctx, "str", "value", 0))
+	require.NoError(t, bm.Put(ctx, "int", 12, 0))
+
+	item, err := client.Get("str")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), item.Value)
+
+	n, err := client.Increment("int", 1)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(13), n)
+
+	require.NoError(t, client.Set(&memcache.Item{Key: "bytes", Value: []byte("value")}))
+	val, err := bm.Get(ctx, "bytes")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), val)
+}
+
+func TestServer_Protocol(t *testing.T) {
+	_, addr := startServer(t, cache.NewMemoryCache(0), ServerWithMaxItemSize(8))
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	testCases := []struct {
+		name string
+		req  string
+		resp []string
+	}{
+		{
+			name: "unknown command",
+			req:  "unknown\r\n",
+			resp: []string{"ERROR\r\n"},
+		},
+		{
+			name: "noreply",
+			req:  "set key 0 0 1 noreply\r\na\r\nget key\r\n",
+			resp: []string{"VALUE key 0 1\r\n", "a\r\n", "END\r\n"},
+		},
+		{
+			name: "too large",
+			req:  "set key 0 0 10\r\n0123456789\r\nversion\r\n",
+			resp: []string{"SERVER_ERROR object too large for cache\r\n", "VERSION " + Version + "\r\n"},
+		},
+		{
+			name: "invalid key",
+			req:  "delete \x01key\r\n",
+			resp: []string{"CLIENT_ERROR bad command line format.  Usage: delete <key> [noreply]\r\n"},
+		},
+		{
+			name: "bad data chunk",
+			req:  "set key 0 0 1\r\nabc\r\n",
+			resp: []string{"CLIENT_ERROR bad data chunk\r\n"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := conn.Write([]byte(tc.req))
+			require.NoError(t, err)
+			for _, want := range tc.resp {
+				line, err := r.ReadString('\n')
+				require.NoError(t, err)
+				assert.Equal(t, want, line)
+			}
+		})
+	}
+}
@@ -0,0 +1,567 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resp
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cache "github.com/beego/beego-cache/v2"
+)
+
+var (
+	errSyntax        = Error("ERR syntax error")
+	errNotInteger    = Error("ERR value is not an integer or out of range")
+	errOverflow      = Error("ERR increment or decrement would overflow")
+	errInvalidExpire = Error("ERR invalid expire time")
+	errNoAuth        = Error("NOAUTH Authentication required.")
+)
+
+type command struct {
+	// arity follows the redis convention:
+	// positive means exact number of arguments, negative means at least -arity.
+	// The command name itself is counted.
+	arity   int
+	handler func(s *Server, args []string) any
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"ping":     {arity: -1, handler: (*Server).ping},
+		"echo":     {arity: 2, handler: func(_ *Server, args []string) any { return args[1] }},
+		"select":   {arity: 2, handler: (*Server).selectDB},
+		"get":      {arity: 2, handler: (*Server).get},
+		"set":      {arity: -3, handler: (*Server).set},
+		"setnx":    {arity: 3, handler: (*Server).setNX},
+		"setex":    {arity: 4, handler: (*Server).setEX},
+		"psetex":   {arity: 4, handler: (*Server).setEX},
+		"mget":     {arity: -2, handler: (*Server).mget},
+		"mset":     {arity: -3, handler: (*Server).mset},
+		"del":      {arity: -2, handler: (*Server).del},
+		"unlink":   {arity: -2, handler: (*Server).del},
+		"exists":   {arity: -2, handler: (*Server).exists},
+		"incr":     {arity: 2, handler: func(s *Server, args []string) any { return s.incrBy(args[1], 1) }},
+		"decr":     {arity: 2, handler: func(s *Server, args []string) any { return s.incrBy(args[1], -1) }},
+		"incrby":   {arity: 3, handler: (*Server).incrByCmd},
+		"decrby":   {arity: 3, handler: (*Server).incrByCmd},
+		"expire":   {arity: 3, handler: (*Server).expire},
+		"pexpire":  {arity: 3, handler: (*Server).expire},
+		"persist":  {arity: 2, handler: (*Server).persist},
+		"ttl":      {arity: 2, handler: (*Server).ttl},
+		"pttl":     {arity: 2, handler: (*Server).ttl},
+		"flushdb":  {arity: -1, handler: (*Server).flush},
+		"flushall": {arity: -1, handler: (*Server).flush},
+	}
+}
+
+// exec runs a command for c. It returns the reply, and whether the connection should be closed.
+func (s *Server) exec(c *Conn, args []string) (any, bool) {
+	switch strings.ToLower(args[0]) {
+	case "quit":
+		return Status("OK"), true
+	case "auth":
+		return s.auth(c, args), false
+	case "hello":
+		return s.hello(c, args), false
+	}
+	if !c.authed {
+		return errNoAuth, false
+	}
+	return s.invoker(c, args), false
+}
+
+// invoke runs a built-in command, it is the innermost Invoker.
+func (s *Server) invoke(_ *Conn, args []string) any {
+	name := strings.ToLower(args[0])
+	cmd, ok := commands[name]
+	if !ok {
+		return Error(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		return Error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+	}
+	return cmd.handler(s, args)
+}
+
+// auth handles AUTH [username] password. The only user is "default".
+func (s *Server) auth(c *Conn, args []string) any {
+	if len(args) < 2 || len(args) > 3 {
+		return Error("ERR wrong number of arguments for 'auth' command")
+	}
+	if s.password == "" {
+		return Error("ERR AUTH <password> called without any password configured for the default user. " +
+			"Are you sure your configuration is correct?")
+	}
+	password := args[len(args)-1]
+	if (len(args) == 3 && args[1] != "default") || !s.checkPassword(password) {
+		return Error("WRONGPASS invalid username-password pair or user is disabled.")
+	}
+	c.authed = true
+	return Status("OK")
+}
+
+// hello handles HELLO [protover [AUTH username password] [SETNAME clientname]],
+// which switches the protocol version of c.
+func (s *Server) hello(c *Conn, args []string) any {
+	proto := c.Protocol()
+	if len(args) > 1 {
+		v, err := strconv.Atoi(args[1])
+		if err != nil {
+			return Error("ERR Protocol version is not an integer or out of range")
+		}
+		if v != 2 && v != 3 {
+			return Error("NOPROTO unsupported protocol version")
+		}
+		proto = v
+	}
+	for i := 2; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "auth":
+			if i+2 >= len(args) {
+				return errSyntax
+			}
+			if res := s.auth(c, []string{"auth", args[i+1], args[i+2]}); res != Status("OK") {
+				return res
+			}
+			i += 2
+		case "setname":
+			if i+1 >= len(args) {
+				return errSyntax
+			}
+			i++
+		default:
+			return errSyntax
+		}
+	}
+	if !c.authed {
+		return Error("NOAUTH HELLO must be called with the client already authenticated, " +
+			"otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client " +
+			"and select the RESP protocol version at the same time")
+	}
+	c.wmu.Lock()
+	c.proto = proto
+	c.wmu.Unlock()
+	return Map{
+		"server", "redis",
+		"version", "7.0.0",
+		"proto", int64(proto),
+		"id", c.id,
+		"mode", "standalone",
+		"role", "master",
+		"modules", []any{},
+	}
+}
+
+func (s *Server) ping(args []string) any {
+	if len(args) > 1 {
+		return args[1]
+	}
+	return Status("PONG")
+}
+
+func (s *Server) selectDB(args []string) any {
+	if args[1] != "0" {
+		return Error("ERR DB index is out of range")
+	}
+	return Status("OK")
+}
+
+func (s *Server) get(args []string) any {
+	mu := s.lock(args[1])
+	defer mu.Unlock()
+	val, ok, err := s.lookup(args[1])
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	return val
+}
+
+// set handles SET key value [NX | XX] [GET] [EX seconds | PX milliseconds | KEEPTTL]
+func (s *Server) set(args []string) any {
+	key, val := args[1], args[2]
+	var (
+		deadline                 time.Time
+		nx, xx, get, keepTTL, ex bool
+	)
+	for i := 3; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "get":
+			get = true
+		case "keepttl":
+			keepTTL = true
+		case "ex", "px":
+			if i+1 >= len(args) || ex {
+				return errSyntax
+			}
+			var err error
+			if deadline, err = parseDeadline(args[i], args[i+1]); err != nil {
+				return err
+			}
+			ex = true
+			i++
+		default:
+			return errSyntax
+		}
+	}
+	if (nx && xx) || (keepTTL && ex) {
+		return errSyntax
+	}
+
+	mu := s.lock(key)
+	defer mu.Unlock()
+	var res any = Status("OK")
+	if nx || xx || get || keepTTL {
+		old, exist, err := s.lookup(key)
+		if err != nil {
+			return err
+		}
+		if get {
+			res = nil
+			if exist {
+				res = old
+			}
+		}
+		if (nx && exist) || (xx && !exist) {
+			if get {
+				return res
+			}
+			return nil
+		}
+		if keepTTL && exist {
+			deadline = s.deadline(key)
+		}
+	}
+	if err := s.save(key, val, deadline); err != nil {
+		return err
+	}
+	return res
+}
+
+func (s *Server) setNX(args []string) any {
+	mu := s.lock(args[1])
+	defer mu.Unlock()
+	_, exist, err := s.lookup(args[1])
+	if err != nil {
+		return err
+	}
+	if exist {
+		return false
+	}
+	if err = s.save(args[1], args[2], time.Time{}); err != nil {
+		return err
+	}
+	return true
+}
+
+// setEX handles SETEX key seconds value and PSETEX key milliseconds value
+func (s *Server) setEX(args []string) any {
+	unit := "ex"
+	if strings.EqualFold(args[0], "psetex") {
+		unit = "px"
+	}
+	deadline, err := parseDeadline(unit, args[2])
+	if err != nil {
+		return err
+	}
+	mu := s.lock(args[1])
+	defer mu.Unlock()
+	if err = s.save(args[1], args[3], deadline); err != nil {
+		return err
+	}
+	return Status("OK")
+}
+
+func (s *Server) mget(args []string) any {
+	res := make([]any, 0, len(args)-1)
+	for _, key := range args[1:] {
+		mu := s.lock(key)
+		val, ok, err := s.lookup(key)
+		mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if ok {
+			res = append(res, val)
+		} else {
+			res = append(res, nil)
+		}
+	}
+	return res
+}
+
+func (s *Server) mset(args []string) any {
+	if len(args)%2 != 1 {
+		return Error("ERR wrong number of arguments for 'mset' command")
+	}
+	for i := 1; i < len(args); i += 2 {
+		mu := s.lock(args[i])
+		err := s.save(args[i], args[i+1], time.Time{})
+		mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return Status("OK")
+}
+
+func (s *Server) del(args []string) any {
+	var n int64
+	for _, key := range args[1:] {
+		mu := s.lock(key)
+		_, exist, err := s.lookup(key)
+		if err == nil && exist {
+			err = s.remove(key)
+		}
+		mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if exist {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Server) exists(args []string) any {
+	var n int64
+	for _, key := range args[1:] {
+		ok, err := s.cache.IsExist(s.ctx, key)
+		if err != nil {
+			return err
+		}
+		if ok {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Server) incrByCmd(args []string) any {
+	delta, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return errNotInteger
+	}
+	if strings.EqualFold(args[0], "decrby") {
+		if delta == math.MinInt64 {
+			return errOverflow
+		}
+		delta = -delta
+	}
+	return s.incrBy(args[1], delta)
+}
+
+// incrBy keeps the time to live of key like redis does.
+// A missing key is treated as 0.
+func (s *Server) incrBy(key string, delta int64) any {
+	mu := s.lock(key)
+	defer mu.Unlock()
+	val, exist, err := s.lookup(key)
+	if err != nil {
+		return err
+	}
+	var n int64
+	if exist {
+		if n, err = strconv.ParseInt(val, 10, 64); err != nil {
+			return errNotInteger
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return errOverflow
+	}
+	n += delta
+	if err = s.save(key, strconv.FormatInt(n, 10), s.deadline(key)); err != nil {
+		return err
+	}
+	return n
+}
+
+// expire handles EXPIRE key seconds and PEXPIRE key milliseconds.
+// A non-positive timeout deletes the key.
+func (s *Server) expire(args []string) any {
+	n, perr := strconv.ParseInt(args[2], 10, 64)
+	if perr != nil {
+		return errNotInteger
+	}
+	unit := time.Millisecond
+	if strings.EqualFold(args[0], "expire") {
+		unit = time.Second
+	}
+	if n > math.MaxInt64/int64(unit) {
+		return errInvalidExpire
+	}
+	deadline := time.Now()
+	if n > 0 {
+		deadline = deadline.Add(time.Duration(n) * unit)
+	}
+
+	mu := s.lock(args[1])
+	defer mu.Unlock()
+	val, exist, err := s.lookup(args[1])
+	if err != nil {
+		return err
+	}
+	if !exist {
+		return false
+	}
+	if err = s.save(args[1], val, deadline); err != nil {
+		return err
+	}
+	return true
+}
+
+func (s *Server) persist(args []string) any {
+	mu := s.lock(args[1])
+	defer mu.Unlock()
+	val, exist, err := s.lookup(args[1])
+	if err != nil {
+		return err
+	}
+	if !exist || s.deadline(args[1]).IsZero() {
+		return false
+	}
+	if err = s.save(args[1], val, time.Time{}); err != nil {
+		return err
+	}
+	return true
+}
+
+// ttl handles TTL key and PTTL key.
+func (s *Server) ttl(args []string) any {
+	mu := s.lock(args[1])
+	defer mu.Unlock()
+	_, exist, err := s.lookup(args[1])
+	if err != nil {
+		return err
+	}
+	if !exist {
+		return int64(-2)
+	}
+	deadline := s.deadline(args[1])
+	if deadline.IsZero() {
+		return int64(-1)
+	}
+	if strings.EqualFold(args[0], "ttl") {
+		return int64(math.Round(time.Until(deadline).Seconds()))
+	}
+	return time.Until(deadline).Milliseconds()
+}
+
+func (s *Server) flush([]string) any {
+	s.deadlineMu.Lock()
+	s.deadlines = make(map[string]time.Time)
+	s.deadlineMu.Unlock()
+	if err := s.cache.ClearAll(s.ctx); err != nil {
+		return err
+	}
+	return Status("OK")
+}
+
+// lock locks and returns the mutex guarding key.
+func (s *Server) lock(key string) *sync.Mutex {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	mu := &s.locks[h.Sum32()%lockStripes]
+	mu.Lock()
+	return mu
+}
+
+// lookup returns the value of key as string.
+// The caller must hold the lock of key.
+func (s *Server) lookup(key string) (string, bool, error) {
+	val, err := s.cache.Get(s.ctx, key)
+	if err != nil || val == nil {
+		// adapters report missing key in different ways, so double check it
+		exist, er := s.cache.IsExist(s.ctx, key)
+		if er == nil && !exist {
+			s.setDeadline(key, time.Time{})
+			return "", false, nil
+		}
+		if err == nil {
+			err = er
+		}
+		return "", false, err
+	}
+	return cache.GetString(val), true, nil
+}
+
+// save puts val into cache, a zero deadline means never expire.
+// The caller must hold the lock of key.
+func (s *Server) save(key, val string, deadline time.Time) error {
+	var timeout time.Duration
+	if !deadline.IsZero() {
+		if timeout = time.Until(deadline); timeout <= 0 {
+			return s.remove(key)
+		}
+	}
+	if err := s.cache.Put(s.ctx, key, val, timeout); err != nil {
+		return err
+	}
+	s.setDeadline(key, deadline)
+	return nil
+}
+
+// remove deletes key from cache.
+// The caller must hold the lock of key.
+func (s *Server) remove(key string) error {
+	s.setDeadline(key, time.Time{})
+	return s.cache.Delete(s.ctx, key)
+}
+
+func (s *Server) deadline(key string) time.Time {
+	s.deadlineMu.Lock()
+	defer s.deadlineMu.Unlock()
+	return s.deadlines[key]
+}
+
+func (s *Server) setDeadline(key string, deadline time.Time) {
+	s.deadlineMu.Lock()
+	defer s.deadlineMu.Unlock()
+	if deadline.IsZero() {
+		delete(s.deadlines, key)
+		return
+	}
+	s.deadlines[key] = deadline
+}
+
+// parseDeadline parses the argument of EX or PX option.
+func parseDeadline(unit, arg string) (time.Time, error) {
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return time.Time{}, errNotInteger
+	}
+	d := time.Millisecond
+	if strings.EqualFold(unit, "ex") {
+		d = time.Second
+	}
+	if n <= 0 || n > math.MaxInt64/int64(d) {
+		return time.Time{}, errInvalidExpire
+	}
+	return time.Now().Add(time.Duration(n) * d), nil
+}
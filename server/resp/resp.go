@@ -0,0 +1,172 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxInlineSize    = 64 * 1024
+	maxMultiBulkSize = 1024 * 1024
+)
+
+// Status is a simple string reply, such as OK or PONG.
+type Status string
+
+// Error is an error reply. The message starts with an error prefix, for example ERR.
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Push is a RESP3 push reply, like the messages of pub/sub. It is encoded as an array for RESP2 clients.
+type Push []any
+
+// Map is a RESP3 map reply with the keys and values interleaved. It is encoded as an array for RESP2 clients.
+type Map []any
+
+// Replies are several replies to one command, like SUBSCRIBE replying once for every channel.
+type Replies []any
+
+// protocolError means the request is malformed and the connection must be closed.
+type protocolError string
+
+func (e protocolError) Error() string {
+	return string(e)
+}
+
+// readCommand reads one request from r.
+// Both multi bulk requests and inline commands are accepted.
+func readCommand(r *bufio.Reader, maxBulkSize int) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, nil
+	}
+	if line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > maxMultiBulkSize {
+		return nil, protocolError("ERR Protocol error: invalid multibulk length")
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err = readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, protocolError(fmt.Sprintf("ERR Protocol error: expected '$', got '%.1s'", line))
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxBulkSize {
+			return nil, protocolError("ERR Protocol error: invalid bulk length")
+		}
+		buf := make([]byte, size+2)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	var buf []byte
+	for {
+		line, err := r.ReadSlice('\n')
+		buf = append(buf, line...)
+		if len(buf) > maxInlineSize {
+			return "", protocolError("ERR Protocol error: too big inline request")
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(buf), "\r\n"), nil
+	}
+}
+
+// writeReply encodes val as a reply of the protocol version proto, either 2 or 3.
+func writeReply(w *bufio.Writer, val any, proto int) {
+	switch v := val.(type) {
+	case nil:
+		if proto > 2 {
+			_, _ = w.WriteString("_\r\n")
+		} else {
+			_, _ = w.WriteString("$-1\r\n")
+		}
+	case Status:
+		_, _ = fmt.Fprintf(w, "+%s\r\n", v)
+	case Error:
+		_, _ = fmt.Fprintf(w, "-%s\r\n", v)
+	case protocolError:
+		_, _ = fmt.Fprintf(w, "-%s\r\n", v)
+	case error:
+		// the message of an error reply must be a single line
+		msg := strings.NewReplacer("\r", " ", "\n", " ").Replace(v.Error())
+		_, _ = fmt.Fprintf(w, "-ERR %s\r\n", msg)
+	case int64:
+		_, _ = fmt.Fprintf(w, ":%d\r\n", v)
+	case bool:
+		if v {
+			_, _ = w.WriteString(":1\r\n")
+		} else {
+			_, _ = w.WriteString(":0\r\n")
+		}
+	case string:
+		_, _ = fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []any:
+		writeAggregate(w, '*', v, 1, proto)
+	case Push:
+		if proto > 2 {
+			writeAggregate(w, '>', v, 1, proto)
+		} else {
+			writeAggregate(w, '*', v, 1, proto)
+		}
+	case Map:
+		if proto > 2 {
+			writeAggregate(w, '%', v, 2, proto)
+		} else {
+			writeAggregate(w, '*', v, 1, proto)
+		}
+	case Replies:
+		for _, e := range v {
+			writeReply(w, e, proto)
+		}
+	default:
+		writeReply(w, fmt.Sprint(v), proto)
+	}
+}
+
+// writeAggregate writes the header of typ with len(elems)/per entries and the elements.
+func writeAggregate(w *bufio.Writer, typ byte, elems []any, per int, proto int) {
+	_, _ = fmt.Fprintf(w, "%c%d\r\n", typ, len(elems)/per)
+	for _, e := range elems {
+		writeReply(w, e, proto)
+	}
+}
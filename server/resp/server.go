@@ -0,0 +1,422 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package resp serves any cache.Cache over the redis protocol, RESP2 and RESP3,
+// so that several processes can share one local cache via standard redis clients.
+//
+// Supported commands: PING, ECHO, AUTH, HELLO, SELECT, QUIT, GET, SET, SETNX, SETEX, PSETEX,
+// MGET, MSET, DEL, UNLINK, EXISTS, INCR, DECR, INCRBY, DECRBY, EXPIRE, PEXPIRE,
+// PERSIST, TTL, PTTL, FLUSHDB and FLUSHALL. Only database 0 exists.
+// More commands can be added by ServerWithInterceptors.
+package resp
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cache "github.com/beego/beego-cache/v2"
+)
+
+// ErrServerClosed is returned by Serve after Shutdown or Close is called.
+var ErrServerClosed = errors.New("resp: server closed")
+
+const (
+	defaultMaxBulkSize = 64 * 1024 * 1024
+	lockStripes        = 256
+	sweepInterval      = time.Minute
+)
+
+type ServerOptions func(s *Server)
+
+// ServerWithPassword requires clients to AUTH with password before running other commands.
+func ServerWithPassword(password string) ServerOptions {
+	return func(s *Server) {
+		s.password = password
+	}
+}
+
+// ServerWithMaxConns limits the number of concurrent connections.
+// Zero means no limit, which is the default.
+func ServerWithMaxConns(n int) ServerOptions {
+	return func(s *Server) {
+		s.maxConns = n
+	}
+}
+
+// ServerWithIdleTimeout closes connections idle longer than timeout.
+// Zero means connections never time out, which is the default.
+func ServerWithIdleTimeout(timeout time.Duration) ServerOptions {
+	return func(s *Server) {
+		s.idleTimeout = timeout
+	}
+}
+
+// ServerWithMaxBulkSize limits the size of one argument in bytes, default 64MB.
+func ServerWithMaxBulkSize(size int) ServerOptions {
+	return func(s *Server) {
+		s.maxBulkSize = size
+	}
+}
+
+// ServerWithInterceptors wraps the execution of the commands with interceptors,
+// the first one is the outermost.
+func ServerWithInterceptors(interceptors ...Interceptor) ServerOptions {
+	return func(s *Server) {
+		s.interceptors = append(s.interceptors, interceptors...)
+	}
+}
+
+// Invoker executes a command for c, args[0] is the name of the command.
+type Invoker func(c *Conn, args []string) any
+
+// Interceptor wraps the execution of the commands, like cache.Interceptor wraps the operations of a cache.
+// It may handle the commands unknown to Server, change the arguments or the reply.
+// AUTH, HELLO and QUIT, and the commands of the connections not authenticated are not intercepted.
+//
+// The reply is nil, Status, Error, error, int64, bool, string, []any, Push, Map or Replies.
+type Interceptor func(c *Conn, args []string, next Invoker) any
+
+// Server serves a cache.Cache over the redis protocol.
+//
+// The time to live of keys put by Server is kept by Server,
+// because cache.Cache can not report it. Keys put by other users of the Cache
+// are served as well, and they are reported as never expire by TTL.
+type Server struct {
+	cache       cache.Cache
+	password    string
+	maxConns    int
+	idleTimeout time.Duration
+	maxBulkSize int
+	// invoker executes the commands through the interceptors
+	invoker      Invoker
+	interceptors []Interceptor
+	lastID       int64
+
+	// locks make the read-modify-write commands, like INCR and SETNX, atomic per key
+	locks      [lockStripes]sync.Mutex
+	deadlineMu sync.Mutex
+	deadlines  map[string]time.Time
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	sweepOnce sync.Once
+	sweepDone chan struct{}
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[*Conn]struct{}
+	closing   int32
+	// wg tracks the listeners and connections
+	wg sync.WaitGroup
+}
+
+// Conn is a client connection of Server.
+type Conn struct {
+	conn   net.Conn
+	id     int64
+	authed bool
+
+	// wmu guards the writer, which is shared by the connection and Send
+	wmu   sync.Mutex
+	w     *bufio.Writer
+	proto int
+}
+
+// ID returns the unique id of the connection, like CLIENT ID.
+func (c *Conn) ID() int64 {
+	return c.id
+}
+
+// Protocol returns the protocol version of the connection, 2 or 3 switched by HELLO.
+func (c *Conn) Protocol() int {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.proto
+}
+
+// Send writes the replies to the connection out of band, like the messages of pub/sub.
+// It is safe to call from any goroutine.
+func (c *Conn) Send(replies ...any) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	for _, reply := range replies {
+		writeReply(c.w, reply, c.proto)
+	}
+	return c.w.Flush()
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// reply writes the reply of a command, and flushes the writer if flush is true.
+func (c *Conn) reply(val any, flush bool) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	writeReply(c.w, val, c.proto)
+	if flush {
+		return c.w.Flush()
+	}
+	return nil
+}
+
+func (c *Conn) flush() error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.w.Flush()
+}
+
+// NewServer creates a Server serving c.
+func NewServer(c cache.Cache, opts ...ServerOptions) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		cache:       c,
+		maxBulkSize: defaultMaxBulkSize,
+		deadlines:   make(map[string]time.Time),
+		ctx:         ctx,
+		cancel:      cancel,
+		sweepDone:   make(chan struct{}),
+		listeners:   make(map[net.Listener]struct{}),
+		conns:       make(map[*Conn]struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.invoker = s.invoke
+	for i := len(s.interceptors) - 1; i >= 0; i-- {
+		interceptor, next := s.interceptors[i], s.invoker
+		s.invoker = func(c *Conn, args []string) any {
+			return interceptor(c, args, next)
+		}
+	}
+	return s
+}
+
+// ListenAndServe listens on the TCP network address addr and then calls Serve.
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts connections on ln and serves them until Shutdown or Close is called.
+// It always returns a non-nil error, ErrServerClosed after Shutdown or Close.
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.isClosing() {
+		s.mu.Unlock()
+		_ = ln.Close()
+		return ErrServerClosed
+	}
+	s.listeners[ln] = struct{}{}
+	s.wg.Add(1)
+	s.mu.Unlock()
+	defer s.wg.Done()
+
+	s.sweepOnce.Do(func() {
+		go s.sweep()
+	})
+
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			delete(s.listeners, ln)
+			s.mu.Unlock()
+			if s.isClosing() {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		c := &Conn{
+			conn:   nc,
+			id:     atomic.AddInt64(&s.lastID, 1),
+			authed: s.password == "",
+			w:      bufio.NewWriter(nc),
+			proto:  2,
+		}
+		s.mu.Lock()
+		if s.isClosing() {
+			s.mu.Unlock()
+			_ = nc.Close()
+			continue
+		}
+		if s.maxConns > 0 && len(s.conns) >= s.maxConns {
+			s.mu.Unlock()
+			_, _ = nc.Write([]byte("-ERR max number of clients reached\r\n"))
+			_ = nc.Close()
+			continue
+		}
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(c)
+	}
+}
+
+// Shutdown gracefully shuts down the server.
+// It stops accepting connections, lets every connection finish the commands it has sent,
+// and then closes it. If ctx is done before that, the remaining connections are closed
+// and the error of ctx is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&s.closing, 1)
+	err := s.closeListeners()
+
+	s.mu.Lock()
+	for c := range s.conns {
+		// wake up the connections waiting for new commands
+		_ = c.conn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.closeConns()
+		<-done
+		err = ctx.Err()
+	}
+	s.stopSweep()
+	return err
+}
+
+// Close immediately closes all listeners and connections.
+func (s *Server) Close() error {
+	atomic.StoreInt32(&s.closing, 1)
+	err := s.closeListeners()
+	s.closeConns()
+	s.wg.Wait()
+	s.stopSweep()
+	return err
+}
+
+func (s *Server) isClosing() bool {
+	return atomic.LoadInt32(&s.closing) == 1
+}
+
+// stopSweep cancels the context of the server,
+// it should be called after all the connections are closed.
+func (s *Server) stopSweep() {
+	s.cancel()
+	s.sweepOnce.Do(func() {
+		close(s.sweepDone)
+	})
+	<-s.sweepDone
+}
+
+func (s *Server) closeListeners() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	for ln := range s.listeners {
+		if er := ln.Close(); er != nil && err == nil {
+			err = er
+		}
+	}
+	return err
+}
+
+func (s *Server) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		_ = c.Close()
+	}
+}
+
+func (s *Server) serveConn(c *Conn) {
+	defer func() {
+		_ = c.Close()
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+
+	r := bufio.NewReader(c.conn)
+	for {
+		if s.isClosing() && r.Buffered() == 0 {
+			// all the commands sent before shutting down are handled
+			_ = c.flush()
+			return
+		}
+		if s.idleTimeout > 0 {
+			_ = c.conn.SetReadDeadline(time.Now().Add(s.idleTimeout))
+			if s.isClosing() {
+				// Shutdown is called after the check above
+				_ = c.conn.SetReadDeadline(time.Now())
+			}
+		}
+		args, err := readCommand(r, s.maxBulkSize)
+		if err != nil {
+			var perr protocolError
+			if errors.As(err, &perr) {
+				_ = c.reply(perr, false)
+			}
+			_ = c.flush()
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		res, quit := s.exec(c, args)
+		// flush once the pipelined commands are all handled
+		if err = c.reply(res, quit || r.Buffered() == 0); err != nil || quit {
+			return
+		}
+	}
+}
+
+// sweep drops the deadlines of the expired keys periodically,
+// because the keys may never be accessed via the Server again.
+func (s *Server) sweep() {
+	defer close(s.sweepDone)
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			s.deadlineMu.Lock()
+			for key, deadline := range s.deadlines {
+				if deadline.Before(now) {
+					delete(s.deadlines, key)
+				}
+			}
+			s.deadlineMu.Unlock()
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Server) checkPassword(password string) bool {
+	return subtle.ConstantTimeCompare([]byte(password), []byte(s.password)) == 1
+}
@@ -0,0 +1,339 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resp
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cache "github.com/beego/beego-cache/v2"
+	rediscache "github.com/beego/beego-cache/v2/redis"
+)
+
+func startServer(t *testing.T, c cache.Cache, opts ...ServerOptions) (*Server, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := NewServer(c, opts...)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve(ln)
+	}()
+	t.Cleanup(func() {
+		_ = s.Close()
+		assert.Equal(t, ErrServerClosed, <-done)
+	})
+	return s, ln.Addr().String()
+}
+
+func newClient(t *testing.T, addr string, password string) *redis.Client {
+	client := redis.NewClient(&redis.Options{Addr: addr, Password: password})
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	return client
+}
+
+func TestServer_String(t *testing.T) {
+	_, addr := startServer(t, cache.NewMemoryCache(0))
+	client := newClient(t, addr, "")
+	ctx := context.Background()
+
+	require.NoError(t, client.Ping(ctx).Err())
+	_, err := client.Get(ctx, "key").Result()
+	assert.Equal(t, redis.Nil, err)
+
+	require.NoError(t, client.Set(ctx, "key", "value", 0).Err())
+	val, err := client.Get(ctx, "key").Result()
+	require.NoError(t, err)
+	assert.Equal(t, "value", val)
+
+	ok, err := client.SetNX(ctx, "key", "other", 0).Result()
+	require.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = client.SetXX(ctx, "missing", "other", 0).Result()
+	require.NoError(t, err)
+	assert.False(t, ok)
+	old, err := client.SetArgs(ctx, "key", "new", redis.SetArgs{Get: true}).Result()
+	require.NoError(t, err)
+	assert.Equal(t, "value", old)
+
+	require.NoError(t, client.MSet(ctx, "k1", "v1", "k2", "v2").Err())
+	vals, err := client.MGet(ctx, "k1", "k2", "missing").Result()
+	require.NoError(t, err)
+	assert.Equal(t, []any{"v1", "v2", nil}, vals)
+
+	n, err := client.Exists(ctx, "k1", "k2", "missing").Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	n, err = client.Del(ctx, "k1", "missing").Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	require.NoError(t, client.FlushDB(ctx).Err())
+	n, err = client.Exists(ctx, "key", "k2").Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+
+	assert.Error(t, client.Do(ctx, "unknown").Err())
+	assert.Error(t, client.Do(ctx, "get").Err())
+}
+
+func TestServer_Counter(t *testing.T) {
+	bm := cache.NewMemoryCache(0)
+	_, addr := startServer(t, bm)
+	client := newClient(t, addr, "")
+	ctx := context.Background()
+
+	n, err := client.Incr(ctx, "counter").Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	n, err = client.DecrBy(ctx, "counter", 5).Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(-4), n)
+
+	// values put by Go side
+	require.NoError(t, bm.Put(ctx, "int", 10, 0))
+	n, err = client.Incr(ctx, "int").Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), n)
+
+	require.NoError(t, client.Set(ctx, "str", "abc", 0).Err())
+	assert.Error(t, client.Incr(ctx, "str").Err())
+}
+
+func TestServer_Expire(t *testing.T) {
+	_, addr := startServer(t, cache.NewMemoryCache(0))
+	client := newClient(t, addr, "")
+	ctx := context.Background()
+
+	require.NoError(t, client.Set(ctx, "key", "1", time.Minute).Err())
+	ttl, err := client.TTL(ctx, "key").Result()
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, ttl)
+
+	// INCR keeps the ttl, SET resets it
+	require.NoError(t, client.Incr(ctx, "key").Err())
+	ttl, err = client.PTTL(ctx, "key").Result()
+	require.NoError(t, err)
+	assert.True(t, ttl > 50*time.Second)
+	require.NoError(t, client.Set(ctx, "key", "1", 0).Err())
+	ttl, err = client.TTL(ctx, "key").Result()
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(-1), ttl)
+
+	ok, err := client.PExpire(ctx, "key", 50*time.Millisecond).Result()
+	require.NoError(t, err)
+	assert.True(t, ok)
+	time.Sleep(100 * time.Millisecond)
+	_, err = client.Get(ctx, "key").Result()
+	assert.Equal(t, redis.Nil, err)
+	ttl, err = client.TTL(ctx, "key").Result()
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(-2), ttl)
+
+	require.NoError(t, client.SetEx(ctx, "key", "1", time.Minute).Err())
+	ok, err = client.Persist(ctx, "key").Result()
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = client.Expire(ctx, "missing", time.Minute).Result()
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// a negative timeout deletes the key, even a huge one
+	require.NoError(t, client.Set(ctx, "key", "1", 0).Err())
+	ok, err = client.Do(ctx, "expire", "key", "-9223372036854775807").Bool()
+	require.NoError(t, err)
+	assert.True(t, ok)
+	n, err := client.Exists(ctx, "key").Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+}
+
+func TestServer_Auth(t *testing.T) {
+	_, addr := startServer(t, cache.NewMemoryCache(0), ServerWithPassword("secret"))
+	ctx := context.Background()
+
+	client := newClient(t, addr, "")
+	assert.ErrorContains(t, client.Get(ctx, "key").Err(), "NOAUTH")
+
+	client = newClient(t, addr, "wrong")
+	assert.ErrorContains(t, client.Ping(ctx).Err(), "WRONGPASS")
+
+	client = newClient(t, addr, "secret")
+	require.NoError(t, client.Set(ctx, "key", "value", 0).Err())
+}
+
+func TestServer_Hello(t *testing.T) {
+	_, addr := startServer(t, cache.NewMemoryCache(0), ServerWithPassword("secret"))
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	send := func(cmd string) string {
+		_, err := conn.Write([]byte(cmd + "\r\n"))
+		require.NoError(t, err)
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		return line
+	}
+
+	assert.Contains(t, send("HELLO 3"), "-NOAUTH")
+	assert.Contains(t, send("HELLO 3 AUTH default wrong"), "-WRONGPASS")
+	assert.Equal(t, "%7\r\n", send("HELLO 3 AUTH default secret\r\nPING"))
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		if line == "+PONG\r\n" {
+			break
+		}
+	}
+	// RESP3 null
+	assert.Equal(t, "_\r\n", send("GET missing"))
+	assert.Equal(t, "-NOPROTO unsupported protocol version\r\n", send("HELLO 4"))
+}
+
+func TestServer_Interceptors(t *testing.T) {
+	var order []string
+	logging := func(c *Conn, args []string, next Invoker) any {
+		order = append(order, args[0])
+		return next(c, args)
+	}
+	double := func(c *Conn, args []string, next Invoker) any {
+		if strings.EqualFold(args[0], "double") {
+			n, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				return Error("ERR value is not an integer or out of range")
+			}
+			return Replies{n * 2, Status("OK")}
+		}
+		return next(c, args)
+	}
+	_, addr := startServer(t, cache.NewMemoryCache(0), ServerWithInterceptors(logging, double))
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	_, err = conn.Write([]byte("DOUBLE 21\r\nPING\r\n"))
+	require.NoError(t, err)
+	for _, want := range []string{":42\r\n", "+OK\r\n", "+PONG\r\n"} {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, want, line)
+	}
+	assert.Equal(t, []string{"DOUBLE", "PING"}, order)
+}
+
+func TestServer_MaxConns(t *testing.T) {
+	_, addr := startServer(t, cache.NewMemoryCache(0), ServerWithMaxConns(1))
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("PING\r\n"))
+	require.NoError(t, err)
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "+PONG\r\n", line)
+
+	conn2, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn2.Close()
+	line, err = bufio.NewReader(conn2).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "-ERR max number of clients reached\r\n", line)
+}
+
+func TestServer_Pipeline(t *testing.T) {
+	_, addr := startServer(t, cache.NewMemoryCache(0))
+	client := newClient(t, addr, "")
+	ctx := context.Background()
+
+	cmds, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i := 0; i < 100; i++ {
+			pipe.Incr(ctx, "counter")
+		}
+		pipe.Get(ctx, "counter")
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, cmds, 101)
+	assert.Equal(t, "100", cmds[100].(*redis.StringCmd).Val())
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := NewServer(cache.NewMemoryCache(0))
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve(ln)
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	_, err = conn.Write([]byte("SET key value\r\n"))
+	require.NoError(t, err)
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "+OK\r\n", line)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, s.Shutdown(ctx))
+	assert.Equal(t, ErrServerClosed, <-done)
+
+	// the idle connection is closed by server
+	_, err = r.ReadString('\n')
+	assert.Error(t, err)
+	_, err = net.Dial("tcp", ln.Addr().String())
+	assert.Error(t, err)
+	assert.Equal(t, ErrServerClosed, s.Serve(ln))
+}
+
+func TestServer_RedisAdapter(t *testing.T) {
+	_, addr := startServer(t, cache.NewMemoryCache(0))
+	client := newClient(t, addr, "")
+	c := rediscache.NewRedisCache(client)
+	ctx := context.Background()
+
+	require.NoError(t, c.Put(ctx, "key", "value", time.Minute))
+	val, err := c.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", val)
+
+	require.NoError(t, c.Put(ctx, "counter", 1, time.Minute))
+	require.NoError(t, c.Incr(ctx, "counter"))
+	vals, err := c.GetMulti(ctx, []string{"key", "counter"})
+	require.NoError(t, err)
+	assert.Equal(t, []any{"value", "2"}, vals)
+
+	require.NoError(t, c.Delete(ctx, "key"))
+	ok, err := c.IsExist(ctx, "key")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
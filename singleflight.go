@@ -16,6 +16,7 @@ package cache
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"golang.org/x/sync/singleflight"
@@ -23,42 +24,187 @@ import (
 	berror "github.com/beego/beego-error/v2"
 )
 
+// SingleflightCacheOption configures the cache created by NewSingleflightCache
+type SingleflightCacheOption func(s *SingleflightCache)
+
+// WithSingleflightCacheNegativeTTL configures how long a tombstone is cached
+// when loadFunc returns ErrNotFound, during which Get returns ErrKeyNotExist without calling loadFunc.
+// By default no tombstone is cached.
+func WithSingleflightCacheNegativeTTL(ttl time.Duration) SingleflightCacheOption {
+	return func(s *SingleflightCache) {
+		s.negativeTTL = ttl
+	}
+}
+
+// WithSingleflightCacheDetachedLoad detaches loadFunc from the callers.
+// By default loadFunc uses the context of the first caller, so that all the callers fail if it is canceled.
+// In detached mode, loadFunc uses a context which keeps the values of the first caller but is never canceled,
+// and is limited by timeout if it is positive.
+// Each caller stops waiting when its own context is done, while the loading goes on for the others.
+func WithSingleflightCacheDetachedLoad(timeout time.Duration) SingleflightCacheOption {
+	return func(s *SingleflightCache) {
+		s.detached = true
+		s.loadTimeout = timeout
+	}
+}
+
+// WithSingleflightCacheForgetOnError forgets a failed loading for the callers waiting for it,
+// which load again instead of sharing the error. They load once more at most, merged together.
+// The caller which started the failed loading gets the error.
+func WithSingleflightCacheForgetOnError() SingleflightCacheOption {
+	return func(s *SingleflightCache) {
+		s.forgetOnError = true
+	}
+}
+
 // SingleflightCache
 // This is a very simple decorator mode
 type SingleflightCache struct {
 	Cache
-	group      *singleflight.Group
-	expiration time.Duration
-	loadFunc   func(ctx context.Context, key string) (any, error)
+	group         *singleflight.Group
+	expiration    time.Duration
+	negativeTTL   time.Duration
+	detached      bool
+	loadTimeout   time.Duration
+	forgetOnError bool
+	loadFunc      func(ctx context.Context, key string) (any, error)
 }
 
 // NewSingleflightCache create SingleflightCache
 func NewSingleflightCache(c Cache, expiration time.Duration,
-	loadFunc func(ctx context.Context, key string) (any, error),
+	loadFunc func(ctx context.Context, key string) (any, error), opts ...SingleflightCacheOption,
 ) (Cache, error) {
 	if loadFunc == nil {
 		return nil, berror.Error(InvalidLoadFunc, "loadFunc cannot be nil")
 	}
-	return &SingleflightCache{
+	res := &SingleflightCache{
 		Cache:      c,
 		group:      &singleflight.Group{},
 		expiration: expiration,
 		loadFunc:   loadFunc,
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res, nil
 }
 
 // Get In the Get method, single flight is used to load data and write back the cache.
+// ErrKeyNotExist is returned if loadFunc returns ErrNotFound.
 func (s *SingleflightCache) Get(ctx context.Context, key string) (any, error) {
 	val, err := s.Cache.Get(ctx, key)
-	if val == nil || err != nil {
-		val, err, _ = s.group.Do(key, func() (interface{}, error) {
-			v, er := s.loadFunc(ctx, key)
-			if er != nil {
-				return nil, berror.Wrap(er, LoadFuncFailed, "cache unable to load data")
-			}
-			er = s.Cache.Put(ctx, key, v, s.expiration)
-			return v, er
+	if err == nil && isTombstone(val) {
+		return nil, ErrKeyNotExist
+	}
+	if val != nil && err == nil {
+		return val, nil
+	}
+	if !s.detached {
+		return s.do(ctx, key, func() (any, error) {
+			return s.load(ctx, key)
 		})
 	}
-	return val, err
+	return s.doChan(ctx, key, func() (any, error) {
+		loadCtx := context.Context(detachedContext{Context: ctx})
+		if s.loadTimeout > 0 {
+			var cancel context.CancelFunc
+			loadCtx, cancel = context.WithTimeout(loadCtx, s.loadTimeout)
+			defer cancel()
+		}
+		return s.load(loadCtx, key)
+	})
+}
+
+// do calls fn once for the concurrent callers of key.
+// With forgetOnError, the callers which waited for a failed call don't share the error,
+// they call fn again once, merged together.
+func (s *SingleflightCache) do(ctx context.Context, key string, fn func() (any, error)) (any, error) {
+	for retried := false; ; retried = true {
+		leader := false
+		val, err, _ := s.group.Do(key, func() (any, error) {
+			leader = true
+			return fn()
+		})
+		if !s.reload(err, leader, retried) {
+			return val, err
+		}
+		fn = s.reloader(ctx, key, fn)
+	}
+}
+
+// doChan is do in detached mode, every caller stops waiting when its ctx is done.
+func (s *SingleflightCache) doChan(ctx context.Context, key string, fn func() (any, error)) (any, error) {
+	for retried := false; ; retried = true {
+		leader := false
+		ch := s.group.DoChan(key, func() (any, error) {
+			leader = true
+			return fn()
+		})
+		select {
+		case res := <-ch:
+			if !s.reload(res.Err, leader, retried) {
+				return res.Val, res.Err
+			}
+			fn = s.reloader(ctx, key, fn)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// reloader returns fn skipped if another waiter has loaded key since
+func (s *SingleflightCache) reloader(ctx context.Context, key string, fn func() (any, error)) func() (any, error) {
+	return func() (any, error) {
+		if val, err := s.Cache.Get(ctx, key); err == nil && val != nil && !isTombstone(val) {
+			return val, nil
+		}
+		return fn()
+	}
+}
+
+// reload reports whether a caller which waited for a loading failed with err loads again.
+// A missing record is not a failure.
+func (s *SingleflightCache) reload(err error, leader, retried bool) bool {
+	return s.forgetOnError && err != nil && !leader && !retried && !errors.Is(err, ErrKeyNotExist)
+}
+
+// GetMulti gets the values of keys, the tombstones are misses.
+func (s *SingleflightCache) GetMulti(ctx context.Context, keys []string) ([]any, error) {
+	return getMultiSkipTombstones(ctx, s.Cache, keys)
+}
+
+// IsExist reports whether key exists, a tombstone doesn't.
+func (s *SingleflightCache) IsExist(ctx context.Context, key string) (bool, error) {
+	return isExistSkipTombstone(ctx, s.Cache, key)
+}
+
+// load loads key and writes it back, it is called once for the concurrent callers.
+func (s *SingleflightCache) load(ctx context.Context, key string) (any, error) {
+	v, err := s.loadFunc(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return nil, putTombstone(ctx, s.Cache, key, s.negativeTTL)
+	}
+	if err != nil {
+		err = berror.Wrap(err, LoadFuncFailed, "cache unable to load data")
+	} else {
+		err = s.Cache.Put(ctx, key, v, s.expiration)
+	}
+	return v, err
+}
+
+// detachedContext keeps the values of the parent context, but is never canceled.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
 }
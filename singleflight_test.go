@@ -16,11 +16,16 @@ package cache
 
 import (
 	"context"
+	"errors"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	berror "github.com/beego/beego-error/v2"
 )
 
 func TestSingleflight_Memory_Get(t *testing.T) {
@@ -68,3 +73,128 @@ func testSingleflightCacheConcurrencyGet(t *testing.T, bm Cache) {
 	}
 	wg.Wait()
 }
+
+type singleflightCtxKey struct{}
+
+func TestSingleflightCache_DetachedLoad(t *testing.T) {
+	var loads int32
+	started, release := make(chan struct{}), make(chan struct{})
+	c, err := NewSingleflightCache(NewMemoryCache(0), time.Minute,
+		func(ctx context.Context, key string) (any, error) {
+			atomic.AddInt32(&loads, 1)
+			close(started)
+			<-release
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return ctx.Value(singleflightCtxKey{}), nil
+		}, WithSingleflightCacheDetachedLoad(time.Second))
+	require.NoError(t, err)
+
+	// the first caller gives up, but the loading goes on for the second one
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), singleflightCtxKey{}, "value"))
+	first := make(chan error, 1)
+	go func() {
+		_, err := c.Get(ctx, "key")
+		first <- err
+	}()
+	<-started
+	second := make(chan any, 1)
+	go func() {
+		val, err := c.Get(context.Background(), "key")
+		assert.NoError(t, err)
+		second <- val
+	}()
+	cancel()
+	assert.Equal(t, context.Canceled, <-first)
+	close(release)
+	assert.Equal(t, "value", <-second)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+
+	val, err := c.Get(context.Background(), "key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", val)
+}
+
+func TestSingleflightCache_DetachedLoadTimeout(t *testing.T) {
+	c, err := NewSingleflightCache(NewMemoryCache(0), time.Minute,
+		func(ctx context.Context, key string) (any, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}, WithSingleflightCacheDetachedLoad(20*time.Millisecond))
+	require.NoError(t, err)
+
+	_, err = c.Get(context.Background(), "key")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	code, _ := berror.FromError(err)
+	assert.Equal(t, LoadFuncFailed.Code(), code.Code())
+}
+
+func TestSingleflightCache_ForgetOnError(t *testing.T) {
+	testCases := []struct {
+		name    string
+		opts    []SingleflightCacheOption
+		reload  bool
+		wantErr bool
+	}{
+		{name: "shared error", wantErr: true},
+		{name: "forget", opts: []SingleflightCacheOption{WithSingleflightCacheForgetOnError()}, reload: true},
+		{
+			name: "forget detached",
+			opts: []SingleflightCacheOption{
+				WithSingleflightCacheForgetOnError(), WithSingleflightCacheDetachedLoad(time.Second),
+			},
+			reload: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var loads int32
+			started := make(chan struct{})
+			release := make(chan struct{})
+			c, err := NewSingleflightCache(NewMemoryCache(0), time.Minute,
+				func(ctx context.Context, key string) (any, error) {
+					if atomic.AddInt32(&loads, 1) == 1 {
+						close(started)
+						<-release
+						return nil, errors.New("load failed")
+					}
+					return "value", nil
+				}, tc.opts...)
+			require.NoError(t, err)
+			ctx := context.Background()
+
+			// the first loading fails after the others wait for it
+			leaderErr := make(chan error, 1)
+			go func() {
+				_, err := c.Get(ctx, "key")
+				leaderErr <- err
+			}()
+			<-started
+			var wg sync.WaitGroup
+			for i := 0; i < 5; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					val, err := c.Get(ctx, "key")
+					if tc.wantErr {
+						assert.Error(t, err)
+						return
+					}
+					assert.NoError(t, err)
+					assert.Equal(t, "value", val)
+				}()
+			}
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			wg.Wait()
+			assert.Error(t, <-leaderErr)
+			if tc.reload {
+				// the waiters load again once, merged together
+				assert.Equal(t, int32(2), atomic.LoadInt32(&loads))
+			} else {
+				assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+			}
+		})
+	}
+}
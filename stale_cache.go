@@ -0,0 +1,238 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	berror "github.com/beego/beego-error/v2"
+)
+
+// StaleCacheOption configures the StaleCache
+type StaleCacheOption func(c *StaleCache)
+
+// WithStaleCacheGracePeriod configures how long a value is kept after its TTL, default the TTL itself.
+func WithStaleCacheGracePeriod(grace time.Duration) StaleCacheOption {
+	return func(c *StaleCache) {
+		c.grace = grace
+	}
+}
+
+// WithStaleCacheWhileRevalidate configures whether a stale value is returned at once
+// while it is loaded in background, default true.
+// If disabled, the stale value is loaded before returning.
+func WithStaleCacheWhileRevalidate(enabled bool) StaleCacheOption {
+	return func(c *StaleCache) {
+		c.whileRevalidate = enabled
+	}
+}
+
+// WithStaleCacheIfError configures whether a stale value is returned when loading fails, default true.
+func WithStaleCacheIfError(enabled bool) StaleCacheOption {
+	return func(c *StaleCache) {
+		c.ifError = enabled
+	}
+}
+
+// WithStaleCacheRevalidateConcurrency configures the max number of the background loading, default 16.
+// A stale value is returned without revalidating when the limit is reached, it is tried again on the next hit.
+func WithStaleCacheRevalidateConcurrency(n int) StaleCacheOption {
+	return func(c *StaleCache) {
+		c.revalidateConcurrency = n
+	}
+}
+
+// WithStaleCacheErrorHandler configures the function to handle the errors of the background loading,
+// by default errors are ignored.
+func WithStaleCacheErrorHandler(fn func(key string, err error)) StaleCacheOption {
+	return func(c *StaleCache) {
+		c.onError = fn
+	}
+}
+
+// StaleCache is a read through decorator which keeps the values for a grace period after their TTL.
+// Within the grace period, the stale values are returned while they are loaded in background,
+// and are returned when loadFunc fails, so that a short outage of the upstream is not noticed.
+//
+// The TTL is only known for the values put by this cache, the values put by others are always fresh.
+type StaleCache struct {
+	Cache
+	expiration      time.Duration
+	grace           time.Duration
+	whileRevalidate bool
+	ifError         bool
+	onError         func(key string, err error)
+	loadFunc        func(ctx context.Context, key string) (any, error)
+	group           singleflight.Group
+
+	revalidateConcurrency int
+	// tokens of the background loading
+	revalidateSem chan struct{}
+	// the time the values put by this cache become stale, kept for the grace period
+	deadlines *ttlTracker
+
+	mu           sync.Mutex
+	revalidating map[string]struct{}
+}
+
+// NewStaleCache creates StaleCache, expiration is the TTL of the loaded values
+func NewStaleCache(c Cache, expiration time.Duration,
+	loadFunc func(ctx context.Context, key string) (any, error), opts ...StaleCacheOption,
+) (*StaleCache, error) {
+	if loadFunc == nil {
+		return nil, berror.Error(InvalidLoadFunc, "loadFunc cannot be nil")
+	}
+	res := &StaleCache{
+		Cache:           c,
+		expiration:      expiration,
+		grace:           expiration,
+		whileRevalidate: true,
+		ifError:         true,
+		onError:         func(string, error) {},
+		loadFunc:        loadFunc,
+		revalidating:    make(map[string]struct{}),
+
+		revalidateConcurrency: defaultRefreshConcurrency,
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	if res.grace < 0 {
+		return nil, berror.Errorf(InvalidInitParameters, "grace period should not be negative, but got %v", res.grace)
+	}
+	if res.revalidateConcurrency <= 0 {
+		return nil, berror.Errorf(InvalidInitParameters,
+			"revalidate concurrency should be positive, but got %d", res.revalidateConcurrency)
+	}
+	res.revalidateSem = make(chan struct{}, res.revalidateConcurrency)
+	res.deadlines = newTTLTracker(res.grace)
+	return res, nil
+}
+
+// Get returns the value of key, which may be stale.
+// Use GetWithStaleness to know whether it is stale.
+func (c *StaleCache) Get(ctx context.Context, key string) (any, error) {
+	val, _, err := c.GetWithStaleness(ctx, key)
+	return val, err
+}
+
+// GetWithStaleness returns the value of key and how long it has been stale, zero means it is fresh.
+// The value is loaded if it is missing, or stale and the stale value can not be returned.
+func (c *StaleCache) GetWithStaleness(ctx context.Context, key string) (any, time.Duration, error) {
+	val, err := c.Cache.Get(ctx, key)
+	if val == nil || err != nil {
+		val, err = c.load(ctx, key)
+		return val, 0, err
+	}
+
+	staleness := c.staleness(key)
+	if staleness == 0 {
+		return val, 0, nil
+	}
+	if c.whileRevalidate {
+		c.revalidate(key)
+		return val, staleness, nil
+	}
+	loaded, err := c.load(ctx, key)
+	if err != nil {
+		if c.ifError {
+			return val, staleness, nil
+		}
+		return nil, 0, err
+	}
+	return loaded, 0, nil
+}
+
+// Put puts the value, which is kept for the grace period after timeout.
+func (c *StaleCache) Put(ctx context.Context, key string, val any, timeout time.Duration) error {
+	physical := timeout
+	if timeout > 0 {
+		physical += c.grace
+	}
+	if err := c.Cache.Put(ctx, key, val, physical); err != nil {
+		return err
+	}
+	c.deadlines.set(key, timeout)
+	return nil
+}
+
+// Delete deletes the value
+func (c *StaleCache) Delete(ctx context.Context, key string) error {
+	c.deadlines.delete(key)
+	return c.Cache.Delete(ctx, key)
+}
+
+// ClearAll clears all the values
+func (c *StaleCache) ClearAll(ctx context.Context) error {
+	c.deadlines.clear()
+	return c.Cache.ClearAll(ctx)
+}
+
+// staleness returns how long the value of key has been stale.
+func (c *StaleCache) staleness(key string) time.Duration {
+	// the entry is dropped after the grace period, the value is put by others if it is still there
+	entry, ok := c.deadlines.get(key)
+	if !ok {
+		return 0
+	}
+	if staleness := time.Since(entry.deadline); staleness > 0 {
+		return staleness
+	}
+	return 0
+}
+
+// load loads key and puts it, the concurrent loadings of a key are merged.
+func (c *StaleCache) load(ctx context.Context, key string) (any, error) {
+	val, err, _ := c.group.Do(key, func() (any, error) {
+		v, er := c.loadFunc(ctx, key)
+		if er != nil {
+			return nil, berror.Wrap(er, LoadFuncFailed, "cache unable to load data")
+		}
+		return v, c.Put(ctx, key, v, c.expiration)
+	})
+	return val, err
+}
+
+// revalidate loads key in background, at most one background loading runs for a key,
+// and at most revalidateConcurrency run in total.
+// The caller's context is not used because the loading outlives the call.
+func (c *StaleCache) revalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.revalidating[key]; ok {
+		return
+	}
+	select {
+	case c.revalidateSem <- struct{}{}:
+	default:
+		return
+	}
+	c.revalidating[key] = struct{}{}
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			delete(c.revalidating, key)
+			c.mu.Unlock()
+			<-c.revalidateSem
+		}()
+		if _, err := c.load(context.Background(), key); err != nil {
+			c.onError(key, err)
+		}
+	}()
+}
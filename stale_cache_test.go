@@ -0,0 +1,189 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	berror "github.com/beego/beego-error/v2"
+)
+
+// newCountingLoader returns a loadFunc returning value1, value2 ... and failing when failed is set.
+func newCountingLoader(failed *int32) func(ctx context.Context, key string) (any, error) {
+	var n int32
+	return func(ctx context.Context, key string) (any, error) {
+		if atomic.LoadInt32(failed) == 1 {
+			return nil, errors.New("upstream is down")
+		}
+		return fmt.Sprintf("value%d", atomic.AddInt32(&n, 1)), nil
+	}
+}
+
+func TestStaleCache_WhileRevalidate(t *testing.T) {
+	var failed int32
+	var bgErr atomic.Value
+	c, err := NewStaleCache(NewMemoryCache(0), 50*time.Millisecond, newCountingLoader(&failed),
+		WithStaleCacheGracePeriod(time.Second),
+		WithStaleCacheErrorHandler(func(key string, err error) { bgErr.Store(err) }))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	val, staleness, err := c.GetWithStaleness(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "value1", val)
+	assert.Equal(t, time.Duration(0), staleness)
+
+	time.Sleep(70 * time.Millisecond)
+	val, staleness, err = c.GetWithStaleness(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "value1", val)
+	assert.True(t, staleness > 0)
+	assert.Eventually(t, func() bool {
+		val, staleness, err := c.GetWithStaleness(ctx, "key")
+		return err == nil && val == "value2" && staleness == 0
+	}, time.Second, 10*time.Millisecond)
+
+	// the stale value is kept when the background loading fails
+	atomic.StoreInt32(&failed, 1)
+	time.Sleep(70 * time.Millisecond)
+	val, err = c.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "value2", val)
+	assert.Eventually(t, func() bool {
+		return bgErr.Load() != nil
+	}, time.Second, 10*time.Millisecond)
+	val, staleness, err = c.GetWithStaleness(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "value2", val)
+	assert.True(t, staleness > 0)
+}
+
+func TestStaleCache_IfError(t *testing.T) {
+	var failed int32
+	c, err := NewStaleCache(NewMemoryCache(0), 50*time.Millisecond, newCountingLoader(&failed),
+		WithStaleCacheGracePeriod(100*time.Millisecond), WithStaleCacheWhileRevalidate(false))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = c.Get(ctx, "key")
+	require.NoError(t, err)
+	// loaded before returning
+	time.Sleep(70 * time.Millisecond)
+	val, staleness, err := c.GetWithStaleness(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "value2", val)
+	assert.Equal(t, time.Duration(0), staleness)
+
+	atomic.StoreInt32(&failed, 1)
+	time.Sleep(70 * time.Millisecond)
+	val, staleness, err = c.GetWithStaleness(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "value2", val)
+	assert.True(t, staleness > 0)
+
+	// out of the grace period
+	time.Sleep(100 * time.Millisecond)
+	_, err = c.Get(ctx, "key")
+	code, ok := berror.FromError(err)
+	require.True(t, ok)
+	assert.Equal(t, LoadFuncFailed.Code(), code.Code())
+}
+
+func TestStaleCache_IfErrorDisabled(t *testing.T) {
+	var failed int32
+	c, err := NewStaleCache(NewMemoryCache(0), 50*time.Millisecond, newCountingLoader(&failed),
+		WithStaleCacheWhileRevalidate(false), WithStaleCacheIfError(false))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = c.Get(ctx, "key")
+	require.NoError(t, err)
+	atomic.StoreInt32(&failed, 1)
+	time.Sleep(70 * time.Millisecond)
+	_, err = c.Get(ctx, "key")
+	assert.Error(t, err)
+}
+
+func TestStaleCache_RevalidateConcurrency(t *testing.T) {
+	var loads int32
+	release := make(chan struct{})
+	c, err := NewStaleCache(NewMemoryCache(0), 20*time.Millisecond, func(ctx context.Context, key string) (any, error) {
+		if atomic.AddInt32(&loads, 1) > 2 {
+			<-release
+		}
+		return "value", nil
+	}, WithStaleCacheGracePeriod(time.Minute), WithStaleCacheRevalidateConcurrency(1))
+	require.NoError(t, err)
+	ctx := context.Background()
+	for _, key := range []string{"key1", "key2"} {
+		_, err = c.Get(ctx, key)
+		require.NoError(t, err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		for _, key := range []string{"key1", "key2"} {
+			val, staleness, err := c.GetWithStaleness(ctx, key)
+			require.NoError(t, err)
+			assert.Equal(t, "value", val)
+			assert.True(t, staleness > 0)
+		}
+	}
+	// one loading for key1, and key2 is skipped by the concurrency limit
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&loads) == 3
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&loads))
+	close(release)
+}
+
+func TestStaleCache_DeadlinesSwept(t *testing.T) {
+	c, err := NewStaleCache(NewMemoryCache(0), time.Millisecond, func(ctx context.Context, key string) (any, error) {
+		return "value", nil
+	}, WithStaleCacheGracePeriod(time.Millisecond))
+	require.NoError(t, err)
+	c.deadlines.sweepInterval = 0
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		_, err = c.Get(ctx, fmt.Sprintf("key%d", i))
+		require.NoError(t, err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	c.deadlines.nextSweep = time.Now()
+	require.NoError(t, c.Put(ctx, "key", "value", time.Minute))
+	assert.Equal(t, 1, c.deadlines.len())
+}
+
+func TestNewStaleCache(t *testing.T) {
+	_, err := NewStaleCache(NewMemoryCache(0), time.Minute, nil)
+	assert.Error(t, err)
+	_, err = NewStaleCache(NewMemoryCache(0), time.Minute, func(ctx context.Context, key string) (any, error) {
+		return "value", nil
+	}, WithStaleCacheGracePeriod(-time.Second))
+	assert.Error(t, err)
+	_, err = NewStaleCache(NewMemoryCache(0), time.Minute, func(ctx context.Context, key string) (any, error) {
+		return "value", nil
+	}, WithStaleCacheRevalidateConcurrency(0))
+	assert.Error(t, err)
+}
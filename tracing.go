@@ -0,0 +1,91 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+)
+
+// The attributes of the spans created by the tracing hook
+const (
+	AttrOperation = "cache.operation"
+	AttrBackend   = "cache.backend"
+	AttrKeyCount  = "cache.key_count"
+	AttrHit       = "cache.hit"
+	AttrHits      = "cache.hits"
+	AttrMisses    = "cache.misses"
+)
+
+// Attribute is a key value pair of a span, like attribute.KeyValue of OpenTelemetry.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// Tracer creates spans, it has the shape of trace.Tracer of OpenTelemetry,
+// so that an adapter of a few lines connects it to OpenTelemetry or the others.
+type Tracer interface {
+	// Start creates a span and a context containing it, like trace.Tracer.Start
+	Start(ctx context.Context, spanName string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is a part of the shape of trace.Span of OpenTelemetry.
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	RecordError(err error)
+	End()
+}
+
+type spanKey struct{}
+
+// tracingHook is a Hook creating a span for every operation
+type tracingHook struct {
+	tracer Tracer
+}
+
+// NewTracingHook returns a Hook creating a span named like cache.Get for every operation of HookCache.
+// The span has the attributes of the operation, backend and key count, and the hits and misses when it ends.
+// A miss, see ClassifyError, is recorded as cache.hit false rather than an error.
+// The keys are not recorded because they may be sensitive.
+func NewTracingHook(tracer Tracer) Hook {
+	return tracingHook{tracer: tracer}
+}
+
+func (h tracingHook) Before(ctx context.Context, o Observation) context.Context {
+	ctx, span := h.tracer.Start(ctx, "cache."+o.Operation,
+		Attribute{Key: AttrOperation, Value: o.Operation},
+		Attribute{Key: AttrBackend, Value: o.Backend},
+		Attribute{Key: AttrKeyCount, Value: len(o.Keys)})
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+func (h tracingHook) After(ctx context.Context, o Observation) {
+	span, ok := ctx.Value(spanKey{}).(Span)
+	if !ok {
+		return
+	}
+	switch o.Operation {
+	case OpGet, OpIsExist:
+		if o.Err == nil {
+			span.SetAttributes(Attribute{Key: AttrHit, Value: o.Hits > 0})
+		}
+	case OpGetMulti:
+		span.SetAttributes(Attribute{Key: AttrHits, Value: o.Hits}, Attribute{Key: AttrMisses, Value: o.Misses})
+	}
+	if o.Err != nil {
+		span.RecordError(o.Err)
+	}
+	span.End()
+}
@@ -0,0 +1,98 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+const ttlSweepInterval = time.Minute
+
+type ttlEntry struct {
+	deadline time.Time
+	ttl      time.Duration
+}
+
+// ttlTracker records the deadline and TTL of the values put by a decorator, which Cache can not report.
+// An entry is kept for retention after its deadline, and then dropped when it is read,
+// or by the sweeping every minute, so that the keys never read again don't leak.
+type ttlTracker struct {
+	retention     time.Duration
+	sweepInterval time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]ttlEntry
+	nextSweep time.Time
+}
+
+func newTTLTracker(retention time.Duration) *ttlTracker {
+	return &ttlTracker{
+		retention:     retention,
+		sweepInterval: ttlSweepInterval,
+		entries:       make(map[string]ttlEntry),
+		nextSweep:     time.Now().Add(ttlSweepInterval),
+	}
+}
+
+// set records that key expires after ttl, a non-positive ttl means never expire and is not recorded.
+func (t *ttlTracker) set(key string, ttl time.Duration) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ttl > 0 {
+		t.entries[key] = ttlEntry{deadline: now.Add(ttl), ttl: ttl}
+	} else {
+		delete(t.entries, key)
+	}
+	if now.After(t.nextSweep) {
+		t.nextSweep = now.Add(t.sweepInterval)
+		for k, entry := range t.entries {
+			if now.Sub(entry.deadline) > t.retention {
+				delete(t.entries, k)
+			}
+		}
+	}
+}
+
+// get returns the entry of key, if it is not dropped.
+func (t *ttlTracker) get(key string) (ttlEntry, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry, ok := t.entries[key]
+	if ok && time.Since(entry.deadline) > t.retention {
+		delete(t.entries, key)
+		return ttlEntry{}, false
+	}
+	return entry, ok
+}
+
+func (t *ttlTracker) delete(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, key)
+}
+
+func (t *ttlTracker) clear() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries = make(map[string]ttlEntry)
+}
+
+func (t *ttlTracker) len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.entries)
+}
@@ -0,0 +1,80 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTTLTracker(t *testing.T) {
+	tracker := newTTLTracker(20 * time.Millisecond)
+	tracker.sweepInterval = 10 * time.Millisecond
+	tracker.nextSweep = time.Now()
+
+	tracker.set("key", time.Millisecond)
+	tracker.set("forever", 0)
+	entry, ok := tracker.get("key")
+	require.True(t, ok)
+	assert.Equal(t, time.Millisecond, entry.ttl)
+	_, ok = tracker.get("forever")
+	assert.False(t, ok)
+
+	// kept for the retention after the deadline
+	time.Sleep(10 * time.Millisecond)
+	_, ok = tracker.get("key")
+	assert.True(t, ok)
+	time.Sleep(20 * time.Millisecond)
+	_, ok = tracker.get("key")
+	assert.False(t, ok)
+
+	// the keys never read again are swept
+	for i := 0; i < 100; i++ {
+		tracker.set(fmt.Sprintf("key%d", i), time.Millisecond)
+	}
+	assert.Equal(t, 100, tracker.len())
+	time.Sleep(30 * time.Millisecond)
+	tracker.set("live", time.Minute)
+	assert.Equal(t, 1, tracker.len())
+
+	tracker.delete("live")
+	assert.Equal(t, 0, tracker.len())
+	tracker.set("live", time.Minute)
+	tracker.clear()
+	assert.Equal(t, 0, tracker.len())
+}
+
+func TestReadThroughCache_TTLsSwept(t *testing.T) {
+	c, err := NewReadThroughCache(NewMemoryCache(0), time.Millisecond, func(ctx context.Context, key string) (any, error) {
+		return "value", nil
+	}, WithReadThroughCacheRefreshAhead(0.5))
+	require.NoError(t, err)
+	tracker := c.(*readThroughCache).ttls
+	tracker.sweepInterval = 0
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		_, err = c.Get(ctx, fmt.Sprintf("key%d", i))
+		require.NoError(t, err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	tracker.nextSweep = time.Now()
+	require.NoError(t, c.Put(ctx, "key", "value", time.Minute))
+	assert.Equal(t, 1, tracker.len())
+}
@@ -0,0 +1,325 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	berror "github.com/beego/beego-error/v2"
+)
+
+const (
+	defaultWriteBehindBatchSize     = 100
+	defaultWriteBehindFlushInterval = time.Second
+	defaultWriteBehindMaxRetries    = 3
+	defaultWriteBehindBackoff       = 100 * time.Millisecond
+
+	// writeBehindQueueKey is the key of the pending writes in the queue cache
+	writeBehindQueueKey = "__write_behind_queue__"
+)
+
+// WriteBehindCacheOption configures the WriteBehindCache
+type WriteBehindCacheOption func(w *WriteBehindCache)
+
+// WithWriteBehindCacheBatchSize configures how many keys are pending before flushing, default 100.
+func WithWriteBehindCacheBatchSize(size int) WriteBehindCacheOption {
+	return func(w *WriteBehindCache) {
+		w.batchSize = size
+	}
+}
+
+// WithWriteBehindCacheFlushInterval configures how often the pending writes are flushed, default one second.
+func WithWriteBehindCacheFlushInterval(interval time.Duration) WriteBehindCacheOption {
+	return func(w *WriteBehindCache) {
+		w.flushInterval = interval
+	}
+}
+
+// WithWriteBehindCacheRetry configures how many times a failed flushing is retried, default 3,
+// and the backoff before the first retrying, default 100ms, which is doubled for each retrying.
+func WithWriteBehindCacheRetry(maxRetries int, backoff time.Duration) WriteBehindCacheOption {
+	return func(w *WriteBehindCache) {
+		w.maxRetries = maxRetries
+		w.backoff = backoff
+	}
+}
+
+// WithWriteBehindCacheErrorHandler configures the function to handle the flushing which still fails after retrying,
+// by default errors are ignored. The entries are kept and flushed again later.
+func WithWriteBehindCacheErrorHandler(fn func(entries map[string]any, err error)) WriteBehindCacheOption {
+	return func(w *WriteBehindCache) {
+		w.onError = fn
+	}
+}
+
+// WithWriteBehindCacheQueue keeps the pending writes in queue, usually a FileCache,
+// so that they are flushed after restarting if the process exits before flushing.
+// The whole queue is rewritten for every write, the values should be supported by queue.
+func WithWriteBehindCacheQueue(queue Cache) WriteBehindCacheOption {
+	return func(w *WriteBehindCache) {
+		w.queue = queue
+	}
+}
+
+// WriteBehindCache updates the cache immediately and stores the values later in batches.
+// The repeated writes of a key are coalesced, only the latest value is stored.
+// Close must be called to flush the pending writes.
+type WriteBehindCache struct {
+	Cache
+	storeFunc     func(ctx context.Context, entries map[string]any) error
+	batchSize     int
+	flushInterval time.Duration
+	maxRetries    int
+	backoff       time.Duration
+	onError       func(entries map[string]any, err error)
+	queue         Cache
+
+	mu      sync.Mutex
+	pending map[string]any
+	// the entries being stored, they are kept in the queue until stored
+	flushing map[string]any
+	// the keys deleted while flushing, they are not pending again if the flushing fails
+	deleted map[string]struct{}
+
+	// only one flushing at a time
+	flushSem chan struct{}
+	flushCh  chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+	err      error
+}
+
+// NewWriteBehindCache creates WriteBehindCache and starts flushing in background.
+// The pending writes in the queue are restored if WithWriteBehindCacheQueue is used.
+func NewWriteBehindCache(cache Cache, fn func(ctx context.Context, entries map[string]any) error,
+	opts ...WriteBehindCacheOption,
+) (*WriteBehindCache, error) {
+	if fn == nil || cache == nil {
+		return nil, berror.Error(InvalidInitParameters, "cache or storeFunc can not be nil")
+	}
+	w := &WriteBehindCache{
+		Cache:         cache,
+		storeFunc:     fn,
+		batchSize:     defaultWriteBehindBatchSize,
+		flushInterval: defaultWriteBehindFlushInterval,
+		maxRetries:    defaultWriteBehindMaxRetries,
+		backoff:       defaultWriteBehindBackoff,
+		onError:       func(map[string]any, error) {},
+		pending:       make(map[string]any),
+		flushSem:      make(chan struct{}, 1),
+		flushCh:       make(chan struct{}, 1),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	if w.batchSize <= 0 || w.flushInterval <= 0 || w.maxRetries < 0 {
+		return nil, berror.Errorf(InvalidInitParameters,
+			"invalid batch size %d, flush interval %v or max retries %d", w.batchSize, w.flushInterval, w.maxRetries)
+	}
+	if w.queue != nil {
+		if queued, err := w.queue.Get(context.Background(), writeBehindQueueKey); err == nil {
+			if entries, ok := queued.(map[string]any); ok {
+				w.pending = entries
+			}
+		}
+	}
+
+	go w.loop()
+	return w, nil
+}
+
+// Put puts the value into the cache, and queues it to be stored.
+func (w *WriteBehindCache) Put(ctx context.Context, key string, val any, timeout time.Duration) error {
+	if err := w.Cache.Put(ctx, key, val, timeout); err != nil {
+		return err
+	}
+	return w.enqueue(ctx, key, val)
+}
+
+// Set is Put
+func (w *WriteBehindCache) Set(ctx context.Context, key string, val any, expiration time.Duration) error {
+	return w.Put(ctx, key, val, expiration)
+}
+
+// Delete deletes the value from the cache, and drops its pending write so that it is not stored.
+// The record is not deleted from the store, and a write being stored by a flushing may still be stored.
+func (w *WriteBehindCache) Delete(ctx context.Context, key string) error {
+	if err := w.Cache.Delete(ctx, key); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.pending, key)
+	if w.flushing != nil {
+		w.deleted[key] = struct{}{}
+	}
+	return w.persist(ctx)
+}
+
+// Incr increases the counter in the cache, and queues the new value to be stored.
+func (w *WriteBehindCache) Incr(ctx context.Context, key string) error {
+	if err := w.Cache.Incr(ctx, key); err != nil {
+		return err
+	}
+	return w.enqueueCurrent(ctx, key)
+}
+
+// Decr decreases the counter in the cache, and queues the new value to be stored.
+func (w *WriteBehindCache) Decr(ctx context.Context, key string) error {
+	if err := w.Cache.Decr(ctx, key); err != nil {
+		return err
+	}
+	return w.enqueueCurrent(ctx, key)
+}
+
+// Flush stores the pending writes now.
+func (w *WriteBehindCache) Flush(ctx context.Context) error {
+	return w.flush(ctx)
+}
+
+// Close stops flushing in background and stores all the pending writes.
+func (w *WriteBehindCache) Close() error {
+	w.once.Do(func() {
+		close(w.stop)
+		<-w.done
+		w.err = w.flush(context.Background())
+	})
+	return w.err
+}
+
+func (w *WriteBehindCache) enqueueCurrent(ctx context.Context, key string) error {
+	val, err := w.Cache.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	return w.enqueue(ctx, key, val)
+}
+
+func (w *WriteBehindCache) enqueue(ctx context.Context, key string, val any) error {
+	w.mu.Lock()
+	w.pending[key] = val
+	full := len(w.pending) >= w.batchSize
+	err := w.persist(ctx)
+	w.mu.Unlock()
+	if full {
+		select {
+		case w.flushCh <- struct{}{}:
+		default:
+		}
+	}
+	return err
+}
+
+// persist saves the pending and flushing entries to the queue. The caller must hold w.mu.
+func (w *WriteBehindCache) persist(ctx context.Context) error {
+	if w.queue == nil {
+		return nil
+	}
+	if len(w.pending) == 0 && len(w.flushing) == 0 {
+		return w.queue.Delete(ctx, writeBehindQueueKey)
+	}
+	entries := make(map[string]any, len(w.pending)+len(w.flushing))
+	for k, v := range w.flushing {
+		if _, ok := w.deleted[k]; !ok {
+			entries[k] = v
+		}
+	}
+	for k, v := range w.pending {
+		entries[k] = v
+	}
+	return w.queue.Put(ctx, writeBehindQueueKey, entries, 0)
+}
+
+func (w *WriteBehindCache) loop() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.flushCh:
+		case <-w.stop:
+			return
+		}
+		_ = w.flush(context.Background())
+	}
+}
+
+// flush stores the pending entries with retrying, after the flushing in progress is done,
+// so that the entries it fails to store are flushed again and its result is never missed.
+// The entries failed to be stored are pending again unless they are written again.
+func (w *WriteBehindCache) flush(ctx context.Context) error {
+	select {
+	case w.flushSem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-w.flushSem }()
+
+	w.mu.Lock()
+	if len(w.pending) == 0 {
+		w.mu.Unlock()
+		return nil
+	}
+	entries := w.pending
+	w.flushing, w.pending = entries, make(map[string]any)
+	w.deleted = make(map[string]struct{})
+	w.mu.Unlock()
+
+	err := w.store(ctx, entries)
+
+	w.mu.Lock()
+	deleted := w.deleted
+	w.flushing, w.deleted = nil, nil
+	if err != nil {
+		for k, v := range entries {
+			if _, ok := deleted[k]; ok {
+				continue
+			}
+			if _, ok := w.pending[k]; !ok {
+				w.pending[k] = v
+			}
+		}
+	}
+	perr := w.persist(ctx)
+	w.mu.Unlock()
+
+	if err != nil {
+		// called without holding w.mu, so that it can write the cache
+		w.onError(entries, err)
+		return berror.Wrap(err, PersistCacheFailed, "write behind cache unable to store data")
+	}
+	return perr
+}
+
+func (w *WriteBehindCache) store(ctx context.Context, entries map[string]any) error {
+	backoff := w.backoff
+	err := w.storeFunc(ctx, entries)
+	for i := 0; err != nil && i < w.maxRetries; i++ {
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+		err = w.storeFunc(ctx, entries)
+	}
+	return err
+}
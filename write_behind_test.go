@@ -0,0 +1,252 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	berror "github.com/beego/beego-error/v2"
+)
+
+// mockStore records the batches stored, and fails the first failures calls.
+type mockStore struct {
+	mu       sync.Mutex
+	batches  []map[string]any
+	calls    int
+	failures int
+}
+
+func (s *mockStore) store(ctx context.Context, entries map[string]any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.calls <= s.failures {
+		return errors.New("db is down")
+	}
+	batch := make(map[string]any, len(entries))
+	for k, v := range entries {
+		batch[k] = v
+	}
+	s.batches = append(s.batches, batch)
+	return nil
+}
+
+func (s *mockStore) getBatches() []map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.batches
+}
+
+func TestWriteBehindCache_BatchSize(t *testing.T) {
+	store := &mockStore{}
+	w, err := NewWriteBehindCache(NewMemoryCache(0), store.store,
+		WithWriteBehindCacheBatchSize(2), WithWriteBehindCacheFlushInterval(time.Hour))
+	require.NoError(t, err)
+	defer w.Close()
+	ctx := context.Background()
+
+	require.NoError(t, w.Set(ctx, "key1", "value1", time.Minute))
+	require.NoError(t, w.Set(ctx, "key1", "value2", time.Minute))
+	val, err := w.Get(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, "value2", val)
+	assert.Empty(t, store.getBatches())
+
+	require.NoError(t, w.Set(ctx, "key2", "value", time.Minute))
+	assert.Eventually(t, func() bool {
+		return len(store.getBatches()) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, map[string]any{"key1": "value2", "key2": "value"}, store.getBatches()[0])
+}
+
+func TestWriteBehindCache_Interval(t *testing.T) {
+	store := &mockStore{}
+	w, err := NewWriteBehindCache(NewMemoryCache(0), store.store,
+		WithWriteBehindCacheFlushInterval(20*time.Millisecond))
+	require.NoError(t, err)
+	defer w.Close()
+	ctx := context.Background()
+
+	require.NoError(t, w.Put(ctx, "counter", 1, time.Minute))
+	require.NoError(t, w.Incr(ctx, "counter"))
+	require.NoError(t, w.Incr(ctx, "counter"))
+	require.NoError(t, w.Decr(ctx, "counter"))
+	assert.Eventually(t, func() bool {
+		return len(store.getBatches()) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, map[string]any{"counter": 2}, store.getBatches()[0])
+}
+
+func TestWriteBehindCache_Delete(t *testing.T) {
+	store := &mockStore{}
+	w, err := NewWriteBehindCache(NewMemoryCache(0), store.store, WithWriteBehindCacheFlushInterval(time.Hour))
+	require.NoError(t, err)
+	defer w.Close()
+	ctx := context.Background()
+
+	require.NoError(t, w.Put(ctx, "key1", "value1", time.Minute))
+	require.NoError(t, w.Put(ctx, "key2", "value2", time.Minute))
+	require.NoError(t, w.Delete(ctx, "key1"))
+	exist, err := w.IsExist(ctx, "key1")
+	require.NoError(t, err)
+	assert.False(t, exist)
+	require.NoError(t, w.Flush(ctx))
+	assert.Equal(t, []map[string]any{{"key2": "value2"}}, store.getBatches())
+}
+
+func TestWriteBehindCache_DeleteWhileFlushing(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var calls int
+	w, err := NewWriteBehindCache(NewMemoryCache(0), func(ctx context.Context, entries map[string]any) error {
+		calls++
+		if calls == 1 {
+			close(started)
+			<-release
+			return errors.New("db is down")
+		}
+		return nil
+	}, WithWriteBehindCacheFlushInterval(time.Hour), WithWriteBehindCacheRetry(0, 0))
+	require.NoError(t, err)
+	defer w.Close()
+	ctx := context.Background()
+
+	require.NoError(t, w.Put(ctx, "key", "value", time.Minute))
+	done := make(chan error)
+	go func() {
+		done <- w.Flush(ctx)
+	}()
+	<-started
+	require.NoError(t, w.Delete(ctx, "key"))
+	close(release)
+	assert.Error(t, <-done)
+	// the failed write of the deleted key is not pending again
+	w.mu.Lock()
+	assert.Empty(t, w.pending)
+	w.mu.Unlock()
+}
+
+func TestWriteBehindCache_FlushWhileFlushing(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	store := &mockStore{}
+	var calls int32
+	var w *WriteBehindCache
+	w, err := NewWriteBehindCache(NewMemoryCache(0), func(ctx context.Context, entries map[string]any) error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+			<-release
+			return errors.New("db is down")
+		}
+		return store.store(ctx, entries)
+	}, WithWriteBehindCacheFlushInterval(time.Hour), WithWriteBehindCacheRetry(0, 0),
+		WithWriteBehindCacheErrorHandler(func(entries map[string]any, err error) {
+			// the handler can write the cache
+			assert.NoError(t, w.Put(context.Background(), "failed", len(entries), time.Minute))
+		}))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.NoError(t, w.Put(ctx, "key", "value", time.Minute))
+	first := make(chan error)
+	go func() {
+		first <- w.Flush(ctx)
+	}()
+	<-started
+	second := make(chan error)
+	go func() {
+		second <- w.Flush(ctx)
+	}()
+	close(release)
+	assert.Error(t, <-first)
+	// the second flushing waits for the first one, and stores the entries it fails to store
+	require.NoError(t, <-second)
+	require.NoError(t, w.Close())
+	var stored []string
+	for _, batch := range store.getBatches() {
+		for k := range batch {
+			stored = append(stored, k)
+		}
+	}
+	assert.ElementsMatch(t, []string{"key", "failed"}, stored)
+}
+
+func TestWriteBehindCache_Retry(t *testing.T) {
+	store := &mockStore{failures: 2}
+	var failed []map[string]any
+	w, err := NewWriteBehindCache(NewMemoryCache(0), store.store,
+		WithWriteBehindCacheFlushInterval(time.Hour), WithWriteBehindCacheRetry(2, time.Millisecond),
+		WithWriteBehindCacheErrorHandler(func(entries map[string]any, err error) {
+			failed = append(failed, entries)
+		}))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.NoError(t, w.Set(ctx, "key", "value", time.Minute))
+	require.NoError(t, w.Flush(ctx))
+	assert.Equal(t, []map[string]any{{"key": "value"}}, store.getBatches())
+
+	// still fails after retrying, the entries are kept
+	store.failures = 6
+	require.NoError(t, w.Set(ctx, "key1", "value1", time.Minute))
+	err = w.Flush(ctx)
+	code, _ := berror.FromError(err)
+	assert.Equal(t, PersistCacheFailed.Code(), code.Code())
+	assert.Equal(t, []map[string]any{{"key1": "value1"}}, failed)
+	require.NoError(t, w.Set(ctx, "key2", "value2", time.Minute))
+
+	// flushed on closing
+	require.NoError(t, w.Close())
+	assert.Equal(t, []map[string]any{{"key": "value"}, {"key1": "value1", "key2": "value2"}}, store.getBatches())
+}
+
+func TestWriteBehindCache_Queue(t *testing.T) {
+	queue, err := NewFileCache(FileCacheWithCachePath(t.TempDir()))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	failing := &mockStore{failures: 100}
+	w, err := NewWriteBehindCache(NewMemoryCache(0), failing.store,
+		WithWriteBehindCacheQueue(queue), WithWriteBehindCacheRetry(0, 0))
+	require.NoError(t, err)
+	require.NoError(t, w.Set(ctx, "key1", "value1", time.Minute))
+	require.NoError(t, w.Set(ctx, "key2", "value2", time.Minute))
+	assert.Error(t, w.Close())
+
+	// restored after restarting
+	store := &mockStore{}
+	w, err = NewWriteBehindCache(NewMemoryCache(0), store.store, WithWriteBehindCacheQueue(queue))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	assert.Equal(t, []map[string]any{{"key1": "value1", "key2": "value2"}}, store.getBatches())
+	ok, err := queue.IsExist(ctx, writeBehindQueueKey)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestNewWriteBehindCache(t *testing.T) {
+	_, err := NewWriteBehindCache(NewMemoryCache(0), nil)
+	assert.Error(t, err)
+	_, err = NewWriteBehindCache(NewMemoryCache(0), (&mockStore{}).store, WithWriteBehindCacheBatchSize(0))
+	assert.Error(t, err)
+}
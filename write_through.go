@@ -17,17 +17,65 @@ package cache
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	berror "github.com/beego/beego-error/v2"
 )
 
+// WriteThroughOrder is the order of writing the storage and the cache
+type WriteThroughOrder int
+
+const (
+	// StoreThenCache stores the value first, and then updates the cache.
+	// The cache is invalidated if it fails to be updated.
+	StoreThenCache WriteThroughOrder = iota
+	// InvalidateThenStore deletes the key from the cache first, and then stores the value.
+	// The cache is filled by the next reading, so it never holds a value failed to be stored.
+	// Incr and Decr don't follow it, the counters live in the cache and can not be invalidated.
+	InvalidateThenStore
+)
+
+// WriteThroughCacheOption configures the WriteThroughCache
+type WriteThroughCacheOption func(w *WriteThroughCache)
+
+// WithWriteThroughCacheDeleteFunc configures the function deleting the key from the storage.
+// Without it, Delete only deletes the key from the cache.
+func WithWriteThroughCacheDeleteFunc(fn func(ctx context.Context, key string) error) WriteThroughCacheOption {
+	return func(w *WriteThroughCache) {
+		w.deleteFunc = fn
+	}
+}
+
+// WithWriteThroughCacheStoreMultiFunc configures the function storing a batch of values.
+// Without it, SetMulti calls storeFunc for every key.
+func WithWriteThroughCacheStoreMultiFunc(fn func(ctx context.Context, entries map[string]any) error) WriteThroughCacheOption {
+	return func(w *WriteThroughCache) {
+		w.storeMultiFunc = fn
+	}
+}
+
+// WithWriteThroughCacheOrder configures the order of writing, default StoreThenCache.
+func WithWriteThroughCacheOrder(order WriteThroughOrder) WriteThroughCacheOption {
+	return func(w *WriteThroughCache) {
+		w.order = order
+	}
+}
+
+// WriteThroughCache writes the storage together with the cache.
+// Put, Set, SetMulti, Incr and Decr store the values, and Delete deletes the key from the storage if deleteFunc is set.
 type WriteThroughCache struct {
 	Cache
-	storeFunc func(ctx context.Context, key string, val any) error
+	storeFunc      func(ctx context.Context, key string, val any) error
+	storeMultiFunc func(ctx context.Context, entries map[string]any) error
+	deleteFunc     func(ctx context.Context, key string) error
+	order          WriteThroughOrder
 }
 
-func NewWriteThroughCache(cache Cache, fn func(ctx context.Context, key string, val any) error) (*WriteThroughCache, error) {
+func NewWriteThroughCache(cache Cache, fn func(ctx context.Context, key string, val any) error,
+	opts ...WriteThroughCacheOption,
+) (*WriteThroughCache, error) {
 	if fn == nil || cache == nil {
 		return nil, berror.Error(InvalidInitParameters, "cache or storeFunc can not be nil")
 	}
@@ -36,13 +84,159 @@ func NewWriteThroughCache(cache Cache, fn func(ctx context.Context, key string,
 		Cache:     cache,
 		storeFunc: fn,
 	}
+	for _, opt := range opts {
+		opt(w)
+	}
 	return w, nil
 }
 
+// Set stores the value and writes the cache in the configured order.
 func (w *WriteThroughCache) Set(ctx context.Context, key string, val any, expiration time.Duration) error {
-	err := w.storeFunc(ctx, key, val)
+	if w.order == InvalidateThenStore {
+		if err := w.Cache.Delete(ctx, key); err != nil {
+			return err
+		}
+		return w.store(ctx, key, val)
+	}
+	if err := w.store(ctx, key, val); err != nil {
+		return err
+	}
+	if err := w.Cache.Put(ctx, key, val, expiration); err != nil {
+		// the cached value is older than the stored one
+		_ = w.Cache.Delete(ctx, key)
+		return err
+	}
+	return nil
+}
+
+// Put is the same as Set, so that the Cache interface writes through too.
+func (w *WriteThroughCache) Put(ctx context.Context, key string, val any, timeout time.Duration) error {
+	return w.Set(ctx, key, val, timeout)
+}
+
+// SetMulti stores the values and writes the cache in the configured order.
+// If some values fail to be stored, their keys are deleted from the cache, and the error lists them.
+func (w *WriteThroughCache) SetMulti(ctx context.Context, entries map[string]any, expiration time.Duration) error {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	if w.order == InvalidateThenStore {
+		for _, key := range keys {
+			if err := w.Cache.Delete(ctx, key); err != nil {
+				return err
+			}
+		}
+		_, err := w.storeMulti(ctx, keys, entries)
+		return err
+	}
+
+	stored, err := w.storeMulti(ctx, keys, entries)
+	for _, key := range keys {
+		if !stored[key] {
+			_ = w.Cache.Delete(ctx, key)
+			continue
+		}
+		if er := w.Cache.Put(ctx, key, entries[key], expiration); er != nil {
+			// the cached value is older than the stored one
+			_ = w.Cache.Delete(ctx, key)
+			if err == nil {
+				err = er
+			}
+		}
+	}
+	return err
+}
+
+// Delete deletes the key from the storage and the cache in the configured order.
+func (w *WriteThroughCache) Delete(ctx context.Context, key string) error {
+	if w.deleteFunc == nil {
+		return w.Cache.Delete(ctx, key)
+	}
+	if w.order == InvalidateThenStore {
+		if err := w.Cache.Delete(ctx, key); err != nil {
+			return err
+		}
+		return w.delete(ctx, key)
+	}
+	if err := w.delete(ctx, key); err != nil {
+		return err
+	}
+	return w.Cache.Delete(ctx, key)
+}
+
+// Incr increases the counter in the cache and stores the new value.
+// The counter is decreased back if it fails to be stored.
+func (w *WriteThroughCache) Incr(ctx context.Context, key string) error {
+	return w.count(ctx, key, w.Cache.Incr, w.Cache.Decr)
+}
+
+// Decr decreases the counter in the cache and stores the new value.
+// The counter is increased back if it fails to be stored.
+func (w *WriteThroughCache) Decr(ctx context.Context, key string) error {
+	return w.count(ctx, key, w.Cache.Decr, w.Cache.Incr)
+}
+
+// count changes the counter by op, which is rolled back by undo if the new value fails to be stored.
+// The counter lives in the cache, so it is always updated before storing and kept in the cache,
+// whatever the order is.
+func (w *WriteThroughCache) count(ctx context.Context, key string, op, undo func(context.Context, string) error) error {
+	if err := op(ctx, key); err != nil {
+		return err
+	}
+	val, err := w.Cache.Get(ctx, key)
 	if err != nil {
+		_ = undo(ctx, key)
+		return err
+	}
+	if err = w.store(ctx, key, val); err != nil {
+		if er := undo(ctx, key); er != nil {
+			_ = w.Cache.Delete(ctx, key)
+		}
+		return err
+	}
+	return nil
+}
+
+func (w *WriteThroughCache) store(ctx context.Context, key string, val any) error {
+	if err := w.storeFunc(ctx, key, val); err != nil {
 		return berror.Wrap(err, PersistCacheFailed, fmt.Sprintf("key: %s, val: %v", key, val))
 	}
-	return w.Cache.Put(ctx, key, val, expiration)
+	return nil
+}
+
+// storeMulti stores entries, and returns the stored keys.
+func (w *WriteThroughCache) storeMulti(ctx context.Context, keys []string, entries map[string]any) (map[string]bool, error) {
+	stored := make(map[string]bool, len(keys))
+	if w.storeMultiFunc != nil {
+		if err := w.storeMultiFunc(ctx, entries); err != nil {
+			return stored, berror.Wrap(err, PersistCacheFailed, fmt.Sprintf("keys: %v", keys))
+		}
+		for _, key := range keys {
+			stored[key] = true
+		}
+		return stored, nil
+	}
+
+	keysErr := make([]string, 0)
+	for _, key := range keys {
+		if err := w.storeFunc(ctx, key, entries[key]); err != nil {
+			keysErr = append(keysErr, fmt.Sprintf("key [%s] error: %s", key, err.Error()))
+			continue
+		}
+		stored[key] = true
+	}
+	if len(keysErr) > 0 {
+		return stored, berror.Error(PersistCacheFailed, strings.Join(keysErr, "; "))
+	}
+	return stored, nil
+}
+
+func (w *WriteThroughCache) delete(ctx context.Context, key string) error {
+	if err := w.deleteFunc(ctx, key); err != nil {
+		return berror.Wrap(err, PersistCacheFailed, fmt.Sprintf("delete key: %s", key))
+	}
+	return nil
 }
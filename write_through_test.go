@@ -23,6 +23,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	berror "github.com/beego/beego-error/v2"
 )
@@ -135,3 +136,146 @@ func TestNewWriteThoughCache(t *testing.T) {
 		})
 	}
 }
+
+// mockWriteDB is a storage failing to store the keys in failKeys.
+type mockWriteDB struct {
+	kvs      map[string]any
+	failKeys map[string]bool
+}
+
+func newMockWriteDB(failKeys ...string) *mockWriteDB {
+	db := &mockWriteDB{kvs: make(map[string]any), failKeys: make(map[string]bool)}
+	for _, key := range failKeys {
+		db.failKeys[key] = true
+	}
+	return db
+}
+
+func (db *mockWriteDB) store(ctx context.Context, key string, val any) error {
+	if db.failKeys[key] {
+		return errors.New("failed")
+	}
+	db.kvs[key] = val
+	return nil
+}
+
+func (db *mockWriteDB) delete(ctx context.Context, key string) error {
+	if db.failKeys[key] {
+		return errors.New("failed")
+	}
+	delete(db.kvs, key)
+	return nil
+}
+
+func TestWriteThroughCache_PutAndDelete(t *testing.T) {
+	db := newMockWriteDB("bad")
+	w, err := NewWriteThroughCache(NewMemoryCache(0), db.store, WithWriteThroughCacheDeleteFunc(db.delete))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// through the Cache interface
+	var c Cache = w
+	require.NoError(t, c.Put(ctx, "key", "value", time.Minute))
+	assert.Equal(t, "value", db.kvs["key"])
+	val, err := c.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", val)
+
+	require.NoError(t, c.Delete(ctx, "key"))
+	assert.NotContains(t, db.kvs, "key")
+	ok, _ := c.IsExist(ctx, "key")
+	assert.False(t, ok)
+
+	// the cache is kept if the storage fails
+	require.NoError(t, w.Cache.Put(ctx, "bad", "value", time.Minute))
+	err = c.Delete(ctx, "bad")
+	code, _ := berror.FromError(err)
+	assert.Equal(t, PersistCacheFailed.Code(), code.Code())
+	ok, _ = c.IsExist(ctx, "bad")
+	assert.True(t, ok)
+}
+
+func TestWriteThroughCache_InvalidateThenStore(t *testing.T) {
+	db := newMockWriteDB("bad")
+	w, err := NewWriteThroughCache(NewMemoryCache(0), db.store,
+		WithWriteThroughCacheDeleteFunc(db.delete), WithWriteThroughCacheOrder(InvalidateThenStore))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.NoError(t, w.Cache.Put(ctx, "key", "old", time.Minute))
+	require.NoError(t, w.Put(ctx, "key", "new", time.Minute))
+	assert.Equal(t, "new", db.kvs["key"])
+	ok, _ := w.IsExist(ctx, "key")
+	assert.False(t, ok)
+
+	// the cache never holds the value failed to be stored
+	require.NoError(t, w.Cache.Put(ctx, "bad", "old", time.Minute))
+	assert.Error(t, w.Set(ctx, "bad", "new", time.Minute))
+	ok, _ = w.IsExist(ctx, "bad")
+	assert.False(t, ok)
+
+	// the counters stay in the cache
+	require.NoError(t, w.Cache.Put(ctx, "counter", 1, time.Minute))
+	require.NoError(t, w.Incr(ctx, "counter"))
+	assert.Equal(t, 2, db.kvs["counter"])
+	require.NoError(t, w.Incr(ctx, "counter"))
+	assert.Equal(t, 3, db.kvs["counter"])
+	val, err := w.Get(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, 3, val)
+
+	db.failKeys["counter"] = true
+	assert.Error(t, w.Decr(ctx, "counter"))
+	val, err = w.Get(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, 3, val)
+}
+
+func TestWriteThroughCache_IncrAndDecr(t *testing.T) {
+	db := newMockWriteDB()
+	w, err := NewWriteThroughCache(NewMemoryCache(0), db.store)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.NoError(t, w.Put(ctx, "counter", 1, time.Minute))
+	require.NoError(t, w.Incr(ctx, "counter"))
+	assert.Equal(t, 2, db.kvs["counter"])
+	require.NoError(t, w.Decr(ctx, "counter"))
+	assert.Equal(t, 1, db.kvs["counter"])
+
+	// rolled back if the storage fails
+	db.failKeys["counter"] = true
+	assert.Error(t, w.Incr(ctx, "counter"))
+	val, err := w.Get(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, 1, val)
+}
+
+func TestWriteThroughCache_SetMulti(t *testing.T) {
+	db := newMockWriteDB("bad")
+	w, err := NewWriteThroughCache(NewMemoryCache(0), db.store)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.NoError(t, w.Cache.Put(ctx, "bad", "old", time.Minute))
+	err = w.SetMulti(ctx, map[string]any{"key1": "value1", "key2": "value2", "bad": "new"}, time.Minute)
+	code, _ := berror.FromError(err)
+	assert.Equal(t, PersistCacheFailed.Code(), code.Code())
+	assert.Contains(t, err.Error(), "key [bad]")
+	assert.Equal(t, map[string]any{"key1": "value1", "key2": "value2"}, db.kvs)
+	vals, _ := w.GetMulti(ctx, []string{"key1", "key2", "bad"})
+	assert.Equal(t, []any{"value1", "value2", nil}, vals)
+
+	var batches []map[string]any
+	w, err = NewWriteThroughCache(NewMemoryCache(0), db.store,
+		WithWriteThroughCacheStoreMultiFunc(func(ctx context.Context, entries map[string]any) error {
+			batches = append(batches, entries)
+			return nil
+		}))
+	require.NoError(t, err)
+	require.NoError(t, w.SetMulti(ctx, map[string]any{"key1": "value1", "key2": "value2"}, time.Minute))
+	assert.Equal(t, []map[string]any{{"key1": "value1", "key2": "value2"}}, batches)
+	vals, err = w.GetMulti(ctx, []string{"key1", "key2"})
+	require.NoError(t, err)
+	assert.Equal(t, []any{"value1", "value2"}, vals)
+}
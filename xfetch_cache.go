@@ -0,0 +1,200 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	berror "github.com/beego/beego-error/v2"
+)
+
+const defaultXFetchBeta = 1.0
+
+// XFetchCacheOption configures the XFetchCache
+type XFetchCacheOption func(c *XFetchCache)
+
+// WithXFetchCacheBeta configures how early the values are recomputed, default 1.
+// The values are recomputed earlier if beta is greater than 1, and later if it is less than 1.
+func WithXFetchCacheBeta(beta float64) XFetchCacheOption {
+	return func(c *XFetchCache) {
+		c.beta = beta
+	}
+}
+
+// WithXFetchCacheSeed configures the seed of the random source, so that the recomputing is reproducible.
+// By default the current time is used.
+func WithXFetchCacheSeed(seed int64) XFetchCacheOption {
+	return func(c *XFetchCache) {
+		c.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+// xfetchMetaPrefix is the prefix of the keys keeping the delta and expiry of the values
+const xfetchMetaPrefix = "__xfetch__:"
+
+// XFetchCache is a read through decorator implementing the XFetch algorithm,
+// or the probabilistic early expiration, to avoid the stampede of a hot key at its expiry.
+//
+// The time loadFunc takes, called delta, and the expiry of the loaded value are stored
+// as a string under the key __xfetch__:key next to the value, which is stored as it is,
+// so that all the instances sharing the cache know them, whatever the adapter is.
+// Get reads both in one GetMulti, and recomputes a value before its expiry
+// when now - delta * beta * ln(rand()) >= expiry,
+// so the slower the loading and the closer to the expiry, the more likely a Get recomputes it.
+// The concurrent recomputing of a key is merged into one loading.
+//
+// The values put by Put have no delta and are never recomputed early.
+type XFetchCache struct {
+	Cache
+	expiration time.Duration
+	beta       float64
+	loadFunc   func(ctx context.Context, key string) (any, error)
+	group      singleflight.Group
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NewXFetchCache creates XFetchCache, expiration is the TTL of the loaded values
+func NewXFetchCache(c Cache, expiration time.Duration,
+	loadFunc func(ctx context.Context, key string) (any, error), opts ...XFetchCacheOption,
+) (*XFetchCache, error) {
+	if loadFunc == nil {
+		return nil, berror.Error(InvalidLoadFunc, "loadFunc cannot be nil")
+	}
+	res := &XFetchCache{
+		Cache:      c,
+		expiration: expiration,
+		beta:       defaultXFetchBeta,
+		loadFunc:   loadFunc,
+		rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	if res.beta <= 0 {
+		return nil, berror.Errorf(InvalidInitParameters, "beta should be positive, but got %v", res.beta)
+	}
+	return res, nil
+}
+
+// Get loads the missed key, and recomputes the value if it is expired early.
+// The current value is returned if the recomputing fails.
+func (c *XFetchCache) Get(ctx context.Context, key string) (any, error) {
+	// the misses are reported by nil values, the errors of the misses are ignored
+	vals, _ := c.Cache.GetMulti(ctx, []string{key, xfetchMetaPrefix + key})
+	if len(vals) != 2 || vals[0] == nil {
+		return c.load(ctx, key)
+	}
+	val := vals[0]
+	delta, expiry, ok := parseXFetchMeta(vals[1])
+	if !ok || !c.expiredEarly(delta, expiry) {
+		return val, nil
+	}
+	if loaded, err := c.load(ctx, key); err == nil {
+		return loaded, nil
+	}
+	return val, nil
+}
+
+// Put puts the value, which is never recomputed early because its delta is unknown.
+func (c *XFetchCache) Put(ctx context.Context, key string, val any, timeout time.Duration) error {
+	if err := c.Cache.Put(ctx, key, val, timeout); err != nil {
+		return err
+	}
+	return c.deleteMeta(ctx, key)
+}
+
+// Delete deletes the value with its delta and expiry.
+func (c *XFetchCache) Delete(ctx context.Context, key string) error {
+	if err := c.Cache.Delete(ctx, key); err != nil {
+		return err
+	}
+	return c.deleteMeta(ctx, key)
+}
+
+func (c *XFetchCache) deleteMeta(ctx context.Context, key string) error {
+	if ok, err := c.Cache.IsExist(ctx, xfetchMetaPrefix+key); err != nil || !ok {
+		return err
+	}
+	return c.Cache.Delete(ctx, xfetchMetaPrefix+key)
+}
+
+// expiredEarly reports whether the value loaded in delta and expiring at expiry should be recomputed now.
+func (c *XFetchCache) expiredEarly(delta time.Duration, expiry time.Time) bool {
+	c.mu.Lock()
+	// rand in (0, 1], so the gap is never infinite
+	gap := -float64(delta) * c.beta * math.Log(1-c.rand.Float64())
+	c.mu.Unlock()
+	return !time.Now().Add(time.Duration(gap)).Before(expiry)
+}
+
+// load loads key and puts it with its delta and expiry, the concurrent loadings of a key are merged.
+func (c *XFetchCache) load(ctx context.Context, key string) (any, error) {
+	val, err, _ := c.group.Do(key, func() (any, error) {
+		start := time.Now()
+		v, er := c.loadFunc(ctx, key)
+		if er != nil {
+			return nil, berror.Wrap(er, LoadFuncFailed, "cache unable to load data")
+		}
+		if er = c.Cache.Put(ctx, key, v, c.expiration); er != nil || c.expiration <= 0 {
+			// a value never expiring is never recomputed
+			return v, er
+		}
+		now := time.Now()
+		meta := formatXFetchMeta(now.Sub(start), now.Add(c.expiration))
+		return v, c.Cache.Put(ctx, xfetchMetaPrefix+key, meta, c.expiration)
+	})
+	return val, err
+}
+
+// formatXFetchMeta formats delta and expiry as "delta:expiry" in nanoseconds, which every adapter can store.
+func formatXFetchMeta(delta time.Duration, expiry time.Time) string {
+	return strconv.FormatInt(int64(delta), 10) + ":" + strconv.FormatInt(expiry.UnixNano(), 10)
+}
+
+// parseXFetchMeta parses the delta and expiry formatted by formatXFetchMeta, read as string or []byte.
+func parseXFetchMeta(meta any) (time.Duration, time.Time, bool) {
+	var str string
+	switch v := meta.(type) {
+	case string:
+		str = v
+	case []byte:
+		str = string(v)
+	default:
+		return 0, time.Time{}, false
+	}
+	deltaStr, expiryStr, ok := strings.Cut(str, ":")
+	if !ok {
+		return 0, time.Time{}, false
+	}
+	delta, err := strconv.ParseInt(deltaStr, 10, 64)
+	if err != nil {
+		return 0, time.Time{}, false
+	}
+	expiry, err := strconv.ParseInt(expiryStr, 10, 64)
+	if err != nil {
+		return 0, time.Time{}, false
+	}
+	return time.Duration(delta), time.Unix(0, expiry), true
+}
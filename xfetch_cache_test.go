@@ -0,0 +1,148 @@
+// Copyright 2014 beego Author. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestXFetchCache_Get(t *testing.T) {
+	ctx := context.Background()
+	var loads int32
+	var failing atomic.Value
+	failing.Store(false)
+	c, err := NewXFetchCache(NewMemoryCache(0), time.Second, func(ctx context.Context, key string) (any, error) {
+		n := atomic.AddInt32(&loads, 1)
+		time.Sleep(20 * time.Millisecond)
+		if failing.Load().(bool) {
+			return nil, errors.New("db is down")
+		}
+		return n, nil
+	}, WithXFetchCacheBeta(10), WithXFetchCacheSeed(1))
+	require.NoError(t, err)
+
+	val, err := c.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), val)
+	// far from the expiry, delta * beta is 200ms
+	val, err = c.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), val)
+
+	// close to the expiry, the concurrent Get recompute it once
+	time.Sleep(900 * time.Millisecond)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.Get(ctx, "key")
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, atomic.LoadInt32(&loads), int32(2))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&loads))
+	val, err = c.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), val)
+
+	// the current value is returned if the recomputing fails
+	failing.Store(true)
+	time.Sleep(800 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		val, err = c.Get(ctx, "key")
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), val)
+	}
+	assert.Greater(t, atomic.LoadInt32(&loads), int32(2))
+}
+
+func TestXFetchCache_Put(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewXFetchCache(NewMemoryCache(0), time.Second, func(ctx context.Context, key string) (any, error) {
+		return "loaded", nil
+	}, WithXFetchCacheBeta(1e9))
+	require.NoError(t, err)
+
+	// delta is unknown, never recomputed
+	require.NoError(t, c.Put(ctx, "key", "value", time.Minute))
+	val, err := c.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", val)
+
+	// recomputed at once with a huge beta
+	require.NoError(t, c.Delete(ctx, "key"))
+	val, err = c.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "loaded", val)
+	// the value is stored as it is for the other readers
+	val, err = c.Cache.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "loaded", val)
+
+	// Put drops the delta
+	require.NoError(t, c.Put(ctx, "key", "value", time.Minute))
+	val, err = c.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", val)
+
+	// Delete deletes the delta too
+	_, err = c.Get(ctx, "other")
+	require.NoError(t, err)
+	require.NoError(t, c.Delete(ctx, "other"))
+	ok, err := c.Cache.IsExist(ctx, xfetchMetaPrefix+"other")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestXFetchCache_Shared(t *testing.T) {
+	ctx := context.Background()
+	shared := NewMemoryCache(0)
+	newCache := func(loaded string) *XFetchCache {
+		c, err := NewXFetchCache(shared, time.Minute, func(ctx context.Context, key string) (any, error) {
+			time.Sleep(time.Millisecond)
+			return loaded, nil
+		}, WithXFetchCacheBeta(1e12))
+		require.NoError(t, err)
+		return c
+	}
+	c1, c2 := newCache("value1"), newCache("value2")
+
+	// the delta of the value loaded by c1 is known by c2
+	val, err := c1.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "value1", val)
+	val, err = c2.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "value2", val)
+}
+
+func TestNewXFetchCache(t *testing.T) {
+	_, err := NewXFetchCache(NewMemoryCache(0), time.Minute, nil)
+	assert.Error(t, err)
+	_, err = NewXFetchCache(NewMemoryCache(0), time.Minute, func(ctx context.Context, key string) (any, error) {
+		return nil, nil
+	}, WithXFetchCacheBeta(0))
+	assert.Error(t, err)
+}